@@ -5,5 +5,14 @@ CREATE TABLE IF NOT EXISTS workflows (
     workflowtemplate VARCHAR(255) NOT NULL,
     namespace VARCHAR(255) NOT NULL,
     cluster VARCHAR(255) NOT NULL,
+    balancer VARCHAR(255),
+    deleted_at TIMESTAMP,
+    cpu_total DOUBLE PRECISION,
+    mem_total DOUBLE PRECISION,
+    scout_free_cpu DOUBLE PRECISION,
+    scout_free_mem DOUBLE PRECISION,
+    cost_center VARCHAR(255),
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
+
+CREATE UNIQUE INDEX IF NOT EXISTS workflows_workflowname_namespace ON workflows (workflowname, namespace);
@@ -0,0 +1,62 @@
+// Package placement is the placement constraints block of a submit, validated by medea-balancer
+// and applied by medea-scout
+package placement
+
+import (
+	"fmt"
+	"math"
+	"slices"
+)
+
+// Priorities, PriorityNormal is assumed when none is given
+const (
+	// PriorityNormal placements go through the canary gate like any other
+	PriorityNormal = "normal"
+	// PriorityHigh placements never land on the canary cluster: not through a placement token,
+	// not as the preferred cluster, and not when the canary is the only cluster that fits
+	PriorityHigh = "high"
+)
+
+// Constraints hold what a client may require of the cluster its submit runs on
+type Constraints struct {
+	// ClusterClass restricts placement to clusters of this class (MEDEA_SCOUT_CLUSTER_CLASSES)
+	ClusterClass string `json:"clusterClass,omitempty"`
+	// Exclude lists clusters that must not be used
+	Exclude []string `json:"exclude,omitempty"`
+	// Preferred is picked when it fits, like preferredCluster
+	Preferred string `json:"preferred,omitempty"`
+	// MinFreeCPU and MinFreeRAM are the cores and memory that must stay free after placement,
+	// memory in the unit of the submit (MEDEA_MEMORY_UNIT) or of the scout request (ramUnit)
+	MinFreeCPU float64 `json:"minFreeCpu,omitempty"`
+	MinFreeRAM float64 `json:"minFreeRam,omitempty"`
+	Priority   string  `json:"priority,omitempty"`
+}
+
+// High reports whether the constraints ask for high priority. A nil *Constraints is normal.
+func (c *Constraints) High() bool {
+	return c != nil && c.Priority == PriorityHigh
+}
+
+// Validate checks the block of a submit whose top-level preferredCluster is preferred
+func (c *Constraints) Validate(preferred string) error {
+	if slices.Contains(c.Exclude, "") {
+		return fmt.Errorf("placement.exclude must not contain empty cluster names")
+	}
+	if c.Preferred != "" && preferred != "" && c.Preferred != preferred {
+		return fmt.Errorf("placement.preferred and preferredCluster disagree")
+	}
+	if c.Preferred != "" && slices.Contains(c.Exclude, c.Preferred) {
+		return fmt.Errorf("placement.preferred %s is also excluded", c.Preferred)
+	}
+	for name, v := range map[string]float64{"minFreeCpu": c.MinFreeCPU, "minFreeRam": c.MinFreeRAM} {
+		if v < 0 || math.IsInf(v, 0) || math.IsNaN(v) {
+			return fmt.Errorf("placement.%s must be a non-negative number", name)
+		}
+	}
+	switch c.Priority {
+	case "", PriorityNormal, PriorityHigh:
+	default:
+		return fmt.Errorf("placement.priority must be %q or %q", PriorityNormal, PriorityHigh)
+	}
+	return nil
+}
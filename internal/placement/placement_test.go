@@ -0,0 +1,41 @@
+package placement
+
+import (
+	"math"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		c         Constraints
+		preferred string // top-level preferredCluster of the submit
+		wantErr   bool
+	}{
+		{"empty", Constraints{}, "", false},
+		{"all fields", Constraints{ClusterClass: "gpu", Exclude: []string{"a"}, Preferred: "b", MinFreeCPU: 1, MinFreeRAM: 2, Priority: PriorityHigh}, "b", false},
+		{"normal priority", Constraints{Priority: PriorityNormal}, "", false},
+		{"unknown priority", Constraints{Priority: "urgent"}, "", true},
+		{"empty exclusion", Constraints{Exclude: []string{""}}, "", true},
+		{"preferred disagrees", Constraints{Preferred: "a"}, "b", true},
+		{"preferred excluded", Constraints{Preferred: "a", Exclude: []string{"a"}}, "", true},
+		{"negative headroom", Constraints{MinFreeCPU: -1}, "", true},
+		{"infinite headroom", Constraints{MinFreeRAM: math.Inf(1)}, "", true},
+		{"NaN headroom", Constraints{MinFreeRAM: math.NaN()}, "", true},
+	}
+	for _, tt := range tests {
+		if err := tt.c.Validate(tt.preferred); (err != nil) != tt.wantErr {
+			t.Errorf("%s: Validate() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestHigh(t *testing.T) {
+	var none *Constraints
+	if none.High() || (&Constraints{}).High() || (&Constraints{Priority: PriorityNormal}).High() {
+		t.Error("High() reported a normal priority as high")
+	}
+	if !(&Constraints{Priority: PriorityHigh}).High() {
+		t.Error("High() missed a high priority")
+	}
+}
@@ -0,0 +1,115 @@
+// Package registry is the canonical list of clusters shared by medea-balancer and medea-scout,
+// read from MEDEA_CLUSTER_REGISTRY
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Modes for clusters missing from the registry, set by MEDEA_CLUSTER_REGISTRY_MODE
+const (
+	// ModeWarn logs unknown clusters and handles them as before
+	ModeWarn = "warn"
+	// ModeReject refuses unknown clusters at startup and at runtime
+	ModeReject = "reject"
+)
+
+// Cluster is one registered cluster. Name is what Prometheus reports in the cluster label,
+// URL where the balancer sends its requests; both identify the cluster.
+type Cluster struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Region string `json:"region,omitempty"`
+	Class  string `json:"class,omitempty"`
+}
+
+// Registry looks clusters up by name or URL. A nil *Registry is valid and knows no cluster.
+type Registry struct {
+	Mode     string
+	clusters []Cluster
+	byKey    map[string]int
+	// warned holds the unknown clusters already reported by FirstSighting
+	warned sync.Map
+}
+
+// Parse reads a JSON array of clusters; names and URLs are required and must be unique
+func Parse(data, mode string) (*Registry, error) {
+	switch mode {
+	case "":
+		mode = ModeWarn
+	case ModeWarn, ModeReject:
+	default:
+		return nil, fmt.Errorf("invalid mode %q, expected warn or reject", mode)
+	}
+	var clusters []Cluster
+	if err := json.Unmarshal([]byte(data), &clusters); err != nil {
+		return nil, err
+	}
+	r := &Registry{Mode: mode, clusters: clusters, byKey: make(map[string]int)}
+	for i, c := range clusters {
+		if c.Name == "" || c.URL == "" {
+			return nil, fmt.Errorf("cluster %d: name and url are required", i)
+		}
+		for _, key := range []string{c.Name, key(c.URL)} {
+			if j, ok := r.byKey[key]; ok && j != i {
+				return nil, fmt.Errorf("cluster %d: %s is already registered", i, key)
+			}
+			r.byKey[key] = i
+		}
+	}
+	return r, nil
+}
+
+// key makes URLs with and without a trailing slash match
+func key(cluster string) string {
+	return strings.TrimSuffix(cluster, "/")
+}
+
+// Enabled reports whether a registry is configured
+func (r *Registry) Enabled() bool {
+	return r != nil
+}
+
+// Lookup finds a cluster by name or URL
+func (r *Registry) Lookup(cluster string) (Cluster, bool) {
+	if r == nil {
+		return Cluster{}, false
+	}
+	i, ok := r.byKey[key(cluster)]
+	if !ok {
+		return Cluster{}, false
+	}
+	return r.clusters[i], true
+}
+
+// Unknown returns the clusters, by name or URL, that are not registered
+func (r *Registry) Unknown(clusters ...string) []string {
+	if r == nil {
+		return nil
+	}
+	var unknown []string
+	for _, c := range clusters {
+		if _, ok := r.Lookup(c); !ok {
+			unknown = append(unknown, c)
+		}
+	}
+	return unknown
+}
+
+// FirstSighting reports whether an unknown cluster is seen for the first time, so that
+// warnings about it are logged once rather than for every request
+func (r *Registry) FirstSighting(cluster string) bool {
+	_, seen := r.warned.LoadOrStore(key(cluster), true)
+	return !seen
+}
+
+// Clusters returns the registered clusters in configuration order
+func (r *Registry) Clusters() []Cluster {
+	if r == nil {
+		return nil
+	}
+	return r.clusters
+}
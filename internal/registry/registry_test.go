@@ -0,0 +1,92 @@
+package registry
+
+import (
+	"slices"
+	"testing"
+)
+
+const testClusters = `[
+	{"name": "east", "url": "http://argowf1:8080", "region": "eu-east"},
+	{"name": "west", "url": "http://argowf2:8080/", "class": "gpu"}
+]`
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		mode     string
+		wantMode string
+		wantErr  bool
+	}{
+		{"default mode", testClusters, "", ModeWarn, false},
+		{"reject mode", testClusters, ModeReject, ModeReject, false},
+		{"unknown mode", testClusters, "ignore", "", true},
+		{"not JSON", `east=http://argowf1:8080`, "", "", true},
+		{"missing url", `[{"name": "east"}]`, "", "", true},
+		{"missing name", `[{"url": "http://argowf1:8080"}]`, "", "", true},
+		{"duplicate name", `[{"name": "east", "url": "http://a"}, {"name": "east", "url": "http://b"}]`, "", "", true},
+		{"duplicate url", `[{"name": "east", "url": "http://a"}, {"name": "west", "url": "http://a/"}]`, "", "", true},
+		{"name of another cluster's url", `[{"name": "east", "url": "http://a"}, {"name": "http://a", "url": "http://b"}]`, "", "", true},
+	}
+	for _, tt := range tests {
+		r, err := Parse(tt.data, tt.mode)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && r.Mode != tt.wantMode {
+			t.Errorf("%s: mode = %q, want %q", tt.name, r.Mode, tt.wantMode)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	r, err := Parse(testClusters, ModeReject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		cluster  string
+		wantName string
+		wantOK   bool
+	}{
+		{"east", "east", true},
+		{"http://argowf1:8080", "east", true},
+		{"http://argowf1:8080/", "east", true},
+		{"http://argowf2:8080", "west", true},
+		{"north", "", false},
+		{"http://argowf3:8080", "", false},
+	}
+	for _, tt := range tests {
+		c, ok := r.Lookup(tt.cluster)
+		if ok != tt.wantOK || c.Name != tt.wantName {
+			t.Errorf("Lookup(%q) = %q, %v, want %q, %v", tt.cluster, c.Name, ok, tt.wantName, tt.wantOK)
+		}
+	}
+
+	if got := r.Unknown("east", "north", "http://argowf2:8080", "http://argowf3:8080"); !slices.Equal(got, []string{"north", "http://argowf3:8080"}) {
+		t.Errorf("Unknown = %q, want the unregistered north and argowf3", got)
+	}
+}
+
+func TestNilRegistry(t *testing.T) {
+	var r *Registry
+	if r.Enabled() {
+		t.Error("nil registry is enabled")
+	}
+	if _, ok := r.Lookup("east"); ok {
+		t.Error("nil registry knows a cluster")
+	}
+	if got := r.Unknown("east"); got != nil {
+		t.Errorf("nil registry reports unknown clusters %q", got)
+	}
+}
+
+func TestFirstSighting(t *testing.T) {
+	r, _ := Parse(testClusters, ModeWarn)
+	for i, want := range []bool{true, false, false} {
+		if got := r.FirstSighting("http://argowf3:8080" + []string{"", "/", ""}[i]); got != want {
+			t.Errorf("sighting %d = %v, want %v", i+1, got, want)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// requireAdmin only lets requests with the MEDEA_ADMIN_TOKEN in X-Medea-Admin-Token through.
+// Without a configured token admin endpoints are disabled.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func isAdmin(r *http.Request) bool {
+	cfg := configFrom(r.Context())
+	token := r.Header.Get("X-Medea-Admin-Token")
+	return cfg.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(cfg.AdminToken)) == 1
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditRecord is one line of the audit log
+type AuditRecord struct {
+	Time      time.Time `json:"time"`
+	Action    string    `json:"action"`
+	Namespace string    `json:"namespace"`
+	Workflow  string    `json:"workflow,omitempty"`
+	Template  string    `json:"template,omitempty"`
+	Cluster   string    `json:"cluster,omitempty"`
+	Tuz       string    `json:"tuz,omitempty"`
+	Status    int       `json:"status"`
+	CPU       float64   `json:"cpu,omitempty"`
+	RAM       float64   `json:"ram,omitempty"`
+	Balancer  string    `json:"balancer,omitempty"`
+	// Params are the submitted parameters and ResolvedParams the values the totals were computed
+	// from, with MEDEA_AUDIT_PARAMS=true
+	Params         []string           `json:"params,omitempty"`
+	ResolvedParams map[string]float64 `json:"resolvedParams,omitempty"`
+}
+
+// auditLogger appends JSON lines to a file and rotates it once it grows past maxSize.
+// A nil *auditLogger is valid and discards everything.
+type auditLogger struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	f       *os.File
+	size    int64
+}
+
+// Global audit log, nil when disabled
+var audit *auditLogger
+
+func openAuditLog(path string, maxSize int64) (*auditLogger, error) {
+	a := &auditLogger{path: path, maxSize: maxSize}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *auditLogger) open() error {
+	a.f = nil
+	f, err := os.OpenFile(a.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	a.f, a.size = f, st.Size()
+	return nil
+}
+
+// rotate moves the current file aside with a timestamp suffix, old files are never overwritten
+func (a *auditLogger) rotate() error {
+	a.f.Close()
+	rotated := fmt.Sprintf("%s.%s", a.path, time.Now().UTC().Format("20060102T150405.000000000"))
+	renameErr := os.Rename(a.path, rotated)
+	// Reopen in any case so that records keep being written
+	if err := a.open(); err != nil {
+		return err
+	}
+	return renameErr
+}
+
+// Log appends rec to the audit log; failures are logged and never fail the request
+func (a *auditLogger) Log(ctx context.Context, rec AuditRecord) {
+	if a == nil {
+		return
+	}
+	rec.Time = time.Now().UTC()
+	rec.Balancer = currentConfig.Load().InstanceID
+	line, err := json.Marshal(rec)
+	if err != nil {
+		slog.ErrorContext(ctx, "Encoding audit record failed", "error", err)
+		return
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.maxSize > 0 && a.size > 0 && a.size+int64(len(line)) > a.maxSize {
+		if err := a.rotate(); err != nil {
+			slog.ErrorContext(ctx, "Rotating audit log failed", "error", err)
+			if a.f == nil {
+				return
+			}
+		}
+	}
+	n, err := a.f.Write(line)
+	a.size += int64(n)
+	if err != nil {
+		slog.ErrorContext(ctx, "Writing audit log failed", "error", err)
+	}
+}
+
+func (a *auditLogger) Close() error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.f.Close()
+}
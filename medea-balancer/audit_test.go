@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditResolvedParams(t *testing.T) {
+	argo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"kind": "Workflow", "metadata": {"name": "wf-1"}}`))
+	}))
+	defer argo.Close()
+	scout := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"cluster": %q}`, argo.URL)
+	}))
+	defer scout.Close()
+	store = &recordingStore{}
+	defer func() { store = nil }()
+
+	params := []string{"executor_num=2", "executor_cores_limit=1500m", "executor_memory_limit=2Gi", "driver_memory_limit=512Mi", "app_name=etl"}
+	tests := []struct {
+		name         string
+		auditParams  bool
+		wantParams   []string
+		wantResolved map[string]float64
+	}{
+		{"with MEDEA_AUDIT_PARAMS", true, params, map[string]float64{
+			// Memory in MEDEA_MEMORY_UNIT, parameters that weren't sent count as 0
+			"executor_num": 2, "executor_cores_limit": 1.5, "driver_cores_limit": 0,
+			"executor_memory_limit": 2048, "driver_memory_limit": 512,
+		}},
+		{"without", false, nil, nil},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "audit.log")
+		var err error
+		if audit, err = openAuditLog(path, 0); err != nil {
+			t.Fatal(err)
+		}
+		cfg := &Config{
+			APIVersion: "1", APIVersions: []string{"1"}, ResourceKinds: defaultResourceKinds, MemoryUnit: memoryMiB,
+			ProxyTimeout: 5 * time.Second, AuditParams: tt.auditParams,
+		}
+		currentConfig.Store(cfg)
+		body, _ := json.Marshal(map[string]any{"resourceKind": "WorkflowTemplate", "resourceName": "tpl", "submitOptions": map[string]any{"parameters": params}})
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/workflows/batch-a/submit", strings.NewReader(string(body)))
+		r.SetPathValue("namespace", "batch-a")
+		r = r.WithContext(context.WithValue(r.Context(), configKey{}, cfg))
+		w := httptest.NewRecorder()
+		handleSubmit(w, r, scout.URL)
+		audit.Close()
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: submit answered %d %q", tt.name, w.Code, w.Body.String())
+		}
+
+		recs := readAudit(t, path)
+		if len(recs) != 1 {
+			t.Fatalf("%s: %d audit records, want 1", tt.name, len(recs))
+		}
+		rec := recs[0]
+		if !slices.Equal(rec.Params, tt.wantParams) {
+			t.Errorf("%s: params %v, want %v", tt.name, rec.Params, tt.wantParams)
+		}
+		if !maps.Equal(rec.ResolvedParams, tt.wantResolved) {
+			t.Errorf("%s: resolved params %v, want %v", tt.name, rec.ResolvedParams, tt.wantResolved)
+		}
+		// The totals are computed from the resolved values: 2*1.5 cores, 2*2048+512 MiB
+		if rec.CPU != 3 || rec.RAM != 4608 {
+			t.Errorf("%s: totals %v cores, %v MiB, want 3 and 4608", tt.name, rec.CPU, rec.RAM)
+		}
+	}
+	audit = nil
+	currentConfig.Store(nil)
+}
+
+// deleteStore places every workflow on one cluster and accepts deletes
+type deleteStore struct {
+	clusterStore
+}
+
+func (s deleteStore) MarkDeleted(wfName, ns string) error { return nil }
+
+// readAudit returns the records of an audit log file
+func readAudit(t *testing.T, path string) []AuditRecord {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var recs []AuditRecord
+	for sc := bufio.NewScanner(f); sc.Scan(); {
+		var rec AuditRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatal(err)
+		}
+		recs = append(recs, rec)
+	}
+	return recs
+}
+
+func TestAuditLifecycleActions(t *testing.T) {
+	argo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer argo.Close()
+	store = deleteStore{clusterStore{cluster: argo.URL}}
+	currentConfig.Store(&Config{ProxyTimeout: 5 * time.Second, InstanceID: "balancer-1"})
+	path := filepath.Join(t.TempDir(), "audit.log")
+	var err error
+	if audit, err = openAuditLog(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		audit.Close()
+		audit, store = nil, nil
+		currentConfig.Store(nil)
+	}()
+
+	for _, req := range []struct{ method, target string }{
+		{http.MethodGet, "/api/v1/workflows/batch-a/wf-1"},
+		{http.MethodPut, "/api/v1/workflows/batch-a/wf-1/stop"},
+		{http.MethodDelete, "/api/v1/workflows/batch-a/wf-1"},
+	} {
+		r := httptest.NewRequest(req.method, req.target, nil)
+		r.SetPathValue("namespace", "batch-a")
+		r.SetPathValue("workflowName", "wf-1")
+		r.Header.Set("tuz", "svc-a")
+		w := httptest.NewRecorder()
+		proxyToCluster(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s %s answered %d", req.method, req.target, w.Code)
+		}
+	}
+	var actions []string
+	for _, rec := range readAudit(t, path) {
+		actions = append(actions, rec.Action)
+		if rec.Namespace != "batch-a" || rec.Workflow != "wf-1" || rec.Cluster != argo.URL || rec.Tuz != "svc-a" || rec.Status != http.StatusOK || rec.Balancer != "balancer-1" || rec.Time.IsZero() {
+			t.Errorf("%s recorded as %+v", rec.Action, rec)
+		}
+	}
+	if want := []string{"status", "stop", "delete"}; !slices.Equal(actions, want) {
+		t.Errorf("audited %v, want %v", actions, want)
+	}
+}
+
+func TestAuditLogRotates(t *testing.T) {
+	currentConfig.Store(&Config{})
+	defer currentConfig.Store(nil)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+	// Room for about two records per file
+	a, err := openAuditLog(path, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 5 {
+		a.Log(context.Background(), AuditRecord{Action: "submit", Namespace: "batch-a", Workflow: fmt.Sprintf("wf-%d", i)})
+	}
+	a.Close()
+
+	files, err := filepath.Glob(path + "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var workflows []string
+	for _, f := range files {
+		if st, _ := os.Stat(f); st.Size() > 250 {
+			t.Errorf("%s grew to %d bytes, past the limit", f, st.Size())
+		}
+		for _, rec := range readAudit(t, f) {
+			workflows = append(workflows, rec.Workflow)
+		}
+	}
+	// Nothing is lost across rotations
+	slices.Sort(workflows)
+	if len(files) < 2 || !slices.Equal(workflows, []string{"wf-0", "wf-1", "wf-2", "wf-3", "wf-4"}) {
+		t.Errorf("%d files with %v, want several files holding every record", len(files), workflows)
+	}
+}
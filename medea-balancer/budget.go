@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// errOverBudget marks a submit that would push its namespace over budget
+var errOverBudget = errors.New("namespace budget exceeded")
+
+// Budget limits the summed resources of a namespace's active workflows, 0 means no limit
+type Budget struct {
+	CPU float64
+	RAM float64
+}
+
+// parseBudget reads "cpu:ram" with ram in MEDEA_MEMORY_UNIT, e.g. "100:400"
+func parseBudget(s string) (Budget, error) {
+	cpuStr, ramStr, ok := strings.Cut(s, ":")
+	if !ok {
+		return Budget{}, fmt.Errorf("expected cpu:ram")
+	}
+	cpu, err := strconv.ParseFloat(strings.TrimSpace(cpuStr), 64)
+	if err != nil || cpu < 0 {
+		return Budget{}, fmt.Errorf("invalid cpu %q", cpuStr)
+	}
+	ram, err := strconv.ParseFloat(strings.TrimSpace(ramStr), 64)
+	if err != nil || ram < 0 {
+		return Budget{}, fmt.Errorf("invalid ram %q", ramStr)
+	}
+	return Budget{CPU: cpu, RAM: ram}, nil
+}
+
+// namespaceBudget returns the budget of the first pattern matching ns
+func namespaceBudget(cfg *Config, ns string) (Budget, bool) {
+	for _, kv := range cfg.NamespaceBudgets {
+		if ok, _ := path.Match(kv.Key, ns); ok {
+			b, _ := parseBudget(kv.Value) // validated in loadConfig
+			return b, true
+		}
+	}
+	return Budget{}, false
+}
+
+// checkBudget fails with errOverBudget when the submit would take the namespace past its budget
+func checkBudget(cfg *Config, ns string, b Budget, cpu, mem float64) error {
+	usedCPU, usedGB, err := store.ActiveUsage(ns)
+	if err != nil {
+		return err
+	}
+	usedMem := memoryInUnit(cfg, usedGB)
+	if b.CPU > 0 && usedCPU+cpu > b.CPU {
+		return fmt.Errorf("%w: cpu %g in use + %g requested > budget %g", errOverBudget, usedCPU, cpu, b.CPU)
+	}
+	if b.RAM > 0 && usedMem+mem > b.RAM {
+		u := cfg.MemoryUnit
+		return fmt.Errorf("%w: ram %g%s in use + %g%s requested > budget %g%s", errOverBudget, usedMem, u, mem, u, b.RAM, u)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// usageStore reports fixed active usage, memory in GB like the real store
+type usageStore struct {
+	Store
+	cpu, memGB float64
+}
+
+func (s usageStore) ActiveUsage(ns string) (float64, float64, error) { return s.cpu, s.memGB, nil }
+
+func TestCheckBudgetMemoryUnit(t *testing.T) {
+	store = usageStore{cpu: 2, memGB: 3}
+	defer func() { store = nil }()
+
+	tests := []struct {
+		name     string
+		unit     string
+		budget   Budget
+		cpu, mem float64
+		wantOver bool
+	}{
+		{"GB within budget", memoryGB, Budget{RAM: 4}, 0, 1, false},
+		{"GB over budget", memoryGB, Budget{RAM: 4}, 0, 1.5, true},
+		{"MiB within budget", memoryMiB, Budget{RAM: 4096}, 0, 1024, false},
+		{"MiB over budget", memoryMiB, Budget{RAM: 4096}, 0, 1025, true},
+		{"cpu over budget", memoryGB, Budget{CPU: 3}, 2, 0, true},
+		{"no limits", memoryMiB, Budget{}, 100, 1e6, false},
+	}
+	for _, tt := range tests {
+		cfg := &Config{MemoryUnit: tt.unit}
+		err := checkBudget(cfg, "batch-a", tt.budget, tt.cpu, tt.mem)
+		if errors.Is(err, errOverBudget) != tt.wantOver {
+			t.Errorf("%s: err = %v, want over budget %v", tt.name, err, tt.wantOver)
+		}
+	}
+}
+
+func TestMemoryInGB(t *testing.T) {
+	tests := []struct {
+		unit string
+		v    float64
+		want float64
+	}{
+		{memoryGB, 2, 2},
+		{memoryMiB, 512, 0.5},
+		{memoryMiB, 1, 1.0 / 1024},
+	}
+	for _, tt := range tests {
+		cfg := &Config{MemoryUnit: tt.unit}
+		if got := memoryInGB(cfg, tt.v); got != tt.want {
+			t.Errorf("memoryInGB(%v %s) = %v, want %v", tt.v, tt.unit, got, tt.want)
+		}
+		if back := memoryInUnit(cfg, memoryInGB(cfg, tt.v)); back != tt.v {
+			t.Errorf("%v %s does not survive the round trip through GB: %v", tt.v, tt.unit, back)
+		}
+	}
+}
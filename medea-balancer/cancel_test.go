@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// hangingServer never answers; it reports each request it gets and whether the
+// caller aborted it
+func hangingServer(t *testing.T) (srv *httptest.Server, got, aborted chan struct{}) {
+	t.Helper()
+	got, aborted = make(chan struct{}, 1), make(chan struct{}, 1)
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The server only notices the client hanging up once the body is read
+		io.Copy(io.Discard, r.Body)
+		got <- struct{}{}
+		select {
+		case <-r.Context().Done():
+			aborted <- struct{}{}
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, got, aborted
+}
+
+func TestClientCancelAbortsOutbound(t *testing.T) {
+	cfg := &Config{APIVersion: "1", APIVersions: []string{"1"}, ResourceKinds: defaultResourceKinds, MemoryUnit: memoryGB, ProxyTimeout: time.Minute}
+	currentConfig.Store(cfg)
+	defer func() {
+		store = nil
+		currentConfig.Store(nil)
+	}()
+
+	hungScout, scoutGot, scoutAborted := hangingServer(t)
+	hungArgo, argoGot, argoAborted := hangingServer(t)
+	scout := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"cluster": %q}`, hungArgo.URL)
+	}))
+	defer scout.Close()
+
+	submit := func(ctx context.Context, scoutURL string) func() {
+		return func() {
+			store = &recordingStore{}
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/workflows/batch-a/submit", strings.NewReader(`{"resourceKind": "WorkflowTemplate", "resourceName": "tpl"}`))
+			r.SetPathValue("namespace", "batch-a")
+			r = r.WithContext(context.WithValue(ctx, configKey{}, cfg))
+			handleSubmit(httptest.NewRecorder(), r, scoutURL)
+		}
+	}
+	proxy := func(ctx context.Context) func() {
+		return func() {
+			store = clusterStore{cluster: hungArgo.URL}
+			proxyToCluster(httptest.NewRecorder(), statusRequest(nil).WithContext(ctx))
+		}
+	}
+
+	tests := []struct {
+		name         string
+		call         func(ctx context.Context) func()
+		got, aborted chan struct{}
+	}{
+		{"submit waiting for scout", func(ctx context.Context) func() { return submit(ctx, hungScout.URL) }, scoutGot, scoutAborted},
+		{"submit waiting for the cluster", func(ctx context.Context) func() { return submit(ctx, scout.URL) }, argoGot, argoAborted},
+		{"proxy waiting for the cluster", proxy, argoGot, argoAborted},
+	}
+	for _, tt := range tests {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			tt.call(ctx)()
+		}()
+		select {
+		case <-tt.got:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: the outbound request was never made", tt.name)
+		}
+		// The client goes away
+		cancel()
+		select {
+		case <-tt.aborted:
+		case <-time.After(5 * time.Second):
+			t.Errorf("%s: the outbound request kept going after the client left", tt.name)
+		}
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Errorf("%s: the handler didn't return", tt.name)
+		}
+	}
+
+	// A client that is already gone causes no outbound request at all
+	var asks atomic.Int32
+	counting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { asks.Add(1) }))
+	defer counting.Close()
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), configKey{}, cfg))
+	cancel()
+	if _, err := getTargetCluster(ctx, counting.URL, ScoutRequest{Namespace: "batch-a"}); err == nil || asks.Load() != 0 {
+		t.Errorf("scout asked %d times for a cancelled request, error %v", asks.Load(), err)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// purgeDeleted hard-deletes soft-deleted workflows once they are older than grace,
+// checking every interval until ctx is done. Within the grace they still resolve
+// for late status checks and show up in exports.
+func purgeDeleted(ctx context.Context, grace, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := store.PurgeDeleted(grace)
+			if err != nil {
+				slog.Error("Purging deleted workflows failed", "error", err)
+			} else if n > 0 {
+				slog.Info("Purged deleted workflows", "count", n, "grace", grace.String())
+			}
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// statusCall is a status lookup in flight, shared by every identical request that arrives meanwhile
+type statusCall struct {
+	done chan struct{}
+	resp *bufferedResponse
+}
+
+// statusCalls coalesces concurrent identical status requests, so a crowd of pollers costs one
+// DB lookup and one upstream call (MEDEA_COALESCE_STATUS)
+var statusCalls = struct {
+	sync.Mutex
+	inFlight map[string]*statusCall
+}{inFlight: make(map[string]*statusCall)}
+
+// bufferedResponse records a response so it can be replayed to several clients
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+// coalescable reports whether a proxied request is a workflow status GET. Deletes, stops and
+// sub-paths like logs change state or stream, and are always sent on their own.
+func coalescable(r *http.Request) bool {
+	return configFrom(r.Context()).CoalesceStatus && r.Method == http.MethodGet && r.PathValue("subPath") == ""
+}
+
+// statusKey identifies identical status requests. Every header forwarded upstream is part of it,
+// credentials and cookies included, so a client never gets an answer fetched with someone else's.
+// Only the request ID is left out, each client keeps its own anyway.
+func statusKey(r *http.Request) string {
+	parts := []string{placementNamespace(r), r.PathValue("namespace"), r.PathValue("workflowName"), r.URL.RawQuery}
+	header := forwardedHeaders(r.Header, true)
+	header.Del(requestIDHeader)
+	for _, name := range slices.Sorted(maps.Keys(header)) {
+		parts = append(parts, name+":"+strings.Join(header[name], "\x01"))
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// handleCoalescedStatus answers a status request with the result of an identical one already in
+// flight, or proxies it and shares the result with those arriving meanwhile. The shared
+// call outlives a client that leaves, so it doesn't fail for the others.
+func handleCoalescedStatus(w http.ResponseWriter, r *http.Request) {
+	key := statusKey(r)
+	statusCalls.Lock()
+	call, ok := statusCalls.inFlight[key]
+	if !ok {
+		call = &statusCall{done: make(chan struct{}), resp: &bufferedResponse{header: make(http.Header)}}
+		statusCalls.inFlight[key] = call
+	}
+	statusCalls.Unlock()
+
+	if ok {
+		coalescedRequestsTotal.Inc()
+	} else {
+		func() {
+			defer func() {
+				// A proxy that panicked answered nobody
+				if call.resp.status == 0 {
+					call.resp.status = http.StatusBadGateway
+				}
+				statusCalls.Lock()
+				delete(statusCalls.inFlight, key)
+				statusCalls.Unlock()
+				close(call.done)
+			}()
+			proxyToCluster(call.resp, r.WithContext(context.WithoutCancel(r.Context())))
+		}()
+	}
+
+	select {
+	case <-call.done:
+	case <-r.Context().Done():
+		return
+	}
+	// Each client keeps its own request ID, not the one of the request that did the call
+	for name, values := range call.resp.header {
+		if name != requestIDHeader {
+			w.Header()[name] = values
+		}
+	}
+	w.WriteHeader(call.resp.status)
+	w.Write(call.resp.body.Bytes())
+}
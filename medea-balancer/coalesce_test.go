@@ -0,0 +1,135 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// clusterStore answers every cluster lookup with one cluster
+type clusterStore struct {
+	Store
+	cluster string
+}
+
+func (s clusterStore) GetCluster(wfName, ns string) (string, error) { return s.cluster, nil }
+
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := c.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func statusRequest(header map[string]string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/workflows/batch-a/wf-1?fields=status", nil)
+	r.SetPathValue("namespace", "batch-a")
+	r.SetPathValue("workflowName", "wf-1")
+	for name, value := range header {
+		r.Header.Set(name, value)
+	}
+	return r
+}
+
+func TestStatusKey(t *testing.T) {
+	currentConfig.Store(&Config{})
+	defer currentConfig.Store(nil)
+	base := map[string]string{"Authorization": "Bearer a", "Cookie": "session=a", "tuz": "svc-a", requestIDHeader: "req-1"}
+	tests := []struct {
+		name   string
+		header map[string]string
+		same   bool
+	}{
+		{"identical", map[string]string{}, true},
+		{"own request ID", map[string]string{requestIDHeader: "req-2"}, true},
+		{"balancer header", map[string]string{"X-Medea-Admin-Token": "secret"}, true},
+		{"accept encoding", map[string]string{"Accept-Encoding": "gzip"}, true},
+		{"other cookie", map[string]string{"Cookie": "session=b"}, false},
+		{"other credentials", map[string]string{"Authorization": "Bearer b"}, false},
+		{"other tuz", map[string]string{"tuz": "svc-b"}, false},
+		{"extra forwarded header", map[string]string{"Accept-Language": "de"}, false},
+	}
+	want := statusKey(statusRequest(base))
+	for _, tt := range tests {
+		header := make(map[string]string)
+		for name, value := range base {
+			header[name] = value
+		}
+		for name, value := range tt.header {
+			header[name] = value
+		}
+		if got := statusKey(statusRequest(header)); (got == want) != tt.same {
+			t.Errorf("%s: same key = %v, want %v", tt.name, got == want, tt.same)
+		}
+	}
+}
+
+func TestCoalescedStatus(t *testing.T) {
+	var calls atomic.Int32
+	arrived, release := make(chan struct{}), make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			close(arrived)
+		}
+		<-release
+		w.Write([]byte(`{"status":"Running"}`))
+	}))
+	defer upstream.Close()
+
+	store = clusterStore{cluster: upstream.URL}
+	defer func() { store = nil }()
+	currentConfig.Store(&Config{CoalesceStatus: true, ProxyTimeout: 5 * time.Second})
+	defer currentConfig.Store(nil)
+
+	header := map[string]string{"Cookie": "session=a"}
+	const clients = 4
+	recorders := make([]*httptest.ResponseRecorder, clients)
+	var wg sync.WaitGroup
+	serve := func(i int) {
+		defer wg.Done()
+		recorders[i] = httptest.NewRecorder()
+		handleProxy(recorders[i], statusRequest(header))
+	}
+	wg.Add(clients)
+	go serve(0)
+	<-arrived
+
+	// The others join the call in flight instead of asking upstream themselves
+	coalesced := counterValue(t, coalescedRequestsTotal)
+	for i := 1; i < clients; i++ {
+		go serve(i)
+	}
+	for deadline := time.Now().Add(5 * time.Second); counterValue(t, coalescedRequestsTotal)-coalesced < clients-1; {
+		if time.Now().After(deadline) {
+			t.Fatal("identical status requests did not join the call in flight")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("upstream asked %d times, want 1", n)
+	}
+	for i, rec := range recorders {
+		if body, _ := io.ReadAll(rec.Body); rec.Code != http.StatusOK || string(body) != `{"status":"Running"}` {
+			t.Errorf("client %d got %d %q", i, rec.Code, body)
+		}
+	}
+
+	// A request with other cookies is never answered from someone else's call
+	rec := httptest.NewRecorder()
+	handleProxy(rec, statusRequest(map[string]string{"Cookie": "session=b"}))
+	if n := calls.Load() - 1; n != 1 || rec.Code != http.StatusOK {
+		t.Errorf("request with other cookies: upstream asked %d times, status %d", n, rec.Code)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// envSource reads settings from the environment, falling back to MEDEA_CONFIG_FILE, keyed by
+// environment variable name. Environment variables take precedence over the file.
+// The first invalid setting is kept in err, so loadConfig can report it instead of exiting.
+type envSource struct {
+	file map[string]string
+	err  error
+}
+
+// newEnvSource loads MEDEA_CONFIG_FILE when it is set
+func newEnvSource() (*envSource, error) {
+	e := &envSource{}
+	if path := os.Getenv("MEDEA_CONFIG_FILE"); path != "" {
+		values, err := readConfigFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load MEDEA_CONFIG_FILE: %v", err)
+		}
+		e.file = values
+	}
+	return e, nil
+}
+
+// getenv returns the environment variable, falling back to the config file
+func (e *envSource) getenv(key string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return e.file[key]
+}
+
+// invalid records an invalid setting, keeping the first one
+func (e *envSource) invalid(format string, args ...any) {
+	if e.err == nil {
+		e.err = fmt.Errorf(format, args...)
+	}
+}
+
+// readConfigFile loads a JSON object of environment variable names to values.
+// Comments (// and /* */) and trailing commas are allowed, so operators can annotate it.
+// Arrays become comma-separated lists, numbers and booleans their usual text.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]any
+	if err := json.Unmarshal(stripTrailingCommas(stripComments(data)), &raw); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	values := make(map[string]string, len(raw))
+	for key, v := range raw {
+		s, err := configValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", path, key, err)
+		}
+		values[key] = s
+	}
+	return values, nil
+}
+
+func configValue(v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := configValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported value %v", v)
+	}
+}
+
+// stripComments blanks out // and /* */ comments outside of JSON strings
+func stripComments(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	inString := false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if inString {
+			out = append(out, c)
+			if c == '\\' && i+1 < len(data) {
+				i++
+				out = append(out, data[i])
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		}
+		switch {
+		case c == '"':
+			inString = true
+			out = append(out, c)
+		case c == '/' && i+1 < len(data) && data[i+1] == '/':
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+			if i < len(data) {
+				out = append(out, '\n')
+			}
+		case c == '/' && i+1 < len(data) && data[i+1] == '*':
+			i += 2
+			for i+1 < len(data) && !(data[i] == '*' && data[i+1] == '/') {
+				i++
+			}
+			i++
+			out = append(out, ' ')
+		default:
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// stripTrailingCommas drops commas directly before a closing } or ]
+func stripTrailingCommas(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	inString := false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if inString {
+			out = append(out, c)
+			if c == '\\' && i+1 < len(data) {
+				i++
+				out = append(out, data[i])
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		}
+		if c == '"' {
+			inString = true
+		}
+		if c == ',' {
+			j := i + 1
+			for j < len(data) && strings.IndexByte(" \t\r\n", data[j]) >= 0 {
+				j++
+			}
+			if j < len(data) && (data[j] == '}' || data[j] == ']') {
+				continue
+			}
+		}
+		out = append(out, c)
+	}
+	return out
+}
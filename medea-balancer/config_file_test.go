@@ -0,0 +1,77 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "medea.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadConfigFileComments(t *testing.T) {
+	path := writeConfigFile(t, `{
+	// Scout runs next to the balancer
+	"SCOUT_URL": "http://scout:8080/path//with/*slashes*/", // not a comment inside the string
+	/* Sensors are submitted by the
+	   data platform team */
+	"MEDEA_RESOURCE_KINDS": ["Workflow", "Sensor",],
+	"MEDEA_AUDIT_PARAMS": true, /* trailing */
+	"MEDEA_QUEUE_SIZE": 20,
+	"MEDEA_NOTE": "quote \" and // inside",
+}
+`)
+	got, err := readConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"SCOUT_URL":            "http://scout:8080/path//with/*slashes*/",
+		"MEDEA_RESOURCE_KINDS": "Workflow,Sensor",
+		"MEDEA_AUDIT_PARAMS":   "true",
+		"MEDEA_QUEUE_SIZE":     "20",
+		"MEDEA_NOTE":           `quote " and // inside`,
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("read %v, want %v", got, want)
+	}
+
+	for _, bad := range []string{
+		`{"SCOUT_URL": "http://scout" /* never closed`,
+		`{"SCOUT_URL": {"nested": true}}`,
+		`// only a comment`,
+	} {
+		if values, err := readConfigFile(writeConfigFile(t, bad)); err == nil {
+			t.Errorf("%q read as %v, want an error", bad, values)
+		}
+	}
+}
+
+func TestLoadCommentedConfigFile(t *testing.T) {
+	t.Setenv("DB_DRIVER", "sqlite")
+	t.Setenv("MEDEA_CONFIG_FILE", writeConfigFile(t, `{
+	// Sensors come from the data platform team
+	"MEDEA_RESOURCE_KINDS": ["Workflow", "Sensor"],
+	/* The environment wins over the file */
+	"MEDEA_ALLOWED_NAMESPACES": "from-file",
+}`))
+	t.Setenv("MEDEA_ALLOWED_NAMESPACES", "from-env")
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Workflow", "Sensor"}; !slices.Equal(cfg.ResourceKinds, want) {
+		t.Errorf("resource kinds %v, want %v from the file", cfg.ResourceKinds, want)
+	}
+	if want := []string{"from-env"}; !slices.Equal(cfg.AllowedNamespaces, want) {
+		t.Errorf("allowed namespaces %v, want %v from the environment", cfg.AllowedNamespaces, want)
+	}
+}
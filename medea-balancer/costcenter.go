@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// costCenterRule maps namespaces matching re to a cost center, template may refer to the
+// groups of re ($1, ${team})
+type costCenterRule struct {
+	re       *regexp.Regexp
+	template string
+}
+
+// parseCostCenters compiles the MEDEA_COST_CENTERS pairs. Patterns must match the whole namespace.
+func parseCostCenters(pairs []KeyValue) ([]costCenterRule, error) {
+	rules := make([]costCenterRule, 0, len(pairs))
+	for _, kv := range pairs {
+		re, err := regexp.Compile("^(?:" + kv.Key + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("pattern %q: %w", kv.Key, err)
+		}
+		rules = append(rules, costCenterRule{re: re, template: kv.Value})
+	}
+	return rules, nil
+}
+
+// costCenter derives the cost center of a namespace from the first matching rule,
+// "" when no rule matches
+func costCenter(cfg *Config, ns string) string {
+	for _, rule := range cfg.CostCenters {
+		if m := rule.re.FindStringSubmatchIndex(ns); m != nil {
+			return string(rule.re.ExpandString(nil, rule.template, ns, m))
+		}
+	}
+	return ""
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCostCenter(t *testing.T) {
+	rules, err := parseCostCenters([]KeyValue{
+		{Key: `team-(?P<team>[a-z]+)-.*`, Value: "cc-${team}"},
+		{Key: `etl|reports`, Value: "data"},
+		{Key: `(prod|stage)-([a-z]+)`, Value: "$2-$1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{CostCenters: rules}
+	tests := []struct {
+		ns   string
+		want string
+	}{
+		{"team-a-batch", "cc-a"},
+		{"team-billing-nightly", "cc-billing"},
+		{"etl", "data"},
+		{"reports", "data"},
+		{"prod-search", "search-prod"},
+		// Patterns match the whole namespace
+		{"etl-2", ""},
+		{"my-team-a-batch", ""},
+		{"other", ""},
+	}
+	for _, tt := range tests {
+		if got := costCenter(cfg, tt.ns); got != tt.want {
+			t.Errorf("costCenter(%q) = %q, want %q", tt.ns, got, tt.want)
+		}
+	}
+	if got := costCenter(&Config{}, "team-a-batch"); got != "" {
+		t.Errorf("costCenter without rules = %q, want empty", got)
+	}
+	if _, err := parseCostCenters([]KeyValue{{Key: "team-(", Value: "x"}}); err == nil {
+		t.Error("parseCostCenters accepted an invalid pattern")
+	}
+}
+
+func TestCostCenterInWebhook(t *testing.T) {
+	events := make(chan map[string]any, 1)
+	dest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event map[string]any
+		json.NewDecoder(r.Body).Decode(&event)
+		events <- event
+	}))
+	defer dest.Close()
+
+	hooks := newPlacementWebhooks([]string{dest.URL}, 1, 0)
+	hooks.Send(WorkflowRecord{Name: "wf-1", Namespace: "team-a-batch", Cluster: "east", CostCenter: "cc-a"})
+	hooks.Close()
+	if event := <-events; event["costCenter"] != "cc-a" {
+		t.Errorf("webhook event %v, want costCenter cc-a", event)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDryRunNoClusterExplains(t *testing.T) {
+	var asked ScoutRequest
+	scout := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&asked)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "No suitable clusters found", "reason": {"cpu": 1, "ram": 0, "both": 1}, "free": {"east": {"cpu": 2, "ram": 8}, "west": {"cpu": 0.5, "ram": 1}}}`))
+	}))
+	defer scout.Close()
+	cfg := &Config{APIVersion: "1", APIVersions: []string{"1"}, ResourceKinds: defaultResourceKinds, MemoryUnit: memoryGB}
+	body := `{"resourceKind": "WorkflowTemplate", "resourceName": "tpl", "submitOptions": {"parameters": ["executor_num=2", "executor_cores_limit=2", "executor_memory_limit=4g", "driver_cores_limit=1", "driver_memory_limit=2g"]}}`
+
+	tests := []struct {
+		name   string
+		dryRun bool
+	}{
+		{"dry-run", true},
+		{"submit", false},
+	}
+	for _, tt := range tests {
+		asked = ScoutRequest{}
+		target := "/api/v1/workflows/batch-a/submit"
+		if tt.dryRun {
+			target += "?dryRun=true"
+		}
+		r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		r.SetPathValue("namespace", "batch-a")
+		r = r.WithContext(context.WithValue(r.Context(), configKey{}, cfg))
+		w := httptest.NewRecorder()
+		handleSubmit(w, r, scout.URL)
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("%s: answered %d %q, want 404", tt.name, w.Code, w.Body.String())
+		}
+		// Only the dry-run asks scout for the capacity it compared against
+		if asked.Verbose != tt.dryRun || asked.DryRun != tt.dryRun {
+			t.Errorf("%s: scout asked with verbose %v, dry-run %v", tt.name, asked.Verbose, asked.DryRun)
+		}
+		if !tt.dryRun {
+			if strings.TrimSpace(w.Body.String()) != "Cluster not found" {
+				t.Errorf("%s: body %q, want the plain error", tt.name, w.Body.String())
+			}
+			continue
+		}
+
+		var got DryRunResponse
+		if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !got.DryRun || got.Cluster != "" || got.CPUTotal != 5 || got.MemTotal != 10 {
+			t.Errorf("%s: answered %+v, want cpuTotal 5 and memTotal 10 without a cluster", tt.name, got)
+		}
+		var reason map[string]int
+		var free map[string]map[string]float64
+		if err := json.Unmarshal(got.Reason, &reason); err != nil || reason["both"] != 1 {
+			t.Errorf("%s: reason %s, want scout's breakdown", tt.name, got.Reason)
+		}
+		if err := json.Unmarshal(got.Free, &free); err != nil || free["east"]["ram"] != 8 || len(free) != 2 {
+			t.Errorf("%s: free %s, want scout's per-cluster capacity", tt.name, got.Free)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const exportPath = "/api/v1/admin/export"
+
+// handleExport streams the workflows table as CSV or newline-delimited JSON
+func handleExport(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "csv" && format != "json" {
+		http.Error(w, "format must be csv or json", http.StatusBadRequest)
+		return
+	}
+	var since, until time.Time
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+	}{{"since", &since}, {"until", &until}} {
+		if v := r.URL.Query().Get(p.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, p.name+" must be an RFC 3339 time", http.StatusBadRequest)
+				return
+			}
+			*p.t = t
+		}
+	}
+
+	// A full export easily outlasts MEDEA_WRITE_TIMEOUT
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	var write func(WorkflowRecord) error
+	var flush func()
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"workflowName", "workflowTemplate", "namespace", "cluster", "balancer", "cpuTotal", "memTotal", "costCenter", "createdAt", "deletedAt"})
+		write = func(rec WorkflowRecord) error {
+			deleted := ""
+			if rec.DeletedAt != nil {
+				deleted = rec.DeletedAt.UTC().Format(time.RFC3339)
+			}
+			return cw.Write([]string{
+				rec.Name, rec.Template, rec.Namespace, rec.Cluster, rec.Balancer,
+				strconv.FormatFloat(rec.CPU, 'f', -1, 64), strconv.FormatFloat(rec.RAM, 'f', -1, 64),
+				rec.CostCenter, rec.CreatedAt.UTC().Format(time.RFC3339), deleted,
+			})
+		}
+		flush = cw.Flush
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		write = func(rec WorkflowRecord) error { return enc.Encode(rec) }
+		flush = func() {}
+	}
+
+	// Headers are gone once the first row is written, so a late error can only cut the stream short
+	if err := store.ExportWorkflows(since, until, write); err != nil {
+		slog.ErrorContext(r.Context(), "Export failed", "error", err)
+	}
+	flush()
+}
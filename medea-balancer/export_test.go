@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// exportStore streams its records and remembers the window it was asked for
+type exportStore struct {
+	Store
+	recs         []WorkflowRecord
+	since, until time.Time
+}
+
+func (s *exportStore) ExportWorkflows(since, until time.Time, fn func(WorkflowRecord) error) error {
+	s.since, s.until = since, until
+	for _, rec := range s.recs {
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestHandleExport(t *testing.T) {
+	created := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	deleted := created.Add(time.Hour)
+	fake := &exportStore{recs: []WorkflowRecord{
+		{Name: "wf-1", Template: "tpl", Namespace: "ns", Cluster: "east", Balancer: "b1", CPU: 1.5, RAM: 4, CostCenter: "cc", CreatedAt: created},
+		{Name: "wf-2", Template: "tpl", Namespace: "ns", Cluster: "west", CreatedAt: created, DeletedAt: &deleted},
+	}}
+	store = fake
+	defer func() { store = nil }()
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantType   string
+		wantSince  time.Time
+	}{
+		{"json by default", "", http.StatusOK, "application/x-ndjson", time.Time{}},
+		{"csv", "?format=csv", http.StatusOK, "text/csv", time.Time{}},
+		{"window", "?format=json&since=2026-03-01T10:00:00%2B02:00&until=2026-03-02T00:00:00Z", http.StatusOK, "application/x-ndjson", created.Add(-4 * time.Hour)},
+		{"unknown format", "?format=xml", http.StatusBadRequest, "", time.Time{}},
+		{"bad since", "?since=yesterday", http.StatusBadRequest, "", time.Time{}},
+	}
+	for _, tt := range tests {
+		fake.since, fake.until = time.Time{}, time.Time{}
+		w := httptest.NewRecorder()
+		handleExport(w, httptest.NewRequest(http.MethodGet, exportPath+tt.query, nil))
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: answered %d, want %d", tt.name, w.Code, tt.wantStatus)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		if got := w.Header().Get("Content-Type"); got != tt.wantType {
+			t.Errorf("%s: content type %q, want %q", tt.name, got, tt.wantType)
+		}
+		if !fake.since.Equal(tt.wantSince) {
+			t.Errorf("%s: exported since %v, want %v", tt.name, fake.since, tt.wantSince)
+		}
+
+		var rows [][]string
+		if tt.wantType == "text/csv" {
+			all, err := csv.NewReader(w.Body).ReadAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(all) == 0 || all[0][0] != "workflowName" {
+				t.Fatalf("%s: no header in %v", tt.name, all)
+			}
+			rows = all[1:]
+		} else {
+			for sc := bufio.NewScanner(w.Body); sc.Scan(); {
+				var rec WorkflowRecord
+				if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+					t.Fatalf("%s: %v", tt.name, err)
+				}
+				deletedAt := ""
+				if rec.DeletedAt != nil {
+					deletedAt = rec.DeletedAt.UTC().Format(time.RFC3339)
+				}
+				rows = append(rows, []string{rec.Name, rec.Template, rec.Namespace, rec.Cluster, rec.Balancer,
+					strconv.FormatFloat(rec.CPU, 'f', -1, 64), strconv.FormatFloat(rec.RAM, 'f', -1, 64),
+					rec.CostCenter, rec.CreatedAt.UTC().Format(time.RFC3339), deletedAt})
+			}
+		}
+		want := [][]string{
+			{"wf-1", "tpl", "ns", "east", "b1", "1.5", "4", "cc", "2026-03-01T12:00:00Z", ""},
+			{"wf-2", "tpl", "ns", "west", "", "0", "0", "", "2026-03-01T12:00:00Z", "2026-03-01T13:00:00Z"},
+		}
+		if !slices.EqualFunc(rows, want, slices.Equal) {
+			t.Errorf("%s: exported\n%v\nwant\n%v", tt.name, rows, want)
+		}
+		if strings.Contains(tt.query, "until") && fake.until.IsZero() {
+			t.Errorf("%s: until was not passed on", tt.name)
+		}
+	}
+}
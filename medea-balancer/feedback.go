@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Outcomes of a forwarded submit, as scout's feedback endpoint expects them
+const (
+	outcomeSuccess = "success"
+	outcomeFailure = "failure"
+)
+
+// feedbackTimeout bounds a single report to scout
+const feedbackTimeout = 5 * time.Second
+
+// Feedback tells scout how a submit to the cluster it picked went
+type Feedback struct {
+	Cluster   string `json:"cluster"`
+	Namespace string `json:"namespace"`
+	Outcome   string `json:"outcome"`
+}
+
+// submitOutcome classifies a forwarded submit for scout: 2xx with a workflow is a success,
+// no answer or a 5xx is the cluster's failure. Other answers, like a 4xx for a bad request,
+// say nothing about the cluster and return "".
+func submitOutcome(status int, forwardErr error) string {
+	switch {
+	case forwardErr != nil || status >= 500:
+		return outcomeFailure
+	case status >= 200 && status < 300:
+		return outcomeSuccess
+	}
+	return ""
+}
+
+// sendFeedback reports a submit outcome to scout in the background when MEDEA_SCOUT_FEEDBACK is set.
+// It is best effort: the submit never waits for it and failures are only logged. The report
+// carries the request ID of ctx, but outlives the request.
+func sendFeedback(ctx context.Context, cfg *Config, scoutURL string, fb Feedback) {
+	if !cfg.ScoutFeedback || fb.Outcome == "" {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		body, _ := json.Marshal(fb)
+		client := &http.Client{Timeout: feedbackTimeout}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, scoutURL+"/api/feedback", bytes.NewReader(body))
+		if err != nil {
+			slog.WarnContext(ctx, "Reporting submit outcome to scout failed", "cluster", fb.Cluster, "namespace", fb.Namespace, "outcome", fb.Outcome, "error", err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if id := requestID(ctx); id != "" {
+			req.Header.Set(requestIDHeader, id)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			slog.WarnContext(ctx, "Reporting submit outcome to scout failed", "cluster", fb.Cluster, "namespace", fb.Namespace, "outcome", fb.Outcome, "error", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			slog.WarnContext(ctx, "Reporting submit outcome to scout failed", "cluster", fb.Cluster, "namespace", fb.Namespace, "outcome", fb.Outcome, "status", resp.StatusCode)
+		}
+	}()
+}
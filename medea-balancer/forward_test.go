@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type upstreamAnswer struct {
+	status     int
+	retryAfter string
+	body       string
+}
+
+func TestForwardSubmitRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		answers  []upstreamAnswer
+		attempts int32
+		status   int
+	}{
+		{"created", []upstreamAnswer{{status: 200}}, 1, 200},
+		{"bad gateway", []upstreamAnswer{{status: 502}, {status: 200}}, 1, 502},
+		{"gateway timeout", []upstreamAnswer{{status: 504}, {status: 200}}, 1, 504},
+		{"unavailable without Retry-After", []upstreamAnswer{{status: 503}, {status: 200}}, 1, 503},
+		{"unavailable with Retry-After", []upstreamAnswer{{status: 503, retryAfter: "0"}, {status: 200}}, 2, 200},
+		{"Retry-After as a date", []upstreamAnswer{{status: 503, retryAfter: "Mon, 02 Jan 2006 15:04:05 GMT"}, {status: 200}}, 2, 200},
+		{"Retry-After beyond the proxy timeout", []upstreamAnswer{{status: 503, retryAfter: "3600"}, {status: 200}}, 1, 503},
+		{"unavailable naming a workflow", []upstreamAnswer{{status: 503, retryAfter: "0", body: `{"metadata":{"name":"wf-1"}}`}, {status: 200}}, 1, 503},
+		{"retries used up", []upstreamAnswer{{status: 503, retryAfter: "0"}, {status: 503, retryAfter: "0"}, {status: 200}}, 2, 503},
+	}
+	for _, tt := range tests {
+		var attempts atomic.Int32
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			a := tt.answers[attempts.Add(1)-1]
+			if a.retryAfter != "" {
+				w.Header().Set("Retry-After", a.retryAfter)
+			}
+			w.WriteHeader(a.status)
+			w.Write([]byte(a.body))
+		}))
+		resp, err := forwardSubmit(submitRequest(&Config{SubmitRetries: 1, ProxyTimeout: 5 * time.Second}), upstream.URL, upstream.URL, nil, "")
+		upstream.Close()
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status || attempts.Load() != tt.attempts {
+			t.Errorf("%s: status %d after %d attempts, want %d after %d", tt.name, resp.StatusCode, attempts.Load(), tt.status, tt.attempts)
+		}
+	}
+}
+
+func TestForwardSubmitTimeoutNotRetried(t *testing.T) {
+	var attempts atomic.Int32
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		<-release
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	_, err := forwardSubmit(submitRequest(&Config{SubmitRetries: 2, ProxyTimeout: 50 * time.Millisecond}), upstream.URL, upstream.URL, nil, "")
+	if err == nil {
+		t.Fatal("submit that timed out returned no error")
+	}
+	if n := attempts.Load(); n != 1 {
+		t.Errorf("submit sent %d times after a timeout, want 1", n)
+	}
+}
+
+func TestForwardSubmitRetriesConnectErrors(t *testing.T) {
+	// Nothing listens on the address of a closed server
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	upstream.Close()
+
+	start := time.Now()
+	_, err := forwardSubmit(submitRequest(&Config{SubmitRetries: 1, ProxyTimeout: time.Second}), upstream.URL, upstream.URL, nil, "")
+	if err == nil || !isConnectError(err) {
+		t.Fatalf("err = %v, want a connect error", err)
+	}
+	if d := time.Since(start); d < submitRetryBackoff {
+		t.Errorf("gave up after %v, a refused connection must be retried", d)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		header string
+		want   time.Duration
+		ok     bool
+	}{
+		{"", 0, false},
+		{"0", 0, true},
+		{"2", 2 * time.Second, true},
+		{"-1", 0, true},
+		{"soon", 0, false},
+		{"1.5", 0, false},
+		{"Mon, 02 Jan 2006 15:04:05 GMT", 0, true},
+	}
+	for _, tt := range tests {
+		got, ok := retryAfter(tt.header)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("retryAfter(%q) = %v, %v, want %v, %v", tt.header, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func submitRequest(cfg *Config) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/workflows/batch-a/submit", nil)
+	return r.WithContext(context.WithValue(r.Context(), configKey{}, cfg))
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// hopByHopHeaders only apply to a single connection (RFC 7230, section 6.1) and are never forwarded
+var hopByHopHeaders = []string{
+	"Connection", "Proxy-Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// forwardedHeaders copies the end-to-end headers of src. Besides hop-by-hop headers it leaves out
+// Content-Length, which belongs to the body actually sent. Requests also lose Accept-Encoding so the
+// transport negotiates compression and submit responses arrive readable, and the balancer's own
+// X-Medea-* headers, which may carry the admin token.
+func forwardedHeaders(src http.Header, request bool) http.Header {
+	dst := src.Clone()
+	for _, name := range hopByHopHeaders {
+		dst.Del(name)
+	}
+	// Connection may name further headers that are hop-by-hop for this connection
+	for _, value := range src.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			dst.Del(strings.TrimSpace(name))
+		}
+	}
+	dst.Del("Content-Length")
+	if request {
+		dst.Del("Accept-Encoding")
+		for name := range dst {
+			if strings.HasPrefix(name, "X-Medea-") {
+				delete(dst, name)
+			}
+		}
+	}
+	return dst
+}
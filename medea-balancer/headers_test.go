@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestForwardedHeaders(t *testing.T) {
+	src := http.Header{
+		"Authorization":     {"Bearer t"},
+		"X-Request-Id":      {"req-1"},
+		"X-Argo-Ui":         {"1"},
+		"Tuz":               {"svc-a"},
+		"Connection":        {"keep-alive, X-Session"},
+		"X-Session":         {"s"},
+		"Keep-Alive":        {"timeout=5"},
+		"Transfer-Encoding": {"chunked"},
+		"Upgrade":           {"h2c"},
+		"Content-Length":    {"12"},
+		"Accept-Encoding":   {"gzip"},
+		"X-Medea-Admin":     {"secret"},
+	}
+	tests := []struct {
+		name    string
+		request bool
+		kept    []string
+		dropped []string
+	}{
+		{"request", true,
+			[]string{"Authorization", "X-Request-Id", "X-Argo-Ui", "Tuz"},
+			[]string{"Connection", "X-Session", "Keep-Alive", "Transfer-Encoding", "Upgrade", "Content-Length", "Accept-Encoding", "X-Medea-Admin"}},
+		{"response", false,
+			[]string{"Authorization", "X-Request-Id", "X-Argo-Ui", "Accept-Encoding", "X-Medea-Admin"},
+			[]string{"Connection", "X-Session", "Keep-Alive", "Transfer-Encoding", "Upgrade", "Content-Length"}},
+	}
+	for _, tt := range tests {
+		got := forwardedHeaders(src, tt.request)
+		for _, name := range tt.kept {
+			if got.Get(name) != src.Get(name) {
+				t.Errorf("%s: %s = %q, want %q", tt.name, name, got.Get(name), src.Get(name))
+			}
+		}
+		for _, name := range tt.dropped {
+			if v := got.Get(name); v != "" {
+				t.Errorf("%s: %s forwarded as %q", tt.name, name, v)
+			}
+		}
+	}
+	if src.Get("Connection") == "" {
+		t.Error("the source headers were modified")
+	}
+}
+
+func TestCustomHeaderRoundTrip(t *testing.T) {
+	var inbound http.Header
+	argo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inbound = r.Header.Clone()
+		w.Header().Set("X-Argo-Trace", "trace-"+r.Header.Get("X-Argo-Ui"))
+		w.Header().Set("Keep-Alive", "timeout=5")
+		w.Write([]byte(`{"kind": "Workflow", "metadata": {"name": "wf-1"}}`))
+	}))
+	defer argo.Close()
+	scout := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"cluster": %q}`, argo.URL)
+	}))
+	defer scout.Close()
+	cfg := &Config{APIVersion: "1", APIVersions: []string{"1"}, ResourceKinds: defaultResourceKinds, MemoryUnit: memoryGB, ProxyTimeout: 5 * time.Second}
+	currentConfig.Store(cfg)
+	defer func() {
+		store = nil
+		currentConfig.Store(nil)
+	}()
+
+	header := map[string]string{"Tuz": "svc-a", "Authorization": "Bearer t", "X-Argo-Ui": "1", "Connection": "X-Session", "X-Session": "s"}
+	tests := []struct {
+		name string
+		call func() *httptest.ResponseRecorder
+	}{
+		{"proxy", func() *httptest.ResponseRecorder {
+			store = clusterStore{cluster: argo.URL}
+			w := httptest.NewRecorder()
+			proxyToCluster(w, statusRequest(header))
+			return w
+		}},
+		{"submit", func() *httptest.ResponseRecorder {
+			store = &recordingStore{}
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/workflows/batch-a/submit", strings.NewReader(`{"resourceKind": "WorkflowTemplate", "resourceName": "tpl"}`))
+			r.SetPathValue("namespace", "batch-a")
+			for name, value := range header {
+				r.Header.Set(name, value)
+			}
+			r = r.WithContext(context.WithValue(r.Context(), configKey{}, cfg))
+			w := httptest.NewRecorder()
+			handleSubmit(w, r, scout.URL)
+			return w
+		}},
+	}
+	for _, tt := range tests {
+		inbound = nil
+		w := tt.call()
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: answered %d %q", tt.name, w.Code, w.Body.String())
+		}
+		for _, name := range []string{"Tuz", "Authorization", "X-Argo-Ui"} {
+			if inbound.Get(name) != header[name] {
+				t.Errorf("%s: the cluster got %s %q", tt.name, name, inbound.Get(name))
+			}
+		}
+		if inbound.Get("X-Session") != "" {
+			t.Errorf("%s: a header named by Connection was forwarded", tt.name)
+		}
+	}
+
+	// The cluster's own headers come back on proxied answers
+	store = clusterStore{cluster: argo.URL}
+	w := httptest.NewRecorder()
+	proxyToCluster(w, statusRequest(header))
+	if got := w.Header().Get("X-Argo-Trace"); got != "trace-1" {
+		t.Errorf("X-Argo-Trace %q came back, want trace-1", got)
+	}
+	if got := w.Header().Get("Keep-Alive"); got != "" {
+		t.Errorf("hop-by-hop Keep-Alive came back as %q", got)
+	}
+}
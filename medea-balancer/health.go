@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DependencyHealth is the result of one dependency check
+type DependencyHealth struct {
+	Status        string     `json:"status"`
+	LatencyMs     float64    `json:"latencyMs"`
+	Error         string     `json:"error,omitempty"`
+	LastError     string     `json:"lastError,omitempty"`
+	LastErrorTime *time.Time `json:"lastErrorTime,omitempty"`
+}
+
+// HealthDetails aggregates the checks of all dependencies
+type HealthDetails struct {
+	Status       string                      `json:"status"`
+	Dependencies map[string]DependencyHealth `json:"dependencies"`
+}
+
+// lastErrors remembers the most recent failure of each dependency across checks
+var lastErrors = struct {
+	sync.Mutex
+	byDep map[string]DependencyHealth
+}{byDep: make(map[string]DependencyHealth)}
+
+// healthChecks returns the dependency checks: the database, scout, and scout's Prometheus
+func healthChecks(cfg *Config) map[string]func(context.Context) error {
+	return map[string]func(context.Context) error{
+		"db": store.Ping,
+		"scout": func(ctx context.Context) error {
+			return checkURL(ctx, cfg.MedeaScout+"/api/v1/seen-clusters")
+		},
+		"prometheus": func(ctx context.Context) error {
+			// Scout is ready when a query against its Prometheus succeeds
+			return checkURL(ctx, cfg.MedeaScout+"/readyz")
+		},
+	}
+}
+
+// checkURL succeeds when a GET on url answers 200
+func checkURL(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// handleHealthDetails runs all checks concurrently, each with its own timeout
+func handleHealthDetails(w http.ResponseWriter, r *http.Request) {
+	cfg := configFrom(r.Context())
+	checks := healthChecks(cfg)
+	details := HealthDetails{Status: "ok", Dependencies: make(map[string]DependencyHealth, len(checks))}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(r.Context(), cfg.HealthCheckTimeout)
+			defer cancel()
+			start := time.Now()
+			err := check(ctx)
+			h := DependencyHealth{Status: "ok", LatencyMs: float64(time.Since(start).Microseconds()) / 1000}
+
+			lastErrors.Lock()
+			if err != nil {
+				now := time.Now().UTC()
+				h.Status, h.Error = "error", err.Error()
+				lastErrors.byDep[name] = DependencyHealth{LastError: err.Error(), LastErrorTime: &now}
+			}
+			h.LastError, h.LastErrorTime = lastErrors.byDep[name].LastError, lastErrors.byDep[name].LastErrorTime
+			lastErrors.Unlock()
+
+			mu.Lock()
+			details.Dependencies[name] = h
+			if err != nil {
+				details.Status = "degraded"
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	status := http.StatusOK
+	if details.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, details)
+}
+
+// handleHealthz is the liveness probe: the process is up and serving
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok\n"))
+}
+
+// handleReadyz is the readiness probe: the balancer can only place workflows with its database
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), configFrom(r.Context()).HealthCheckTimeout)
+	defer cancel()
+	if err := store.Ping(ctx); err != nil {
+		http.Error(w, "database unreachable: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
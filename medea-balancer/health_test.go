@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// pingStore is a store that is always reachable
+type pingStore struct{ Store }
+
+func (pingStore) Ping(ctx context.Context) error { return nil }
+
+func TestHealthChecksScoutPaths(t *testing.T) {
+	// Scout serves only its real routes, Prometheus is down
+	scout := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/seen-clusters":
+			w.Write([]byte("[]"))
+		case "/readyz":
+			http.Error(w, "prometheus unreachable", http.StatusServiceUnavailable)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer scout.Close()
+
+	store = pingStore{}
+	defer func() { store = nil }()
+	checks := healthChecks(&Config{MedeaScout: scout.URL})
+	tests := []struct {
+		dep    string
+		wantOK bool
+	}{
+		{"db", true},
+		{"scout", true},
+		{"prometheus", false},
+	}
+	for _, tt := range tests {
+		err := checks[tt.dep](context.Background())
+		if (err == nil) != tt.wantOK {
+			t.Errorf("%s check: err = %v, want ok %v", tt.dep, err, tt.wantOK)
+		}
+		if err != nil && err.Error() != "status 503" {
+			t.Errorf("%s check asked a route scout doesn't serve: %v", tt.dep, err)
+		}
+	}
+}
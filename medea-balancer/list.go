@@ -0,0 +1,48 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// WorkflowListItem is one entry of the workflow listing of a namespace
+type WorkflowListItem struct {
+	Name      string    `json:"workflowName"`
+	Template  string    `json:"workflowTemplate"`
+	Cluster   string    `json:"cluster"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// handleListWorkflows lists the workflows the balancer placed in a namespace, newest first,
+// optionally filtered by ?cluster= and paginated with ?limit= and ?offset=
+func handleListWorkflows(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var limit, offset int
+	for _, p := range []struct {
+		name string
+		n    *int
+	}{{"limit", &limit}, {"offset", &offset}} {
+		if v := q.Get(p.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				http.Error(w, p.name+" must be a non-negative integer", http.StatusBadRequest)
+				return
+			}
+			*p.n = n
+		}
+	}
+
+	records, err := store.ListWorkflows(r.PathValue("namespace"), q.Get("cluster"), limit, offset)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Listing workflows failed", "namespace", r.PathValue("namespace"), "error", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	items := make([]WorkflowListItem, 0, len(records))
+	for _, rec := range records {
+		items = append(items, WorkflowListItem{Name: rec.Name, Template: rec.Template, Cluster: rec.Cluster, CreatedAt: rec.CreatedAt})
+	}
+	writeJSON(w, http.StatusOK, items)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// setupLogging installs the default slog logger: JSON lines for the log pipeline, or text with
+// LOG_FORMAT=text for local runs, dropping records below LOG_LEVEL (debug, info, warn, error).
+// Both are read by loadConfig, so they may also come from MEDEA_CONFIG_FILE.
+// Output of the standard log package goes through the same handler.
+// Records logged with a request's context carry its request_id.
+func setupLogging(level slog.Level, format string) {
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	if format == logFormatText {
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	} else {
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(requestIDHandler{handler}))
+}
+
+// Values of LOG_FORMAT
+const (
+	logFormatJSON = "json"
+	logFormatText = "text"
+)
+
+// parseLogSettings validates LOG_LEVEL and LOG_FORMAT
+func parseLogSettings(levelName, format string) (slog.Level, string, error) {
+	var level slog.Level
+	if levelName != "" {
+		if err := level.UnmarshalText([]byte(levelName)); err != nil {
+			return 0, "", fmt.Errorf("Invalid LOG_LEVEL %q: expected debug, info, warn or error", levelName)
+		}
+	}
+	switch format = strings.ToLower(format); format {
+	case "", logFormatJSON:
+		return level, logFormatJSON, nil
+	case logFormatText:
+		return level, format, nil
+	}
+	return 0, "", fmt.Errorf("Invalid LOG_FORMAT %q: expected json or text", format)
+}
+
+// requestIDHandler adds the request_id of the request a record was logged for, when logged
+// with its context (slog.InfoContext(r.Context(), ...))
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, rec slog.Record) error {
+	if id := requestID(ctx); id != "" {
+		rec.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, rec)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
+
+// fatal logs an error with its attributes and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// fatalf logs a formatted error and exits, for config errors that are plain messages
+func fatalf(format string, args ...any) {
+	fatal(fmt.Sprintf(format, args...))
+}
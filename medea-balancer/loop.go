@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+)
+
+// hopsHeader counts the balancers a request has passed through
+const hopsHeader = "X-Medea-Hops"
+
+// checkLoop rejects requests that passed through more than MEDEA_MAX_HOPS balancers,
+// which means some target URL points back at a balancer
+func checkLoop(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := configFrom(r.Context())
+		if hops := incomingHops(r); cfg.MaxHops > 0 && hops > cfg.MaxHops {
+			http.Error(w, fmt.Sprintf("Proxy loop detected: %s=%d exceeds %d", hopsHeader, hops, cfg.MaxHops), http.StatusLoopDetected)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func incomingHops(r *http.Request) int {
+	n, _ := strconv.Atoi(r.Header.Get(hopsHeader))
+	return max(n, 0)
+}
+
+// setHops marks an outgoing request with one more hop than the incoming one
+func setHops(out, in *http.Request) {
+	out.Header.Set(hopsHeader, strconv.Itoa(incomingHops(in)+1))
+}
+
+// isSelf reports whether target points at this balancer: one of MEDEA_SELF_URLS,
+// or a local address on the balancer's own port
+func isSelf(cfg *Config, target string) bool {
+	u, err := url.Parse(target)
+	if err != nil {
+		return false
+	}
+	for _, self := range cfg.SelfURLs {
+		if su, err := url.Parse(self); err == nil && su.Host == u.Host {
+			return true
+		}
+	}
+	if u.Port() != cfg.ServicePort {
+		return false
+	}
+	host := u.Hostname()
+	if hostname, err := os.Hostname(); err == nil && host == hostname {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return host == "localhost" || (ip != nil && (ip.IsLoopback() || ip.IsUnspecified()))
+}
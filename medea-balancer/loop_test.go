@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestProxyLoopBroken(t *testing.T) {
+	// A balancer whose only cluster is the balancer itself
+	balancer := httptest.NewServer(withConfigSnapshot(checkLoop(newMux())))
+	defer balancer.Close()
+	var asks atomic.Int32
+	scout := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		asks.Add(1)
+		fmt.Fprintf(w, `{"cluster": %q}`, balancer.URL)
+	}))
+	defer scout.Close()
+	store = clusterStore{cluster: balancer.URL}
+	defer func() {
+		store = nil
+		currentConfig.Store(nil)
+	}()
+	u, _ := url.Parse(balancer.URL)
+
+	tests := []struct {
+		name        string
+		servicePort string
+		selfURLs    []string
+		req         func() *http.Request
+		wantAsks    int32
+	}{
+		// Seen as another balancer, the request goes around until the hop count runs out
+		{"submit through other balancers", "1", nil, loopSubmit(balancer.URL), 4},
+		{"proxy through other balancers", "1", nil, loopProxy(balancer.URL), 0},
+		// Its own address is refused right away
+		{"submit to its own port", u.Port(), nil, loopSubmit(balancer.URL), 1},
+		{"submit to a MEDEA_SELF_URLS entry", "1", []string{balancer.URL}, loopSubmit(balancer.URL), 1},
+		{"proxy to its own port", u.Port(), nil, loopProxy(balancer.URL), 0},
+	}
+	for _, tt := range tests {
+		currentConfig.Store(&Config{
+			APIVersion: "1", APIVersions: []string{"1"}, ResourceKinds: defaultResourceKinds, MemoryUnit: memoryGB,
+			MedeaScout: scout.URL, ProxyTimeout: 5 * time.Second, SelfURLs: tt.selfURLs, MaxHops: 3,
+			StartupConfig: StartupConfig{ServicePort: tt.servicePort},
+		})
+		asks.Store(0)
+		resp, err := http.DefaultClient.Do(tt.req())
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusLoopDetected {
+			t.Errorf("%s: answered %d, want 508", tt.name, resp.StatusCode)
+		}
+		if n := asks.Load(); n != tt.wantAsks {
+			t.Errorf("%s: scout asked %d times, want %d", tt.name, n, tt.wantAsks)
+		}
+	}
+}
+
+// loopSubmit and loopProxy build a fresh request to the balancer at base for every case
+func loopSubmit(base string) func() *http.Request {
+	return func() *http.Request {
+		r, _ := http.NewRequest(http.MethodPost, base+"/api/v1/workflows/batch-a/submit", strings.NewReader(`{"resourceKind": "WorkflowTemplate", "resourceName": "tpl"}`))
+		r.Header.Set("tuz", "svc-a")
+		return r
+	}
+}
+
+func loopProxy(base string) func() *http.Request {
+	return func() *http.Request {
+		r, _ := http.NewRequest(http.MethodGet, base+"/api/v1/workflows/batch-a/wf-1", nil)
+		r.Header.Set("tuz", "svc-a")
+		return r
+	}
+}
+
+func TestCheckLoop(t *testing.T) {
+	tests := []struct {
+		hops       string
+		maxHops    int
+		wantStatus int
+	}{
+		{"", 3, http.StatusNoContent},
+		{"3", 3, http.StatusNoContent},
+		{"4", 3, http.StatusLoopDetected},
+		{"garbage", 3, http.StatusNoContent},
+		{"100", 0, http.StatusNoContent},
+	}
+	h := checkLoop(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }))
+	for _, tt := range tests {
+		currentConfig.Store(&Config{MaxHops: tt.maxHops})
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/workflows/batch-a/wf-1", nil)
+		if tt.hops != "" {
+			r.Header.Set(hopsHeader, tt.hops)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s=%q with a maximum of %d: answered %d, want %d", hopsHeader, tt.hops, tt.maxHops, w.Code, tt.wantStatus)
+		}
+	}
+	currentConfig.Store(nil)
+}
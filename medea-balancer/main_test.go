@@ -0,0 +1,836 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIsDriverOnly(t *testing.T) {
+	tests := []struct {
+		name   string
+		params []string
+		want   bool
+	}{
+		{"explicit zero", []string{"executor_num=0"}, true},
+		{"decimal zero", []string{"executor_num=0.0"}, true},
+		{"executors", []string{"executor_num=3"}, false},
+		{"missing uses template default", []string{"driver_cores_limit=1"}, false},
+		{"no params", nil, false},
+		{"invalid", []string{"executor_num=none"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isDriverOnly(tt.params); got != tt.want {
+				t.Errorf("isDriverOnly(%q) = %v, want %v", tt.params, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubmitDeprecationHeaders(t *testing.T) {
+	argo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"kind": "Workflow", "metadata": {"name": "wf-1"}}`))
+	}))
+	defer argo.Close()
+	scout := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/request" {
+			fmt.Fprintf(w, `{"cluster": %q}`, argo.URL)
+		}
+	}))
+	defer scout.Close()
+
+	store = &recordingStore{}
+	defer func() { store = nil }()
+	cfg := &Config{
+		APIVersion: "1", APIVersions: []string{"1"}, ResourceKinds: defaultResourceKinds, MemoryUnit: memoryGB,
+		ProxyTimeout: 5 * time.Second, DeprecationWarnings: true,
+		DeprecatedParams: []KeyValue{{Key: "executors_num", Value: "executor_num"}},
+	}
+	tests := []struct {
+		name       string
+		body       string
+		deprecated []string
+	}{
+		{"current format", `{"submitOptions": {"parameters": ["executor_num=2"]}}`, nil},
+		{"deprecated parameter", `{"submitOptions": {"parameters": ["executors_num=2"]}}`, []string{"executors_num"}},
+		{"deprecated and current parameter", `{"submitOptions": {"parameters": ["executors_num=2", "executor_num=2"]}}`, []string{"executors_num"}},
+		{"flat parameters", `{"parameters": ["executor_num=2"]}`, []string{"parameters"}},
+		{"flat deprecated parameter", `{"parameters": ["executors_num=2"], "submitOptions": {"parameters": ["executors_num=2"]}}`, []string{"parameters", "executors_num"}},
+	}
+	for _, tt := range tests {
+		body := `{"resourceKind": "WorkflowTemplate", "resourceName": "tpl", ` + strings.TrimPrefix(tt.body, "{")
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/workflows/batch-a/submit", strings.NewReader(body))
+		r.SetPathValue("namespace", "batch-a")
+		r = r.WithContext(context.WithValue(r.Context(), configKey{}, cfg))
+		w := httptest.NewRecorder()
+		handleSubmit(w, r, scout.URL)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: answered %d %q, want 200", tt.name, w.Code, strings.TrimSpace(w.Body.String()))
+		}
+		if got := w.Header().Values("X-Medea-Deprecated"); !slices.Equal(got, tt.deprecated) {
+			t.Errorf("%s: X-Medea-Deprecated = %q, want %q", tt.name, got, tt.deprecated)
+		}
+		if got := w.Header().Values("Warning"); len(got) != len(tt.deprecated) {
+			t.Errorf("%s: Warning = %q, want %d", tt.name, got, len(tt.deprecated))
+		}
+	}
+}
+
+func TestScoutFreeMemGB(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	tests := []struct {
+		name    string
+		ourUnit string
+		resp    ScoutResponse
+		want    *float64
+	}{
+		{"no headroom", memoryMiB, ScoutResponse{}, nil},
+		{"GB answer", memoryMiB, ScoutResponse{FreeMem: f(3), RAMUnit: memoryGB}, f(3)},
+		{"MiB answer", memoryGB, ScoutResponse{FreeMem: f(2048), RAMUnit: memoryMiB}, f(2)},
+		{"older scout in GB", memoryGB, ScoutResponse{FreeMem: f(3)}, f(3)},
+		{"older scout in MiB", memoryMiB, ScoutResponse{FreeMem: f(512)}, f(0.5)},
+	}
+	for _, tt := range tests {
+		got := scoutFreeMemGB(&Config{MemoryUnit: tt.ourUnit}, tt.resp)
+		if (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+			t.Errorf("%s: scoutFreeMemGB = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSubmitResourceKind(t *testing.T) {
+	tests := []struct {
+		kinds  []string
+		kind   string
+		wantOK bool
+	}{
+		{defaultResourceKinds, "WorkflowTemplate", true},
+		{defaultResourceKinds, "CronWorkflow", true},
+		{defaultResourceKinds, "Workflow", true},
+		{defaultResourceKinds, "ClusterWorkflowTemplate", true},
+		{defaultResourceKinds, "WorkfowTemplate", false},
+		{defaultResourceKinds, "workflowtemplate", false},
+		{defaultResourceKinds, "", false},
+		{[]string{"Workflow", "Sensor"}, "Sensor", true},
+		{[]string{"Workflow", "Sensor"}, "WorkflowTemplate", false},
+	}
+	for _, tt := range tests {
+		cfg := &Config{APIVersion: "1", APIVersions: []string{"1"}, ResourceKinds: tt.kinds}
+		body := `{"resourceKind": "` + tt.kind + `", "resourceName": "tpl", "submitOptions": {"parameters": []}}`
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/workflows/batch-a/submit", strings.NewReader(body))
+		r.SetPathValue("namespace", "batch-a")
+		r = r.WithContext(context.WithValue(r.Context(), configKey{}, cfg))
+		w := httptest.NewRecorder()
+		// No scout: accepted kinds fail later, when the placement is asked for
+		handleSubmit(w, r, "http://127.0.0.1:1")
+		refused := w.Code == http.StatusBadRequest && strings.Contains(w.Body.String(), "Unsupported resourceKind")
+		if refused == tt.wantOK {
+			t.Errorf("kind %q with %v: answered %d %q, want accepted %v", tt.kind, tt.kinds, w.Code, strings.TrimSpace(w.Body.String()), tt.wantOK)
+		}
+	}
+}
+
+func TestSubmitAPIVersion(t *testing.T) {
+	cfg := &Config{APIVersion: "2", APIVersions: []string{"1", "2"}, ResourceKinds: defaultResourceKinds}
+	tests := []struct {
+		name        string
+		header      string
+		wantRefused bool
+		wantVersion string
+	}{
+		{"supported", "1", false, "1"},
+		{"current", "2", false, "2"},
+		{"absent", "", false, "2"},
+		{"unsupported", "3", true, ""},
+	}
+	for _, tt := range tests {
+		body := `{"resourceKind": "WorkflowTemplate", "resourceName": "tpl", "submitOptions": {"parameters": []}}`
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/workflows/batch-a/submit", strings.NewReader(body))
+		r.SetPathValue("namespace", "batch-a")
+		if tt.header != "" {
+			r.Header.Set("X-Medea-Api-Version", tt.header)
+		}
+		r = r.WithContext(context.WithValue(r.Context(), configKey{}, cfg))
+		w := httptest.NewRecorder()
+		// No scout: accepted versions fail later, when the placement is asked for
+		handleSubmit(w, r, "http://127.0.0.1:1")
+		refused := w.Code == http.StatusBadRequest && strings.Contains(w.Body.String(), "Unsupported X-Medea-Api-Version")
+		if refused != tt.wantRefused {
+			t.Errorf("%s: answered %d %q, want refused %v", tt.name, w.Code, strings.TrimSpace(w.Body.String()), tt.wantRefused)
+		}
+		// The answer says which version the submit was read as
+		if got := w.Header().Get("X-Medea-Api-Version"); got != tt.wantVersion {
+			t.Errorf("%s: answered with version %q, want %q", tt.name, got, tt.wantVersion)
+		}
+	}
+}
+
+func TestResourceKindsFromEnv(t *testing.T) {
+	t.Setenv("DB_DRIVER", "sqlite")
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(cfg.ResourceKinds, defaultResourceKinds) {
+		t.Errorf("default kinds = %v, want %v", cfg.ResourceKinds, defaultResourceKinds)
+	}
+	t.Setenv("MEDEA_RESOURCE_KINDS", "Workflow, Sensor")
+	if cfg, err = loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Workflow", "Sensor"}; !slices.Equal(cfg.ResourceKinds, want) {
+		t.Errorf("MEDEA_RESOURCE_KINDS kinds = %v, want %v", cfg.ResourceKinds, want)
+	}
+}
+
+func TestSubmitBodyOverClusterLimit(t *testing.T) {
+	var forwarded atomic.Int32
+	argo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded.Add(1)
+		w.Write([]byte(`{"kind": "Workflow", "metadata": {"name": "wf-1"}}`))
+	}))
+	defer argo.Close()
+	// Scout places the first submit on the limited cluster, the others on one without a limit
+	var asks atomic.Int32
+	scout := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/request" {
+			return
+		}
+		cluster := argo.URL
+		if asks.Add(1) > 2 {
+			cluster = strings.Replace(argo.URL, "127.0.0.1", "localhost", 1)
+		}
+		fmt.Fprintf(w, `{"cluster": %q}`, cluster)
+	}))
+	defer scout.Close()
+
+	store = &recordingStore{}
+	defer func() { store = nil }()
+	cfg := &Config{
+		APIVersion: "1", APIVersions: []string{"1"}, ResourceKinds: defaultResourceKinds, MemoryUnit: memoryGB,
+		ProxyTimeout: 5 * time.Second, ClusterMaxBody: map[string]int64{argo.URL: 200},
+	}
+	small := `{"resourceKind": "WorkflowTemplate", "resourceName": "tpl", "submitOptions": {"parameters": ["executor_num=1"]}}`
+	large := `{"resourceKind": "WorkflowTemplate", "resourceName": "tpl", "submitOptions": {"parameters": ["executor_num=1", "data=` + strings.Repeat("x", 500) + `"]}}`
+	tests := []struct {
+		name        string
+		body        string
+		wantStatus  int
+		wantForward bool
+	}{
+		{"body within the limit", small, http.StatusOK, true},
+		{"body over the limit", large, http.StatusRequestEntityTooLarge, false},
+		{"cluster without a limit", large, http.StatusOK, true},
+	}
+	for _, tt := range tests {
+		before := forwarded.Load()
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/workflows/batch-a/submit", strings.NewReader(tt.body))
+		r.SetPathValue("namespace", "batch-a")
+		r = r.WithContext(context.WithValue(r.Context(), configKey{}, cfg))
+		w := httptest.NewRecorder()
+		handleSubmit(w, r, scout.URL)
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: answered %d %q, want %d", tt.name, w.Code, strings.TrimSpace(w.Body.String()), tt.wantStatus)
+		}
+		if got := forwarded.Load() > before; got != tt.wantForward {
+			t.Errorf("%s: forwarded %v, want %v", tt.name, got, tt.wantForward)
+		}
+		if tt.wantStatus == http.StatusRequestEntityTooLarge && !strings.Contains(w.Body.String(), "limit of 200 bytes of cluster "+argo.URL) {
+			t.Errorf("%s: message %q doesn't name the limit and the cluster", tt.name, w.Body.String())
+		}
+	}
+}
+
+func TestSubmitRecordsInstanceID(t *testing.T) {
+	t.Setenv("DB_DRIVER", "sqlite")
+	host, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, env := range []string{"", "balancer-eu-1"} {
+		t.Setenv("MEDEA_INSTANCE_ID", env)
+		cfg, err := loadConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := cmp.Or(env, host); cfg.InstanceID != want {
+			t.Errorf("MEDEA_INSTANCE_ID=%q: instance ID %q, want %q", env, cfg.InstanceID, want)
+		}
+	}
+
+	argo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"kind": "Workflow", "metadata": {"name": "wf-1"}}`))
+	}))
+	defer argo.Close()
+	scout := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/request" {
+			fmt.Fprintf(w, `{"cluster": %q}`, argo.URL)
+		}
+	}))
+	defer scout.Close()
+	rs := &recordingStore{}
+	store = rs
+	defer func() { store = nil }()
+	cfg := &Config{
+		APIVersion: "1", APIVersions: []string{"1"}, ResourceKinds: defaultResourceKinds, MemoryUnit: memoryGB,
+		ProxyTimeout: 5 * time.Second, InstanceID: "balancer-eu-1",
+	}
+	body := `{"resourceKind": "WorkflowTemplate", "resourceName": "tpl", "submitOptions": {"parameters": ["executor_num=1"]}}`
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/workflows/batch-a/submit", strings.NewReader(body))
+	r.SetPathValue("namespace", "batch-a")
+	r = r.WithContext(context.WithValue(r.Context(), configKey{}, cfg))
+	w := httptest.NewRecorder()
+	handleSubmit(w, r, scout.URL)
+	if w.Code != http.StatusOK {
+		t.Fatalf("submit answered %d %q", w.Code, strings.TrimSpace(w.Body.String()))
+	}
+	if len(rs.saved) != 1 || rs.saved[0].Balancer != "balancer-eu-1" {
+		t.Errorf("saved %+v, want one record placed by balancer-eu-1", rs.saved)
+	}
+}
+
+func TestGetTargetClusterRetries(t *testing.T) {
+	cfg := &Config{ScoutRetries: 2, ScoutRetryBackoff: time.Millisecond}
+	tests := []struct {
+		name         string
+		answers      []int // status per attempt, 0 drops the connection; the last one repeats
+		wantAttempts int32
+		wantCluster  string
+		wantNoFit    bool
+	}{
+		{"5xx then success", []int{http.StatusBadGateway, http.StatusOK}, 2, "east", false},
+		{"dropped connection then success", []int{0, http.StatusOK}, 2, "east", false},
+		{"404 is not retried", []int{http.StatusNotFound}, 1, "", true},
+		{"5xx until the retries run out", []int{http.StatusInternalServerError}, 3, "", false},
+		{"4xx is not retried", []int{http.StatusBadRequest}, 1, "", false},
+	}
+	for _, tt := range tests {
+		var attempts atomic.Int32
+		scout := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := int(attempts.Add(1))
+			status := tt.answers[min(n, len(tt.answers))-1]
+			switch status {
+			case 0:
+				conn, _, _ := http.NewResponseController(w).Hijack()
+				conn.Close()
+			case http.StatusOK:
+				w.Write([]byte(`{"cluster": "east"}`))
+			case http.StatusNotFound:
+				w.WriteHeader(status)
+				w.Write([]byte(`{"reason": {"cpu": 1}}`))
+			default:
+				w.WriteHeader(status)
+			}
+		}))
+		ctx := context.WithValue(context.Background(), configKey{}, cfg)
+		decision, err := getTargetCluster(ctx, scout.URL, ScoutRequest{Namespace: "batch-a"})
+		scout.Close()
+		if got := attempts.Load(); got != tt.wantAttempts {
+			t.Errorf("%s: scout asked %d times, want %d", tt.name, got, tt.wantAttempts)
+		}
+		if decision.Cluster != tt.wantCluster || (err == nil) != (tt.wantCluster != "") {
+			t.Errorf("%s: got cluster %q, error %v, want cluster %q", tt.name, decision.Cluster, err, tt.wantCluster)
+		}
+		var nc *noClusterError
+		if errors.As(err, &nc) != tt.wantNoFit {
+			t.Errorf("%s: error %v, want no-capacity %v", tt.name, err, tt.wantNoFit)
+		}
+	}
+
+	// Retries end with the request
+	scout := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer scout.Close()
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), configKey{}, &Config{ScoutRetries: 5, ScoutRetryBackoff: time.Second}), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := getTargetCluster(ctx, scout.URL, ScoutRequest{Namespace: "batch-a"}); err == nil {
+		t.Error("a failing scout went unnoticed")
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("retries took %v past the request deadline", d)
+	}
+}
+
+func TestSubmitPassesPreferredCluster(t *testing.T) {
+	var forwarded string
+	argo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		forwarded = string(body)
+		w.Write([]byte(`{"kind": "Workflow", "metadata": {"name": "wf-1"}}`))
+	}))
+	defer argo.Close()
+	var asked ScoutRequest
+	scout := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/request" {
+			asked = ScoutRequest{}
+			json.NewDecoder(r.Body).Decode(&asked)
+			fmt.Fprintf(w, `{"cluster": %q}`, argo.URL)
+		}
+	}))
+	defer scout.Close()
+	store = &recordingStore{}
+	defer func() { store = nil }()
+	cfg := &Config{
+		APIVersion: "1", APIVersions: []string{"1"}, ResourceKinds: defaultResourceKinds, MemoryUnit: memoryGB,
+		ProxyTimeout: 5 * time.Second,
+	}
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"no hint", ``, ""},
+		{"hint", `, "preferredCluster": "east"`, "east"},
+	}
+	for _, tt := range tests {
+		body := `{"resourceKind": "WorkflowTemplate", "resourceName": "tpl", "submitOptions": {"parameters": ["executor_num=1"]}` + tt.body + `}`
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/workflows/batch-a/submit", strings.NewReader(body))
+		r.SetPathValue("namespace", "batch-a")
+		r = r.WithContext(context.WithValue(r.Context(), configKey{}, cfg))
+		w := httptest.NewRecorder()
+		handleSubmit(w, r, scout.URL)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: answered %d %q", tt.name, w.Code, strings.TrimSpace(w.Body.String()))
+		}
+		if asked.PreferredCluster != tt.want {
+			t.Errorf("%s: scout asked to prefer %q, want %q", tt.name, asked.PreferredCluster, tt.want)
+		}
+		// The hint is for scout, Argo never sees it
+		if strings.Contains(forwarded, "preferredCluster") {
+			t.Errorf("%s: forwarded %s", tt.name, forwarded)
+		}
+	}
+}
+
+// affinityStore knows the last cluster of each template
+type affinityStore struct {
+	recordingStore
+	last map[string]string
+	err  error
+}
+
+func (s *affinityStore) LastTemplateCluster(template, ns string) (string, error) {
+	if s.err != nil {
+		return "", s.err
+	}
+	cluster, ok := s.last[ns+"/"+template]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+	return cluster, nil
+}
+
+func TestSubmitTemplateAffinity(t *testing.T) {
+	argo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"kind": "Workflow", "metadata": {"name": "wf-1"}}`))
+	}))
+	defer argo.Close()
+	// Scout honours the preference only when that cluster fits, the full one never does
+	var asked ScoutRequest
+	scout := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		asked = ScoutRequest{}
+		json.NewDecoder(r.Body).Decode(&asked)
+		cluster := argo.URL
+		if asked.PreferredCluster != "" && asked.PreferredCluster != "full" {
+			cluster = asked.PreferredCluster
+		}
+		fmt.Fprintf(w, `{"cluster": %q}`, cluster)
+	}))
+	defer scout.Close()
+	defer func() { store = nil }()
+
+	tests := []struct {
+		name        string
+		affinity    bool
+		template    string
+		lookupErr   error
+		hint        string
+		wantPrefer  string
+		wantCluster string
+	}{
+		{"hit", true, "tpl", nil, "", argo.URL, argo.URL},
+		{"last cluster is full", true, "busy", nil, "", "full", argo.URL},
+		{"template never ran", true, "new", nil, "", "", argo.URL},
+		{"lookup fails", true, "tpl", errors.New("database down"), "", "", argo.URL},
+		{"client hint wins", true, "tpl", nil, "east", "east", "east"},
+		{"disabled", false, "tpl", nil, "", "", argo.URL},
+	}
+	for _, tt := range tests {
+		st := &affinityStore{last: map[string]string{"batch-a/tpl": argo.URL, "batch-a/busy": "full"}, err: tt.lookupErr}
+		store = st
+		cfg := &Config{
+			APIVersion: "1", APIVersions: []string{"1"}, ResourceKinds: defaultResourceKinds, MemoryUnit: memoryGB,
+			ProxyTimeout: 5 * time.Second, TemplateAffinity: tt.affinity,
+		}
+		body := `{"resourceKind": "WorkflowTemplate", "resourceName": "` + tt.template + `", "submitOptions": {"parameters": []}`
+		if tt.hint != "" {
+			body += `, "preferredCluster": "` + tt.hint + `"`
+		}
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/workflows/batch-a/submit", strings.NewReader(body+`}`))
+		r.SetPathValue("namespace", "batch-a")
+		r = r.WithContext(context.WithValue(r.Context(), configKey{}, cfg))
+		w := httptest.NewRecorder()
+		handleSubmit(w, r, scout.URL)
+		if asked.PreferredCluster != tt.wantPrefer {
+			t.Errorf("%s: scout asked to prefer %q, want %q", tt.name, asked.PreferredCluster, tt.wantPrefer)
+		}
+		if tt.wantCluster != argo.URL {
+			// The hinted cluster isn't served by the fake Argo, only the placement matters
+			continue
+		}
+		if w.Code != http.StatusOK || len(st.saved) != 1 || st.saved[0].Cluster != tt.wantCluster {
+			t.Errorf("%s: answered %d, recorded %+v, want a placement on %s", tt.name, w.Code, st.saved, tt.wantCluster)
+		}
+	}
+}
+
+// activeStore reports the workflows in active as running
+type activeStore struct {
+	recordingStore
+	active []string
+}
+
+func (s *activeStore) ActiveWorkflowExists(wfName, ns string) (bool, error) {
+	return slices.Contains(s.active, ns+"/"+wfName), nil
+}
+
+func TestSubmitNameCollision(t *testing.T) {
+	var forwarded atomic.Int32
+	argo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded.Add(1)
+		w.Write([]byte(`{"kind": "Workflow", "metadata": {"name": "wf-1"}}`))
+	}))
+	defer argo.Close()
+	scout := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/request" {
+			fmt.Fprintf(w, `{"cluster": %q}`, argo.URL)
+		}
+	}))
+	defer scout.Close()
+	store = &activeStore{active: []string{"batch-a/nightly"}}
+	defer func() { store = nil }()
+
+	tests := []struct {
+		name       string
+		reject     bool
+		wfName     string
+		wantStatus int
+	}{
+		{"active name", true, "nightly", http.StatusConflict},
+		{"free name", true, "weekly", http.StatusOK},
+		{"generated name", true, "", http.StatusOK},
+		{"active name, only logged", false, "nightly", http.StatusOK},
+	}
+	for _, tt := range tests {
+		cfg := &Config{
+			APIVersion: "1", APIVersions: []string{"1"}, ResourceKinds: defaultResourceKinds, MemoryUnit: memoryGB,
+			ProxyTimeout: 5 * time.Second, RejectNameCollisions: tt.reject,
+		}
+		before := forwarded.Load()
+		body := fmt.Sprintf(`{"resourceKind": "WorkflowTemplate", "resourceName": "tpl", "submitOptions": {"name": %q, "parameters": ["executor_num=1"]}}`, tt.wfName)
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/workflows/batch-a/submit", strings.NewReader(body))
+		r.SetPathValue("namespace", "batch-a")
+		r = r.WithContext(context.WithValue(r.Context(), configKey{}, cfg))
+		w := httptest.NewRecorder()
+		handleSubmit(w, r, scout.URL)
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: answered %d %q, want %d", tt.name, w.Code, strings.TrimSpace(w.Body.String()), tt.wantStatus)
+		}
+		if got := forwarded.Load() > before; got != (tt.wantStatus == http.StatusOK) {
+			t.Errorf("%s: forwarded %v", tt.name, got)
+		}
+	}
+}
+
+func TestNamespaceHeaderOverride(t *testing.T) {
+	var argoPath string
+	argo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		argoPath = r.URL.Path
+		w.Write([]byte(`{"kind": "Workflow", "metadata": {"name": "wf-1"}}`))
+	}))
+	defer argo.Close()
+	var asked ScoutRequest
+	scout := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/request" {
+			json.NewDecoder(r.Body).Decode(&asked)
+			fmt.Fprintf(w, `{"cluster": %q}`, argo.URL)
+		}
+	}))
+	defer scout.Close()
+	defer func() { store = nil }()
+
+	tests := []struct {
+		name    string
+		allowed bool
+		header  string
+		want    string
+	}{
+		{"header allowed", true, "tenant-b", "tenant-b"},
+		{"header allowed but absent", true, "", "batch-a"},
+		{"header not allowed", false, "tenant-b", "batch-a"},
+	}
+	for _, tt := range tests {
+		rs := &recordingStore{}
+		store = rs
+		cfg := &Config{
+			APIVersion: "1", APIVersions: []string{"1"}, ResourceKinds: defaultResourceKinds, MemoryUnit: memoryGB,
+			ProxyTimeout: 5 * time.Second, AllowNamespaceHeader: tt.allowed,
+		}
+		body := `{"resourceKind": "WorkflowTemplate", "resourceName": "tpl", "submitOptions": {"parameters": ["executor_num=1"]}}`
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/workflows/batch-a/submit", strings.NewReader(body))
+		r.SetPathValue("namespace", "batch-a")
+		if tt.header != "" {
+			r.Header.Set("X-Medea-Namespace", tt.header)
+		}
+		r = r.WithContext(context.WithValue(r.Context(), configKey{}, cfg))
+		w := httptest.NewRecorder()
+		handleSubmit(w, r, scout.URL)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: answered %d %q", tt.name, w.Code, strings.TrimSpace(w.Body.String()))
+		}
+		if asked.Namespace != tt.want {
+			t.Errorf("%s: placed for %q, want %q", tt.name, asked.Namespace, tt.want)
+		}
+		if len(rs.saved) != 1 || rs.saved[0].Namespace != tt.want {
+			t.Errorf("%s: recorded %+v, want namespace %q", tt.name, rs.saved, tt.want)
+		}
+		// Argo always gets the path namespace
+		if argoPath != "/api/v1/workflows/batch-a/submit" {
+			t.Errorf("%s: forwarded to %s", tt.name, argoPath)
+		}
+	}
+}
+
+func TestAllowedNamespaces(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		path    string
+		header  string
+		want    bool
+	}{
+		{"no allowlist", nil, "anything", "", true},
+		{"listed", []string{"batch-a", "batch-b"}, "batch-b", "", true},
+		{"not listed", []string{"batch-a", "batch-b"}, "batch-c", "", false},
+		{"wildcard", []string{"team-*"}, "team-etl", "", true},
+		{"wildcard not matched", []string{"team-*"}, "teams", "", false},
+		{"header namespace not listed", []string{"team-*"}, "team-etl", "batch-a", false},
+		{"header and path listed", []string{"team-*"}, "team-etl", "team-ml", true},
+	}
+	for _, tt := range tests {
+		cfg := &Config{
+			APIVersion: "1", APIVersions: []string{"1"}, ResourceKinds: defaultResourceKinds, MemoryUnit: memoryGB,
+			AllowedNamespaces: tt.allowed, AllowNamespaceHeader: true,
+		}
+		body := `{"resourceKind": "WorkflowTemplate", "resourceName": "tpl", "submitOptions": {"parameters": []}}`
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/workflows/"+tt.path+"/submit", strings.NewReader(body))
+		r.SetPathValue("namespace", tt.path)
+		if tt.header != "" {
+			r.Header.Set("X-Medea-Namespace", tt.header)
+		}
+		r = r.WithContext(context.WithValue(r.Context(), configKey{}, cfg))
+		w := httptest.NewRecorder()
+		// No scout: allowed submits fail later, when the placement is asked for
+		handleSubmit(w, r, "http://127.0.0.1:1")
+		if denied := w.Code == http.StatusForbidden; denied == tt.want {
+			t.Errorf("%s: answered %d %q, want allowed %v", tt.name, w.Code, strings.TrimSpace(w.Body.String()), tt.want)
+		}
+	}
+}
+
+// countStore counts its active workflows and how often it was asked
+type countStore struct {
+	Store
+	active int
+	calls  int
+}
+
+func (s *countStore) CountActive() (int, error) {
+	s.calls++
+	return s.active, nil
+}
+
+func TestMaxActiveWorkflows(t *testing.T) {
+	cs := &countStore{}
+	store = cs
+	defer func() {
+		store = nil
+		activeCount = countCache{}
+	}()
+	submit := func(cfg *Config, query string) int {
+		body := `{"resourceKind": "WorkflowTemplate", "resourceName": "tpl", "submitOptions": {"parameters": []}}`
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/workflows/batch-a/submit"+query, strings.NewReader(body))
+		r.SetPathValue("namespace", "batch-a")
+		r = r.WithContext(context.WithValue(r.Context(), configKey{}, cfg))
+		w := httptest.NewRecorder()
+		// No scout: admitted submits fail later, when the placement is asked for
+		handleSubmit(w, r, "http://127.0.0.1:1")
+		return w.Code
+	}
+	cfg := &Config{
+		APIVersion: "1", APIVersions: []string{"1"}, ResourceKinds: defaultResourceKinds, MemoryUnit: memoryGB,
+		MaxActiveWorkflows: 3, ActiveCountTTL: time.Hour,
+	}
+	tests := []struct {
+		name      string
+		active    int
+		query     string
+		fresh     bool // the cached count has expired
+		wantFull  bool
+		wantCalls int
+	}{
+		{"under the cap", 2, "", true, false, 1},
+		{"at the cap, count still cached", 3, "", false, false, 1},
+		{"at the cap", 3, "", true, true, 2},
+		{"over the cap, dry-run", 5, "?dryRun=true", false, false, 2},
+		{"under the cap, count still cached", 0, "", false, true, 2},
+	}
+	for _, tt := range tests {
+		cs.active = tt.active
+		if tt.fresh {
+			activeCount.fetchedAt = time.Time{}
+		}
+		full := submit(cfg, tt.query) == http.StatusServiceUnavailable
+		if full != tt.wantFull {
+			t.Errorf("%s: rejected as full %v, want %v", tt.name, full, tt.wantFull)
+		}
+		if cs.calls != tt.wantCalls {
+			t.Errorf("%s: counted %d times, want %d", tt.name, cs.calls, tt.wantCalls)
+		}
+	}
+}
+
+func TestSubmitEmptyResourceName(t *testing.T) {
+	argo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"kind": "Workflow", "metadata": {"name": "wf-1"}}`))
+	}))
+	defer argo.Close()
+	scout := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/request" {
+			fmt.Fprintf(w, `{"cluster": %q}`, argo.URL)
+		}
+	}))
+	defer scout.Close()
+	defer func() { store = nil }()
+
+	tests := []struct {
+		name         string
+		resourceName string
+		sentinel     string
+		wantStatus   int
+		wantTemplate string
+	}{
+		{"named", "tpl", "", http.StatusOK, "tpl"},
+		{"empty", "", "", http.StatusBadRequest, ""},
+		{"blank", "  ", "", http.StatusBadRequest, ""},
+		{"empty with a sentinel", "", "(none)", http.StatusOK, "(none)"},
+	}
+	for _, tt := range tests {
+		rs := &recordingStore{}
+		store = rs
+		cfg := &Config{
+			APIVersion: "1", APIVersions: []string{"1"}, ResourceKinds: defaultResourceKinds, MemoryUnit: memoryGB,
+			ProxyTimeout: 5 * time.Second, EmptyResourceName: tt.sentinel,
+		}
+		body := fmt.Sprintf(`{"resourceKind": "Workflow", "resourceName": %q, "submitOptions": {"parameters": ["executor_num=1"]}}`, tt.resourceName)
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/workflows/batch-a/submit", strings.NewReader(body))
+		r.SetPathValue("namespace", "batch-a")
+		r = r.WithContext(context.WithValue(r.Context(), configKey{}, cfg))
+		w := httptest.NewRecorder()
+		handleSubmit(w, r, scout.URL)
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: answered %d %q, want %d", tt.name, w.Code, strings.TrimSpace(w.Body.String()), tt.wantStatus)
+		}
+		var recorded []string
+		for _, rec := range rs.saved {
+			recorded = append(recorded, rec.Template)
+		}
+		if want := slices.DeleteFunc([]string{tt.wantTemplate}, func(s string) bool { return s == "" }); !slices.Equal(recorded, want) {
+			t.Errorf("%s: recorded templates %q, want %q", tt.name, recorded, want)
+		}
+	}
+}
+
+func TestSubmitPassesNoFitStatus(t *testing.T) {
+	cfg := &Config{APIVersion: "1", APIVersions: []string{"1"}, ResourceKinds: defaultResourceKinds, MemoryUnit: memoryGB, ProxyTimeout: 5 * time.Second}
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		wantStatus int
+	}{
+		{"no clusters", http.StatusNotFound, `{"reason": {}}`, http.StatusNotFound},
+		{"insufficient quota", http.StatusInsufficientStorage, `{"reason": {"cpu": 2}}`, http.StatusInsufficientStorage},
+		{"all excluded", http.StatusServiceUnavailable, `{"reason": {"excluded": 2}}`, http.StatusServiceUnavailable},
+		// Not scout's answer but a proxy in front of it
+		{"unavailable without a reason", http.StatusServiceUnavailable, ``, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		scout := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+			w.Write([]byte(tt.body))
+		}))
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/workflows/batch-a/submit", strings.NewReader(`{"resourceKind": "WorkflowTemplate", "resourceName": "tpl"}`))
+		r.SetPathValue("namespace", "batch-a")
+		r = r.WithContext(context.WithValue(r.Context(), configKey{}, cfg))
+		w := httptest.NewRecorder()
+		handleSubmit(w, r, scout.URL)
+		scout.Close()
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: answered %d, want %d", tt.name, w.Code, tt.wantStatus)
+		}
+	}
+}
+
+func TestSubmitPassesRetryAfter(t *testing.T) {
+	cfg := &Config{APIVersion: "1", APIVersions: []string{"1"}, ResourceKinds: defaultResourceKinds, MemoryUnit: memoryGB, ProxyTimeout: 5 * time.Second}
+	tests := []struct {
+		name       string
+		retryAfter string
+		dryRun     bool
+	}{
+		{"submit", "30", false},
+		{"dry-run", "30", true},
+		{"no hint", "", false},
+	}
+	for _, tt := range tests {
+		scout := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tt.retryAfter != "" {
+				w.Header().Set("Retry-After", tt.retryAfter)
+			}
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"reason": {"cpu": 1}}`))
+		}))
+		target := "/api/v1/workflows/batch-a/submit"
+		if tt.dryRun {
+			target += "?dryRun=true"
+		}
+		r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(`{"resourceKind": "WorkflowTemplate", "resourceName": "tpl"}`))
+		r.SetPathValue("namespace", "batch-a")
+		r = r.WithContext(context.WithValue(r.Context(), configKey{}, cfg))
+		w := httptest.NewRecorder()
+		handleSubmit(w, r, scout.URL)
+		scout.Close()
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: answered %d, want 404", tt.name, w.Code)
+		}
+		if got := w.Header().Get("Retry-After"); got != tt.retryAfter {
+			t.Errorf("%s: Retry-After %q, want scout's %q", tt.name, got, tt.retryAfter)
+		}
+	}
+}
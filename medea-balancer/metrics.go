@@ -0,0 +1,146 @@
+package main
+
+import (
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// otherNamespace collects namespaces beyond the label cap and rejected ones. Namespace names
+// can't contain an underscore, so it never collides with a real namespace.
+const otherNamespace = "_other"
+
+var submitDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "medea_balancer_submit_duration_seconds",
+	Help:    "End-to-end latency of workflow submits, by namespace.",
+	Buckets: prometheus.DefBuckets,
+}, []string{"namespace"})
+
+// Forwarding outcomes; code is the upstream status code, or "error" when no response came back
+var (
+	submitsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "medea_balancer_submits_total",
+		Help: "Submits forwarded to a cluster, by cluster and status code.",
+	}, []string{"cluster", "code"})
+	proxyRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "medea_balancer_proxy_requests_total",
+		Help: "Status, stop, delete and sub-resource requests proxied to a cluster, by method and status code.",
+	}, []string{"method", "code"})
+	coalescedRequestsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "medea_balancer_coalesced_requests_total",
+		Help: "Status requests answered with the result of an identical request already in flight.",
+	})
+	forwardDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "medea_balancer_forward_duration_seconds",
+		Help:    "Latency of calls forwarded to clusters, including submit retries, by kind (submit or proxy).",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"kind"})
+	scoutErrorsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "medea_balancer_scout_errors_total",
+		Help: "Failed calls to medea-scout; answers that no cluster fits are not errors.",
+	})
+	dbWriteFailuresTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "medea_balancer_db_write_failures_total",
+		Help: "Workflow records that could not be written to the database.",
+	})
+	mirrorWriteFailuresTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "medea_balancer_mirror_write_failures_total",
+		Help: "Writes that failed or were dropped on the mirror database (POSTGRESQL_MIRROR_URL).",
+	})
+	webhookFailuresTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "medea_balancer_webhook_failures_total",
+		Help: "Placement events that could not be delivered to a webhook destination or were dropped.",
+	})
+)
+
+// codeLabel is the code label of a forwarded call, "error" when it failed without a response
+func codeLabel(status int) string {
+	if status == 0 {
+		return "error"
+	}
+	return strconv.Itoa(status)
+}
+
+// namespaceLabels caps the number of distinct namespace label values. The first
+// MEDEA_METRICS_MAX_NAMESPACES accepted namespaces get their own label, everything else
+// shares otherNamespace, so junk requests can't use up the slots.
+var namespaceLabels = struct {
+	mu   sync.Mutex
+	seen map[string]bool
+}{seen: make(map[string]bool)}
+
+// claimNamespaceLabel gives an accepted namespace its own label while slots are left
+func claimNamespaceLabel(cfg *Config, ns string) {
+	namespaceLabels.mu.Lock()
+	defer namespaceLabels.mu.Unlock()
+	if !namespaceLabels.seen[ns] && len(namespaceLabels.seen) < cfg.MetricsMaxNamespaces {
+		namespaceLabels.seen[ns] = true
+	}
+}
+
+// namespaceLabel is the label of ns: its name once claimed, otherNamespace otherwise
+func namespaceLabel(ns string) string {
+	namespaceLabels.mu.Lock()
+	defer namespaceLabels.mu.Unlock()
+	if namespaceLabels.seen[ns] {
+		return ns
+	}
+	return otherNamespace
+}
+
+// activeWorkflows exports the active workflows per namespace and cluster from the database.
+// The counts are cached for MEDEA_ACTIVE_METRICS_TTL so frequent scrapes don't load the DB.
+type activeWorkflows struct {
+	desc *prometheus.Desc
+
+	mu        sync.Mutex
+	counts    []ActiveCount
+	fetchedAt time.Time
+}
+
+func newActiveWorkflows() *activeWorkflows {
+	return &activeWorkflows{desc: prometheus.NewDesc(
+		"medea_balancer_active_workflows",
+		"Workflows recorded in the database and not deleted, by namespace and cluster.",
+		[]string{"namespace", "cluster"}, nil,
+	)}
+}
+
+func (a *activeWorkflows) Describe(ch chan<- *prometheus.Desc) {
+	ch <- a.desc
+}
+
+func (a *activeWorkflows) Collect(ch chan<- prometheus.Metric) {
+	a.mu.Lock()
+	if a.fetchedAt.IsZero() || time.Since(a.fetchedAt) >= currentConfig.Load().ActiveMetricsTTL {
+		counts, err := store.ActiveCounts()
+		if err != nil {
+			a.mu.Unlock()
+			slog.Error("Counting active workflows for metrics failed", "error", err)
+			ch <- prometheus.NewInvalidMetric(a.desc, err)
+			return
+		}
+		a.counts, a.fetchedAt = counts, time.Now()
+	}
+	counts := a.counts
+	a.mu.Unlock()
+
+	// Recorded namespaces that are still allowed may claim a label, those beyond the cap
+	// are summed up under otherNamespace
+	cfg := currentConfig.Load()
+	type key struct{ namespace, cluster string }
+	sums := make(map[key]int)
+	for _, c := range counts {
+		if namespaceAllowed(cfg, c.Namespace) {
+			claimNamespaceLabel(cfg, c.Namespace)
+		}
+		sums[key{namespaceLabel(c.Namespace), c.Cluster}] += c.Count
+	}
+	for k, n := range sums {
+		ch <- prometheus.MustNewConstMetric(a.desc, prometheus.GaugeValue, float64(n), k.namespace, k.cluster)
+	}
+}
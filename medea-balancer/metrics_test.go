@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestNamespaceLabel(t *testing.T) {
+	namespaceLabels.seen = make(map[string]bool)
+	defer func() { namespaceLabels.seen = make(map[string]bool) }()
+
+	cfg := &Config{MetricsMaxNamespaces: 2}
+	// A rejected namespace is only looked up and must not take a slot
+	if got := namespaceLabel("junk"); got != otherNamespace {
+		t.Errorf("unclaimed namespace labeled %q, want %q", got, otherNamespace)
+	}
+	for _, ns := range []string{"batch-a", "batch-a", "batch-b", "batch-c"} {
+		claimNamespaceLabel(cfg, ns)
+	}
+
+	tests := []struct {
+		ns, want string
+	}{
+		{"batch-a", "batch-a"},
+		{"batch-b", "batch-b"},
+		{"batch-c", otherNamespace},
+		{"junk", otherNamespace},
+	}
+	for _, tt := range tests {
+		if got := namespaceLabel(tt.ns); got != tt.want {
+			t.Errorf("namespaceLabel(%q) = %q, want %q", tt.ns, got, tt.want)
+		}
+	}
+}
+
+func TestNamespaceLabelConcurrentClaims(t *testing.T) {
+	namespaceLabels.seen = make(map[string]bool)
+	defer func() { namespaceLabels.seen = make(map[string]bool) }()
+
+	cfg := &Config{MetricsMaxNamespaces: 3}
+	var wg sync.WaitGroup
+	for _, ns := range []string{"a", "b", "c", "d", "e", "f"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			claimNamespaceLabel(cfg, ns)
+		}()
+	}
+	wg.Wait()
+	if n := len(namespaceLabels.seen); n != 3 {
+		t.Errorf("%d namespaces claimed a label, want the cap of 3", n)
+	}
+}
+
+// activeCountsStore reports fixed active counts and how often they were asked for
+type activeCountsStore struct {
+	Store
+	mu     sync.Mutex
+	counts []ActiveCount
+	err    error
+	calls  int
+}
+
+func (s *activeCountsStore) ActiveCounts() ([]ActiveCount, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	return slices.Clone(s.counts), s.err
+}
+
+// gatherActive scrapes the collector and returns the gauges keyed by namespace/cluster
+func gatherActive(t *testing.T, reg *prometheus.Registry) (map[string]float64, error) {
+	t.Helper()
+	families, err := reg.Gather()
+	got := make(map[string]float64)
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			labels := make(map[string]string)
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			got[labels["namespace"]+"/"+labels["cluster"]] = m.GetGauge().GetValue()
+		}
+	}
+	return got, err
+}
+
+func TestActiveWorkflowsGauges(t *testing.T) {
+	namespaceLabels.seen = make(map[string]bool)
+	st := &activeCountsStore{counts: []ActiveCount{
+		{Namespace: "batch-a", Cluster: "east", Count: 3},
+		{Namespace: "batch-a", Cluster: "west", Count: 1},
+		{Namespace: "batch-b", Cluster: "east", Count: 2},
+		{Namespace: "batch-c", Cluster: "east", Count: 4},
+	}}
+	store = st
+	currentConfig.Store(&Config{MetricsMaxNamespaces: 2, ActiveMetricsTTL: time.Hour})
+	defer func() {
+		store = nil
+		currentConfig.Store(nil)
+		namespaceLabels.seen = make(map[string]bool)
+	}()
+	reg := prometheus.NewRegistry()
+	active := newActiveWorkflows()
+	reg.MustRegister(active)
+
+	got, err := gatherActive(t, reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Namespaces beyond the label cap are summed up
+	want := map[string]float64{"batch-a/east": 3, "batch-a/west": 1, "batch-b/east": 2, otherNamespace + "/east": 4}
+	if !maps.Equal(got, want) {
+		t.Errorf("gauges %v, want %v", got, want)
+	}
+
+	// Scrapes within the TTL don't reach the database
+	st.mu.Lock()
+	st.counts = append(st.counts, ActiveCount{Namespace: "batch-b", Cluster: "west", Count: 5})
+	st.mu.Unlock()
+	if got, _ := gatherActive(t, reg); !maps.Equal(got, want) || st.calls != 1 {
+		t.Errorf("cached scrape gave %v after %d queries, want %v from 1", got, st.calls, want)
+	}
+	// Once it expires the new state shows
+	active.mu.Lock()
+	active.fetchedAt = time.Now().Add(-2 * time.Hour)
+	active.mu.Unlock()
+	want["batch-b/west"] = 5
+	if got, _ := gatherActive(t, reg); !maps.Equal(got, want) {
+		t.Errorf("gauges %v after the TTL, want %v", got, want)
+	}
+
+	// A failing database fails the scrape instead of showing zeroes
+	st.err = errors.New("database down")
+	active.mu.Lock()
+	active.fetchedAt = time.Time{}
+	active.mu.Unlock()
+	if _, err := gatherActive(t, reg); err == nil {
+		t.Error("a failed count went unnoticed")
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA is a throwaway certificate authority that issues client certificates
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "medea test CA"},
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour),
+		IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testCA{cert, key}
+}
+
+// writePEM stores the CA certificate in a file, as MEDEA_MTLS_CA expects it
+func (ca testCA) writePEM(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// issue returns a client certificate for cn signed by the CA
+func (ca testCA) issue(t *testing.T, cn string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2), Subject: pkix.Name{CommonName: cn},
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour),
+		KeyUsage: x509.KeyUsageDigitalSignature, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestMTLS(t *testing.T) {
+	ca, other := newTestCA(t), newTestCA(t)
+	tlsCfg, err := mtlsConfig(ca.writePEM(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(checkTuzCN(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})))
+	srv.TLS = tlsCfg
+	// Refused handshakes are expected below
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.StartTLS()
+	defer srv.Close()
+
+	tests := []struct {
+		name       string
+		certs      []tls.Certificate
+		tuz        string
+		wantStatus int // 0 when the handshake must fail
+	}{
+		{"valid certificate", []tls.Certificate{ca.issue(t, "team-a")}, "", http.StatusNoContent},
+		{"tuz matching the CN", []tls.Certificate{ca.issue(t, "team-a")}, "team-a", http.StatusNoContent},
+		{"tuz of someone else", []tls.Certificate{ca.issue(t, "team-a")}, "team-b", http.StatusForbidden},
+		{"certificate of another CA", []tls.Certificate{other.issue(t, "team-a")}, "", 0},
+		{"no certificate", nil, "", 0},
+	}
+	for _, tt := range tests {
+		transport := srv.Client().Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig.Certificates = tt.certs
+		client := &http.Client{Transport: transport}
+		r, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		if tt.tuz != "" {
+			r.Header.Set("tuz", tt.tuz)
+		}
+		resp, err := client.Do(r)
+		if tt.wantStatus == 0 {
+			if err == nil {
+				resp.Body.Close()
+				t.Errorf("%s: connection accepted with status %d", tt.name, resp.StatusCode)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.wantStatus {
+			t.Errorf("%s: answered %d, want %d", tt.name, resp.StatusCode, tt.wantStatus)
+		}
+	}
+
+	if _, err := mtlsConfig(filepath.Join(t.TempDir(), "missing.pem")); err == nil {
+		t.Error("a missing CA file was accepted")
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPISpec describes the client-facing endpoints of the balancer. It is maintained by
+// hand, so changes to routes, bodies or answers must be reflected in openapi.json.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// handleOpenAPI serves the embedded OpenAPI spec unless MEDEA_OPENAPI=false
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if !configFrom(r.Context()).OpenAPI {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
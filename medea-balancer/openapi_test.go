@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPISpec(t *testing.T) {
+	currentConfig.Store(&Config{OpenAPI: true})
+	defer currentConfig.Store(nil)
+	mux := newMux()
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("GET /openapi.json answered %d with %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	var spec struct {
+		OpenAPI string `json:"openapi"`
+		Info    struct {
+			Title   string `json:"title"`
+			Version string `json:"version"`
+		} `json:"info"`
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &spec); err != nil {
+		t.Fatalf("spec is not valid JSON: %v", err)
+	}
+	if !strings.HasPrefix(spec.OpenAPI, "3.") || spec.Info.Title == "" || spec.Info.Version == "" || len(spec.Paths) == 0 {
+		t.Fatalf("spec lacks the required OpenAPI 3 fields: openapi %q, info %+v, %d paths", spec.OpenAPI, spec.Info, len(spec.Paths))
+	}
+
+	// Every documented operation is routed to a handler registered for exactly that route
+	documented := make(map[string]bool)
+	for path, item := range spec.Paths {
+		for method := range item {
+			if method == "parameters" {
+				continue
+			}
+			pattern := strings.ToUpper(method) + " " + path
+			documented[pattern] = true
+			r := httptest.NewRequest(strings.ToUpper(method), strings.NewReplacer("{namespace}", "ns", "{workflowName}", "wf").Replace(path), nil)
+			if _, got := mux.Handler(r); got != pattern {
+				t.Errorf("%s is documented but routed to %q", pattern, got)
+			}
+		}
+	}
+
+	// The client-facing routes are all documented
+	for _, pattern := range []string{
+		"POST /api/v1/workflows/{namespace}/submit",
+		"GET /api/v1/workflows/{namespace}",
+		"GET /api/v1/workflows/{namespace}/{workflowName}",
+		"DELETE /api/v1/workflows/{namespace}/{workflowName}",
+		"PUT /api/v1/workflows/{namespace}/{workflowName}/stop",
+		"GET " + exportPath,
+	} {
+		if !documented[pattern] {
+			t.Errorf("%s is routed but missing from openapi.json", pattern)
+		}
+	}
+
+	// MEDEA_OPENAPI=false hides the spec
+	currentConfig.Store(&Config{})
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("GET /openapi.json with the spec disabled answered %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Quantity parsing shared by everything that reads CPU or memory from a submit. Values follow
+// the Kubernetes notation plus the historic "g" of the submit parameters.
+
+// memoryUnits converts a memory suffix to GB. "g" is the historic unit of the submit
+// parameters, and the GB of scout's quota query are 1024^3 bytes, so Gi equals g.
+var memoryUnits = []struct {
+	suffix string
+	toGB   float64
+}{
+	{"Ki", 1.0 / (1 << 20)},
+	{"Mi", 1.0 / (1 << 10)},
+	{"Gi", 1},
+	{"Ti", 1 << 10},
+	{"K", 1e3 / (1 << 30)},
+	{"M", 1e6 / (1 << 30)},
+	{"G", 1e9 / (1 << 30)},
+	{"T", 1e12 / (1 << 30)},
+	{"g", 1},
+}
+
+// parseCPU reads a CPU quantity in cores: "2", "0.5" or millicores like "500m"
+func parseCPU(v string) (float64, error) {
+	num, milli := strings.CutSuffix(v, "m")
+	f, err := parseQuantityNumber(num)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cpu quantity %q", v)
+	}
+	if milli {
+		return f / 1000, nil
+	}
+	return f, nil
+}
+
+// parseMemoryGB reads a memory quantity like "0.5g", "2048Mi" or "4Gi" and returns it in GB.
+// A unit is required, a bare number is ambiguous between bytes and the historic g.
+func parseMemoryGB(v string) (float64, error) {
+	for _, u := range memoryUnits {
+		num, ok := strings.CutSuffix(v, u.suffix)
+		if !ok {
+			continue
+		}
+		f, err := parseQuantityNumber(num)
+		if err != nil {
+			return 0, fmt.Errorf("invalid memory quantity %q", v)
+		}
+		return f * u.toGB, nil
+	}
+	return 0, fmt.Errorf("memory quantity %q needs a unit: g, Ki, Mi, Gi, Ti, K, M, G or T", v)
+}
+
+// parseQuantityNumber reads the decimal number of a quantity. Unlike a bare strconv.ParseFloat
+// it refuses hex, underscores, NaN and Inf; negative values are left to validateQuantity.
+func parseQuantityNumber(s string) (float64, error) {
+	if s == "" || strings.Trim(s, "0123456789.+-eE") != "" {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	return strconv.ParseFloat(s, 64)
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseCPU(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    float64
+		wantErr bool
+	}{
+		{"2", 2, false},
+		{"0.5", 0.5, false},
+		{".25", 0.25, false},
+		{"500m", 0.5, false},
+		{"1500m", 1.5, false},
+		{"0.5m", 0.0005, false},
+		{"1e1", 10, false},
+		{"0", 0, false},
+		{"", 0, true},
+		{"m", 0, true},
+		{"2c", 0, true},
+		{"2 ", 0, true},
+		{"0x1p4", 0, true},
+		{"1_000", 0, true},
+		{"NaN", 0, true},
+		{"Inf", 0, true},
+		{"2Gi", 0, true},
+		{"1.2.3", 0, true},
+		// Negative values parse, validateQuantity refuses them
+		{"-1", -1, false},
+		{"-500m", -0.5, false},
+		{"-", 0, true},
+		{"--1", 0, true},
+		{"1-", 0, true},
+		{"+", 0, true},
+		{".", 0, true},
+		{"e5", 0, true},
+		{"1e", 0, true},
+		{"mm", 0, true},
+		{"500mm", 0, true},
+		{" 2", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseCPU(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseCPU(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("parseCPU(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseMemoryGB(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    float64
+		wantErr bool
+	}{
+		{"1g", 1, false},
+		{"0.5g", 0.5, false},
+		{"1048576Ki", 1, false},
+		{"2048Mi", 2, false},
+		{"512Mi", 0.5, false},
+		{"4Gi", 4, false},
+		{"1.5Gi", 1.5, false},
+		{"1Ti", 1024, false},
+		{"1073741824K", 1000, false},
+		{"1024M", 1024e6 / (1 << 30), false},
+		{"1G", 1e9 / (1 << 30), false},
+		{"1T", 1e12 / (1 << 30), false},
+		{"0Mi", 0, false},
+		{"4", 0, true},
+		{"", 0, true},
+		{"Gi", 0, true},
+		{"4gb", 0, true},
+		{"4GB", 0, true},
+		{"4Pi", 0, true},
+		{"4mi", 0, true},
+		{"0x1p4g", 0, true},
+		{"1_024Mi", 0, true},
+		{"NaNg", 0, true},
+		{"Infg", 0, true},
+		// Negative values parse, validateQuantity refuses them
+		{"-4g", -4, false},
+		{"-512Mi", -0.5, false},
+		{"-g", 0, true},
+		{"--4g", 0, true},
+		{"4-g", 0, true},
+		{".Gi", 0, true},
+		{"1e Gi", 0, true},
+		{"4GiB", 0, true},
+		{"4 Gi", 0, true},
+		{"g4", 0, true},
+		{"4gg", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseMemoryGB(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseMemoryGB(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("parseMemoryGB(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestValidateQuantity(t *testing.T) {
+	tests := []struct {
+		v       float64
+		wantErr string
+	}{
+		{0, ""},
+		{2.5, ""},
+		{math.MaxFloat64, ""},
+		{-0.001, "must not be negative"},
+		{math.Inf(-1), "must be a finite number"},
+		{math.Inf(1), "must be a finite number"},
+		{math.NaN(), "must be a finite number"},
+	}
+	for _, tt := range tests {
+		err := validateQuantity("executor_num", tt.v)
+		if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("validateQuantity(%v) error = %v, want %q", tt.v, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCalculateResourcesRejectsHexFloats(t *testing.T) {
+	cfg := &Config{MemoryUnit: memoryGB}
+	for _, params := range [][]string{
+		{"executor_num=0x1p4"},
+		{"executor_num=1_0"},
+		{"executor_num=Inf"},
+	} {
+		if _, _, _, err := calculateResources(cfg, params); err == nil {
+			t.Errorf("calculateResources(%q) accepted an invalid executor_num", params)
+		}
+	}
+	if isDriverOnly([]string{"executor_num=0x0p0"}) {
+		t.Error("isDriverOnly accepted a hex float executor_num")
+	}
+}
+
+func TestCalculateResourcesRejectsInvalidValues(t *testing.T) {
+	cfg := &Config{MemoryUnit: memoryGB}
+	tests := []struct {
+		name    string
+		params  []string
+		wantErr string
+	}{
+		{"valid", []string{"executor_num=2", "executor_cores_limit=1", "executor_memory_limit=4g"}, ""},
+		{"negative executors", []string{"executor_num=-1", "executor_cores_limit=4"}, "executor_num must not be negative"},
+		{"negative cores", []string{"driver_cores_limit=-2"}, "driver_cores_limit must not be negative"},
+		{"negative memory", []string{"driver_memory_limit=-4g"}, "driver_memory_limit must not be negative"},
+		{"infinite CPU total", []string{"executor_num=1e308", "executor_cores_limit=1e10"}, "cpu_total must be a finite number"},
+		{"infinite memory total", []string{"executor_num=1e308", "executor_memory_limit=1e300g"}, "mem_total must be a finite number"},
+	}
+	for _, tt := range tests {
+		_, _, _, err := calculateResources(cfg, tt.params)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+
+	// The submit is refused before scout is asked
+	body := `{"resourceKind": "WorkflowTemplate", "resourceName": "tpl", "submitOptions": {"parameters": ["driver_memory_limit=-4g"]}}`
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/workflows/batch-a/submit", strings.NewReader(body))
+	r.SetPathValue("namespace", "batch-a")
+	r = r.WithContext(context.WithValue(r.Context(), configKey{}, &Config{
+		APIVersion: "1", APIVersions: []string{"1"}, ResourceKinds: defaultResourceKinds, MemoryUnit: memoryGB,
+	}))
+	w := httptest.NewRecorder()
+	handleSubmit(w, r, "http://127.0.0.1:1")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("negative memory submit answered %d %q, want 400", w.Code, strings.TrimSpace(w.Body.String()))
+	}
+}
+
+func TestParameterBounds(t *testing.T) {
+	bounded := Config{MaxExecutors: 100, MaxExecutorCores: 8, MaxDriverCores: 4, MaxExecutorMemory: 32, MaxDriverMemory: 16}
+	tests := []struct {
+		name    string
+		params  []string
+		wantErr string
+	}{
+		{"within every bound", []string{"executor_num=100", "executor_cores_limit=8", "driver_cores_limit=4", "executor_memory_limit=32g", "driver_memory_limit=16g"}, ""},
+		{"too many executors", []string{"executor_num=5000"}, "executor_num=5000 exceeds the maximum of 100 (MEDEA_MAX_EXECUTORS)"},
+		{"executor cores", []string{"executor_cores_limit=64"}, "executor_cores_limit=64 exceeds the maximum of 8 (MEDEA_MAX_EXECUTOR_CORES)"},
+		{"executor cores in millicores", []string{"executor_cores_limit=8500m"}, "executor_cores_limit=8.5 exceeds"},
+		{"driver cores", []string{"driver_cores_limit=5"}, "driver_cores_limit=5 exceeds the maximum of 4 (MEDEA_MAX_DRIVER_CORES)"},
+		{"executor memory", []string{"executor_memory_limit=64g"}, "executor_memory_limit=64 exceeds the maximum of 32 (MEDEA_MAX_EXECUTOR_MEMORY_GB)"},
+		{"driver memory", []string{"driver_memory_limit=20Gi"}, "(MEDEA_MAX_DRIVER_MEMORY_GB)"},
+	}
+	for _, unit := range []string{memoryGB, memoryMiB} {
+		cfg := bounded
+		// The memory bounds are in GB whatever unit the totals are in
+		cfg.MemoryUnit = unit
+		for _, tt := range tests {
+			_, _, _, err := calculateResources(&cfg, tt.params)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("%s in %s: %v", tt.name, unit, err)
+				}
+				continue
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s in %s: error %v, want %q", tt.name, unit, err, tt.wantErr)
+			}
+		}
+	}
+	// Unset bounds don't limit anything
+	if _, _, _, err := calculateResources(&Config{MemoryUnit: memoryGB}, []string{"executor_num=5000", "executor_memory_limit=1024g"}); err != nil {
+		t.Errorf("unbounded config refused a large submit: %v", err)
+	}
+
+	// The submit is refused with the bound it broke, before scout is asked
+	body := `{"resourceKind": "WorkflowTemplate", "resourceName": "tpl", "submitOptions": {"parameters": ["executor_num=5000"]}}`
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/workflows/batch-a/submit", strings.NewReader(body))
+	r.SetPathValue("namespace", "batch-a")
+	cfg := bounded
+	cfg.APIVersion, cfg.APIVersions, cfg.ResourceKinds, cfg.MemoryUnit = "1", []string{"1"}, defaultResourceKinds, memoryGB
+	r = r.WithContext(context.WithValue(r.Context(), configKey{}, &cfg))
+	w := httptest.NewRecorder()
+	handleSubmit(w, r, "http://127.0.0.1:1")
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "MEDEA_MAX_EXECUTORS") {
+		t.Errorf("oversized submit answered %d %q, want 400 naming the bound", w.Code, strings.TrimSpace(w.Body.String()))
+	}
+}
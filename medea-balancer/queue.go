@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path"
+	"time"
+)
+
+var (
+	// errQueueFull is returned when MEDEA_QUEUE_SIZE submits are already waiting
+	errQueueFull = errors.New("submit queue is full")
+	// errQueueTimeout is returned when no cluster freed up within MEDEA_QUEUE_MAX_WAIT
+	errQueueTimeout = errors.New("no cluster freed up in time")
+	// errShuttingDown is returned when the balancer stops while the submit waits
+	errShuttingDown = errors.New("balancer is shutting down")
+)
+
+// submitQueue holds a token per waiting submit, nil when queuing is disabled
+var submitQueue chan struct{}
+
+// queueStop is closed on shutdown, so queued submits end instead of holding off the drain
+var queueStop = make(chan struct{})
+
+// queueable reports whether submits of ns wait for capacity instead of failing right away
+func queueable(cfg *Config, ns string) bool {
+	if submitQueue == nil {
+		return false
+	}
+	for _, pattern := range cfg.QueueNamespaces {
+		if ok, _ := path.Match(pattern, ns); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// waitForCluster re-asks scout every MEDEA_QUEUE_POLL_INTERVAL until a cluster fits,
+// MEDEA_QUEUE_MAX_WAIT passes, the client goes away or the balancer shuts down. Scout errors
+// other than "nothing fits" end the wait right away.
+func waitForCluster(w http.ResponseWriter, r *http.Request, scoutURL string, scoutReq ScoutRequest) (ScoutResponse, error) {
+	select {
+	case submitQueue <- struct{}{}:
+		defer func() { <-submitQueue }()
+	default:
+		return ScoutResponse{}, errQueueFull
+	}
+
+	// The wait easily outlasts MEDEA_WRITE_TIMEOUT
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	// The whole wait runs on the config the submit started with, a reload doesn't change it
+	cfg := configFrom(r.Context())
+	deadline := time.Now().Add(cfg.QueueMaxWait)
+	for {
+		wait := min(cfg.QueuePollInterval, time.Until(deadline))
+		if wait <= 0 {
+			return ScoutResponse{}, errQueueTimeout
+		}
+		if err := sleepContext(r.Context(), wait); err != nil {
+			return ScoutResponse{}, err
+		}
+		decision, err := getTargetCluster(r.Context(), scoutURL, scoutReq)
+		var nc *noClusterError
+		if !errors.As(err, &nc) {
+			return decision, err
+		}
+	}
+}
+
+// sleepContext waits for d, returning the context's error when ctx ends first and
+// errShuttingDown on shutdown
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-queueStop:
+		return errShuttingDown
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
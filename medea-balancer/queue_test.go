@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWaitForClusterKeepsRequestConfig(t *testing.T) {
+	// Scout finds no cluster twice, then places the workflow
+	var asks atomic.Int32
+	scout := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if asks.Add(1) <= 2 {
+			http.Error(w, "no cluster", http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"cluster":"http://argowf1:8080"}`))
+	}))
+	defer scout.Close()
+
+	submitQueue = make(chan struct{}, 1)
+	defer func() { submitQueue = nil }()
+	snapshot := &Config{QueueMaxWait: time.Second, QueuePollInterval: 10 * time.Millisecond}
+	// A reload to a config that would time out at once must not reach the waiting submit
+	currentConfig.Store(&Config{QueueMaxWait: time.Nanosecond, QueuePollInterval: time.Hour})
+	defer currentConfig.Store(nil)
+
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r = r.WithContext(context.WithValue(r.Context(), configKey{}, snapshot))
+	decision, err := waitForCluster(httptest.NewRecorder(), r, scout.URL, ScoutRequest{Namespace: "batch-a"})
+	if err != nil {
+		t.Fatalf("waitForCluster: %v", err)
+	}
+	if decision.Cluster != "http://argowf1:8080" {
+		t.Errorf("cluster = %q, want http://argowf1:8080", decision.Cluster)
+	}
+	if n := asks.Load(); n != 3 {
+		t.Errorf("scout asked %d times, want 3", n)
+	}
+}
+
+func TestWaitForClusterTimeout(t *testing.T) {
+	scout := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no cluster", http.StatusInsufficientStorage)
+	}))
+	defer scout.Close()
+
+	submitQueue = make(chan struct{}, 1)
+	defer func() { submitQueue = nil }()
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r = r.WithContext(context.WithValue(r.Context(), configKey{}, &Config{QueueMaxWait: 30 * time.Millisecond, QueuePollInterval: 10 * time.Millisecond}))
+	if _, err := waitForCluster(httptest.NewRecorder(), r, scout.URL, ScoutRequest{}); err != errQueueTimeout {
+		t.Errorf("err = %v, want errQueueTimeout", err)
+	}
+
+	// The queue slot is taken by another submit
+	submitQueue <- struct{}{}
+	if _, err := waitForCluster(httptest.NewRecorder(), r, scout.URL, ScoutRequest{}); err != errQueueFull {
+		t.Errorf("err = %v, want errQueueFull", err)
+	}
+}
+
+func TestWaitForClusterEndsOnShutdown(t *testing.T) {
+	scout := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no cluster", http.StatusNotFound)
+	}))
+	defer scout.Close()
+
+	submitQueue = make(chan struct{}, 1)
+	queueStop = make(chan struct{})
+	defer func() { submitQueue, queueStop = nil, make(chan struct{}) }()
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r = r.WithContext(context.WithValue(r.Context(), configKey{}, &Config{QueueMaxWait: time.Hour, QueuePollInterval: 10 * time.Millisecond}))
+
+	time.AfterFunc(30*time.Millisecond, func() { close(queueStop) })
+	start := time.Now()
+	if _, err := waitForCluster(httptest.NewRecorder(), r, scout.URL, ScoutRequest{}); err != errShuttingDown {
+		t.Errorf("err = %v, want errShuttingDown", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("queued wait took %v to notice the shutdown", d)
+	}
+}
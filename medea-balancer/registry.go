@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+
+	"medea/internal/registry"
+)
+
+// loadRegistry reads MEDEA_CLUSTER_REGISTRY and checks the clusters named by other settings
+// against it: unknown ones are an error in reject mode and logged in warn mode
+func loadRegistry(env *envSource, c *Config) error {
+	v := env.getenv("MEDEA_CLUSTER_REGISTRY")
+	if v == "" {
+		return nil
+	}
+	reg, err := registry.Parse(v, env.getenv("MEDEA_CLUSTER_REGISTRY_MODE"))
+	if err != nil {
+		return fmt.Errorf("Invalid MEDEA_CLUSTER_REGISTRY: %v", err)
+	}
+	c.Registry = reg
+
+	settings := []struct {
+		name     string
+		clusters []string
+	}{
+		{"MEDEA_KNOWN_CLUSTERS", c.KnownClusters},
+		{"MEDEA_DRIVER_ONLY_POOL", c.DriverOnlyPool},
+		{"MEDEA_CLUSTER_HEADERS", slices.Sorted(maps.Keys(c.ClusterHeaders))},
+		{"MEDEA_CLUSTER_MAX_BODY_BYTES", slices.Sorted(maps.Keys(c.ClusterMaxBody))},
+	}
+	for _, s := range settings {
+		for _, cluster := range reg.Unknown(s.clusters...) {
+			if reg.Mode == registry.ModeReject {
+				return fmt.Errorf("%s names %s, which is not in MEDEA_CLUSTER_REGISTRY", s.name, cluster)
+			}
+			slog.Warn("Cluster is not in MEDEA_CLUSTER_REGISTRY", "setting", s.name, "cluster", cluster)
+		}
+	}
+	return nil
+}
+
+// registeredURL maps a cluster, by name or URL, to the URL it is registered with. Without a
+// registry the cluster is returned as is; unknown clusters are an error in reject mode.
+func registeredURL(cfg *Config, cluster string) (string, error) {
+	if !cfg.Registry.Enabled() {
+		return cluster, nil
+	}
+	if c, ok := cfg.Registry.Lookup(cluster); ok {
+		return c.URL, nil
+	}
+	if cfg.Registry.Mode == registry.ModeReject {
+		return "", fmt.Errorf("%s is not in MEDEA_CLUSTER_REGISTRY", cluster)
+	}
+	if cfg.Registry.FirstSighting(cluster) {
+		slog.Warn("Cluster is not in MEDEA_CLUSTER_REGISTRY", "cluster", cluster)
+	}
+	return cluster, nil
+}
+
+// sameCluster reports whether a and b name the same cluster, directly or through the
+// name and URL of one registry entry
+func sameCluster(cfg *Config, a, b string) bool {
+	if a == b {
+		return true
+	}
+	ca, okA := cfg.Registry.Lookup(a)
+	cb, okB := cfg.Registry.Lookup(b)
+	return okA && okB && ca.Name == cb.Name
+}
+
+// containsCluster reports whether clusters has one that is the same as cluster
+func containsCluster(cfg *Config, clusters []string, cluster string) bool {
+	return slices.ContainsFunc(clusters, func(c string) bool { return sameCluster(cfg, c, cluster) })
+}
+
+// clusterSetting returns the setting of cluster from a map keyed by cluster name or URL. A
+// registered cluster finds the setting under either its name or its URL.
+func clusterSetting[T any](cfg *Config, settings map[string]T, cluster string) (T, bool) {
+	if v, ok := settings[cluster]; ok {
+		return v, true
+	}
+	if v, ok := settings[strings.TrimSuffix(cluster, "/")]; ok {
+		return v, true
+	}
+	for _, key := range slices.Sorted(maps.Keys(settings)) {
+		if sameCluster(cfg, key, cluster) {
+			return settings[key], true
+		}
+	}
+	var zero T
+	return zero, false
+}
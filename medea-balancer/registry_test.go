@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"medea/internal/registry"
+)
+
+func testRegistry(t *testing.T, mode string) *registry.Registry {
+	t.Helper()
+	reg, err := registry.Parse(`[{"name": "east", "url": "http://argowf1:8080"}]`, mode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reg
+}
+
+func TestRegisteredURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		cluster string
+		want    string
+		wantErr bool
+	}{
+		{"by name", registry.ModeReject, "east", "http://argowf1:8080", false},
+		{"by url", registry.ModeReject, "http://argowf1:8080/", "http://argowf1:8080", false},
+		{"unregistered in reject mode", registry.ModeReject, "http://argowf9:8080", "", true},
+		{"unregistered in warn mode", registry.ModeWarn, "http://argowf9:8080", "http://argowf9:8080", false},
+		{"no registry", "", "west", "west", false},
+	}
+	for _, tt := range tests {
+		cfg := &Config{}
+		if tt.mode != "" {
+			cfg.Registry = testRegistry(t, tt.mode)
+		}
+		got, err := registeredURL(cfg, tt.cluster)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("%s: registeredURL(%q) = %q, %v, want %q, error %v", tt.name, tt.cluster, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestClusterSetting(t *testing.T) {
+	cfg := &Config{Registry: testRegistry(t, registry.ModeWarn)}
+	tests := []struct {
+		name     string
+		settings map[string]int64
+		cluster  string
+		want     int64
+		wantOK   bool
+	}{
+		{"keyed by url, asked by name", map[string]int64{"http://argowf1:8080": 1}, "east", 1, true},
+		{"keyed by name, asked by url", map[string]int64{"east": 2}, "http://argowf1:8080/", 2, true},
+		{"keyed by url with slash", map[string]int64{"http://argowf1:8080/": 3}, "east", 3, true},
+		{"unregistered cluster", map[string]int64{"http://argowf9:8080": 4}, "http://argowf9:8080/", 4, true},
+		{"other cluster", map[string]int64{"http://argowf9:8080": 5}, "east", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := clusterSetting(cfg, tt.settings, tt.cluster)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("%s: got %d, %v, want %d, %v", tt.name, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+// recordingStore keeps the records saved through it
+type recordingStore struct {
+	Store
+	mu    sync.Mutex
+	saved []WorkflowRecord
+}
+
+func (s *recordingStore) SaveWorkflow(rec WorkflowRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.saved = append(s.saved, rec)
+	return nil
+}
+
+func TestSubmitKeepsClusterName(t *testing.T) {
+	argo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "k1" {
+			http.Error(w, "missing the cluster's API key", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"kind": "Workflow", "metadata": {"name": "wf-1"}}`))
+	}))
+	defer argo.Close()
+
+	feedback := make(chan Feedback, 1)
+	scout := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/feedback" {
+			var fb Feedback
+			json.NewDecoder(r.Body).Decode(&fb)
+			feedback <- fb
+			return
+		}
+		w.Write([]byte(`{"cluster": "east"}`))
+	}))
+	defer scout.Close()
+
+	reg, err := registry.Parse(`[{"name": "east", "url": "`+argo.URL+`"}]`, registry.ModeReject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{
+		APIVersion: "1", APIVersions: []string{"1"}, ResourceKinds: defaultResourceKinds, MemoryUnit: memoryGB,
+		ProxyTimeout: 5 * time.Second, ScoutFeedback: true, Registry: reg,
+		ClusterHeaders: map[string]map[string]string{"east": {"X-Api-Key": "k1"}},
+	}
+	recs := &recordingStore{}
+	store = recs
+	defer func() { store = nil }()
+
+	body := `{"resourceKind": "WorkflowTemplate", "resourceName": "tpl", "submitOptions": {"parameters": ["executor_num=1"]}}`
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/workflows/batch-a/submit", strings.NewReader(body))
+	r.SetPathValue("namespace", "batch-a")
+	r = r.WithContext(context.WithValue(r.Context(), configKey{}, cfg))
+	w := httptest.NewRecorder()
+	handleSubmit(w, r, scout.URL)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("submit answered %d %q", w.Code, w.Body.String())
+	}
+	if len(recs.saved) != 1 || recs.saved[0].Cluster != "east" {
+		t.Errorf("saved %+v, want one record naming the cluster east", recs.saved)
+	}
+	select {
+	case fb := <-feedback:
+		if fb.Cluster != "east" {
+			t.Errorf("feedback names cluster %q, want east", fb.Cluster)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("scout got no feedback")
+	}
+}
+
+func TestProxyResolvesRecordedName(t *testing.T) {
+	argo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status": "Running"}`))
+	}))
+	defer argo.Close()
+	reg, err := registry.Parse(`[{"name": "east", "url": "`+argo.URL+`"}]`, registry.ModeReject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	currentConfig.Store(&Config{ProxyTimeout: 5 * time.Second, Registry: reg})
+	defer currentConfig.Store(nil)
+
+	tests := []struct {
+		recorded string
+		want     int
+	}{
+		{"east", http.StatusOK},
+		{argo.URL, http.StatusOK},
+		{"west", http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		store = clusterStore{cluster: tt.recorded}
+		w := httptest.NewRecorder()
+		proxyToCluster(w, statusRequest(nil))
+		if w.Code != tt.want {
+			t.Errorf("workflow recorded on %q: status %d, want %d", tt.recorded, w.Code, tt.want)
+		}
+	}
+	store = nil
+}
+
+func TestSubPathProxy(t *testing.T) {
+	var got string
+	argo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.RequestURI()
+		w.Write([]byte(`{}`))
+	}))
+	defer argo.Close()
+	store = clusterStore{cluster: argo.URL}
+	defer func() {
+		store = nil
+		currentConfig.Store(nil)
+	}()
+	mux := newMux()
+
+	tests := []struct {
+		name       string
+		allowed    []string
+		target     string
+		wantStatus int
+	}{
+		{"allowed sub-path", []string{"log"}, "/api/v1/workflows/batch-a/wf-1/log?logOptions.container=main", http.StatusOK},
+		{"allowed by pattern", []string{"log", "artifacts/*"}, "/api/v1/workflows/batch-a/wf-1/artifacts/out", http.StatusOK},
+		{"not allowed", []string{"log", "artifacts/*"}, "/api/v1/workflows/batch-a/wf-1/exec", http.StatusForbidden},
+		{"pattern does not cross slashes", []string{"artifacts/*"}, "/api/v1/workflows/batch-a/wf-1/artifacts/out/deep", http.StatusForbidden},
+		{"no allowlist", nil, "/api/v1/workflows/batch-a/wf-1/log", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		currentConfig.Store(&Config{ProxyTimeout: 5 * time.Second, ProxySubPaths: tt.allowed})
+		got = ""
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: answered %d %q, want %d", tt.name, w.Code, strings.TrimSpace(w.Body.String()), tt.wantStatus)
+		}
+		// The full path and query reach the cluster, refused sub-paths never do
+		want := ""
+		if tt.wantStatus == http.StatusOK {
+			want = tt.target
+		}
+		if got != want {
+			t.Errorf("%s: forwarded %q, want %q", tt.name, got, want)
+		}
+	}
+}
+
+func TestClusterHeaders(t *testing.T) {
+	// Each fake cluster reports the headers it got
+	headersOf := make(map[string]http.Header)
+	var mu sync.Mutex
+	cluster := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			headersOf[name] = r.Header.Clone()
+			mu.Unlock()
+			w.Write([]byte(`{"kind": "Workflow", "metadata": {"name": "wf-1"}}`))
+		}))
+	}
+	east, west := cluster("east"), cluster("west")
+	defer east.Close()
+	defer west.Close()
+	reg, err := registry.Parse(`[{"name": "east", "url": "`+east.URL+`"}, {"name": "west", "url": "`+west.URL+`"}]`, registry.ModeReject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var placeOn string
+	scout := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"cluster": %q}`, placeOn)
+	}))
+	defer scout.Close()
+
+	t.Setenv("DB_DRIVER", "sqlite")
+	t.Setenv("MEDEA_CLUSTER_HEADERS", `{"east": {"X-Api-Key": "east-key", "X-Tenant": "data"}, "west": {"X-Api-Key": "west-key"}}`)
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.ProxyTimeout, cfg.Registry = 5*time.Second, reg
+	currentConfig.Store(cfg)
+	defer func() {
+		store = nil
+		currentConfig.Store(nil)
+	}()
+
+	tests := []struct {
+		cluster string
+		want    map[string]string
+	}{
+		{"east", map[string]string{"X-Api-Key": "east-key", "X-Tenant": "data", "Tuz": "svc-a"}},
+		{"west", map[string]string{"X-Api-Key": "west-key", "X-Tenant": "", "Tuz": "svc-a"}},
+	}
+	for _, tt := range tests {
+		placeOn = tt.cluster
+		store = &recordingStore{}
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/workflows/batch-a/submit", strings.NewReader(`{"resourceKind": "WorkflowTemplate", "resourceName": "tpl"}`))
+		r.SetPathValue("namespace", "batch-a")
+		r.Header.Set("tuz", "svc-a")
+		r = r.WithContext(context.WithValue(r.Context(), configKey{}, cfg))
+		w := httptest.NewRecorder()
+		handleSubmit(w, r, scout.URL)
+		if w.Code != http.StatusOK {
+			t.Fatalf("submit to %s answered %d %q", tt.cluster, w.Code, w.Body.String())
+		}
+		submitted := headersOf[tt.cluster]
+
+		store = clusterStore{cluster: tt.cluster}
+		w = httptest.NewRecorder()
+		proxyToCluster(w, statusRequest(map[string]string{"tuz": "svc-a"}))
+		if w.Code != http.StatusOK {
+			t.Fatalf("proxy to %s answered %d", tt.cluster, w.Code)
+		}
+		proxied := headersOf[tt.cluster]
+
+		for name, want := range tt.want {
+			if got := submitted.Get(name); got != want {
+				t.Errorf("submit to %s: %s %q, want %q", tt.cluster, name, got, want)
+			}
+			if got := proxied.Get(name); got != want {
+				t.Errorf("proxy to %s: %s %q, want %q", tt.cluster, name, got, want)
+			}
+		}
+	}
+
+	// Logs name the headers but never show their values
+	if got, want := redactHeaders(cfg.ClusterHeaders["east"]), "X-Api-Key=<redacted>, X-Tenant=<redacted>"; got != want {
+		t.Errorf("redacted to %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync/atomic"
+	"syscall"
+)
+
+// currentConfig is the config new requests start with. A reload swaps it, requests in flight
+// keep the snapshot they started with and never wait for a reload or hold one off.
+var currentConfig atomic.Pointer[Config]
+
+type configKey struct{}
+
+// withConfigSnapshot gives each request the config that is current when it starts
+func withConfigSnapshot(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), configKey{}, currentConfig.Load())
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// configFrom returns the config snapshot of a request, or the current config outside one
+func configFrom(ctx context.Context) *Config {
+	if c, ok := ctx.Value(configKey{}).(*Config); ok {
+		return c
+	}
+	return currentConfig.Load()
+}
+
+func reloadOnSIGHUP() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if err := reloadConfig(); err != nil {
+			slog.Error("Config reload failed, keeping the current config", "error", err)
+		}
+	}
+}
+
+// reloadConfig re-reads the environment and MEDEA_CONFIG_FILE and swaps the config for the
+// requests that start afterwards. Startup settings keep their current values until a restart.
+func reloadConfig() error {
+	next, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	cur := currentConfig.Load()
+	warnStartupChanges(&next.StartupConfig, &cur.StartupConfig)
+	next.StartupConfig = cur.StartupConfig
+	currentConfig.Store(next)
+	slog.Info("Config reloaded")
+	return nil
+}
+
+// warnStartupChanges logs the startup settings a reload would change but can't
+func warnStartupChanges(next, cur *StartupConfig) {
+	nv, cv := reflect.ValueOf(next).Elem(), reflect.ValueOf(cur).Elem()
+	for i := range nv.NumField() {
+		if !reflect.DeepEqual(nv.Field(i).Interface(), cv.Field(i).Interface()) {
+			slog.Warn("Setting can't be changed by a reload, keeping the current value until restart", "field", nv.Type().Field(i).Name)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReloadConfig(t *testing.T) {
+	t.Setenv("DB_DRIVER", "sqlite")
+	t.Setenv("MEDEA_BALANCER_PORT", "8080")
+	t.Setenv("MEDEA_PROXY_TIMEOUT", "10s")
+	start, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	currentConfig.Store(start)
+
+	// A request keeps the config it started with while a reload swaps it
+	started, reloaded := make(chan struct{}), make(chan struct{})
+	var seen time.Duration
+	handler := withConfigSnapshot(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-reloaded
+		seen = configFrom(r.Context()).ProxyTimeout
+	}))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}()
+	<-started
+
+	t.Setenv("MEDEA_PROXY_TIMEOUT", "20s")
+	t.Setenv("MEDEA_BALANCER_PORT", "9090")
+	if err := reloadConfig(); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	close(reloaded)
+	<-done
+
+	if seen != 10*time.Second {
+		t.Errorf("request in flight saw ProxyTimeout %v, want the 10s it started with", seen)
+	}
+	cur := currentConfig.Load()
+	if cur.ProxyTimeout != 20*time.Second {
+		t.Errorf("ProxyTimeout after reload = %v, want 20s", cur.ProxyTimeout)
+	}
+	if cur.ServicePort != "8080" {
+		t.Errorf("ServicePort after reload = %q, startup settings must keep their value", cur.ServicePort)
+	}
+
+	// An invalid value keeps the current config
+	t.Setenv("MEDEA_PROXY_TIMEOUT", "soon")
+	if err := reloadConfig(); err == nil {
+		t.Error("reload accepted an invalid MEDEA_PROXY_TIMEOUT")
+	}
+	if currentConfig.Load() != cur {
+		t.Error("failed reload replaced the config")
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// requestIDHeader correlates a request across balancer, scout and the target cluster
+const requestIDHeader = "X-Request-Id"
+
+// Longest inbound request ID kept, longer ones are replaced like missing ones
+const maxRequestIDLen = 128
+
+type requestIDKey struct{}
+
+// withRequestID gives every request an ID: the client's X-Request-Id, or a new UUID. The ID is
+// stored in the request context for the logs, set on the request so it is forwarded with the
+// other headers, and returned in the response.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.NewString()
+			r.Header.Set(requestIDHeader, id)
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// validRequestID accepts non-empty printable ASCII IDs up to maxRequestIDLen, anything else
+// would end up verbatim in logs and headers
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// requestID returns the ID withRequestID stored in ctx, "" outside of a request
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer that background goroutines may log to
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// captureLogs sends the default logger's records to a buffer as JSON lines until the test ends
+func captureLogs(t *testing.T) *syncBuffer {
+	t.Helper()
+	logs := &syncBuffer{}
+	prev := slog.Default()
+	slog.SetDefault(slog.New(requestIDHandler{slog.NewJSONHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug})}))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	return logs
+}
+
+func TestRequestIDPropagated(t *testing.T) {
+	var mu sync.Mutex
+	seen := make(map[string]string)
+	argo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen["argo"] = r.Header.Get(requestIDHeader)
+		mu.Unlock()
+		w.Write([]byte(`{"kind": "Workflow", "metadata": {"name": "wf-1"}}`))
+	}))
+	defer argo.Close()
+	feedback := make(chan string, 1)
+	scout := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/request":
+			mu.Lock()
+			seen["scout"] = r.Header.Get(requestIDHeader)
+			mu.Unlock()
+			fmt.Fprintf(w, `{"cluster": %q}`, argo.URL)
+		case "/api/feedback":
+			feedback <- r.Header.Get(requestIDHeader)
+		}
+	}))
+	defer scout.Close()
+
+	store = &recordingStore{}
+	defer func() { store = nil }()
+	cfg := &Config{
+		APIVersion: "1", APIVersions: []string{"1"}, ResourceKinds: defaultResourceKinds, MemoryUnit: memoryGB,
+		ProxyTimeout: 5 * time.Second, ScoutFeedback: true,
+	}
+	handler := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleSubmit(w, r, scout.URL)
+	}))
+	tests := []struct {
+		name    string
+		inbound string
+	}{
+		{"client ID kept", "req-123"},
+		{"ID generated", ""},
+		{"invalid ID replaced", "bad id\n"},
+	}
+	for _, tt := range tests {
+		logs := captureLogs(t)
+		body := `{"resourceKind": "WorkflowTemplate", "resourceName": "tpl", "submitOptions": {"parameters": ["executor_num=1"]}}`
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/workflows/batch-a/submit", strings.NewReader(body))
+		r.SetPathValue("namespace", "batch-a")
+		r = r.WithContext(context.WithValue(r.Context(), configKey{}, cfg))
+		if tt.inbound != "" {
+			r.Header.Set(requestIDHeader, tt.inbound)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: submit answered %d %q", tt.name, w.Code, w.Body.String())
+		}
+
+		id := w.Header().Get(requestIDHeader)
+		if tt.inbound == "req-123" && id != tt.inbound || id == "" || !validRequestID(id) {
+			t.Errorf("%s: response carries request ID %q", tt.name, id)
+		}
+		mu.Lock()
+		select {
+		case seen["feedback"] = <-feedback:
+		case <-time.After(time.Second):
+			t.Errorf("%s: no feedback reached scout", tt.name)
+		}
+		for to, got := range seen {
+			if got != id {
+				t.Errorf("%s: %s got request ID %q, want %q", tt.name, to, got, id)
+			}
+		}
+		mu.Unlock()
+
+		// Every line logged for the submit names it
+		lines := 0
+		for sc := bufio.NewScanner(strings.NewReader(logs.String())); sc.Scan(); lines++ {
+			var rec map[string]any
+			if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+				t.Fatal(err)
+			}
+			if rec["request_id"] != id {
+				t.Errorf("%s: %q logged with request_id %v, want %q", tt.name, rec["msg"], rec["request_id"], id)
+			}
+		}
+		if lines == 0 {
+			t.Errorf("%s: nothing logged", tt.name)
+		}
+	}
+}
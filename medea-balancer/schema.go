@@ -0,0 +1,302 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// builtinSubmitSchema describes the submit body the balancer understands,
+// used with MEDEA_SUBMIT_SCHEMA=builtin
+//
+//go:embed submit_schema.json
+var builtinSubmitSchema []byte
+
+// jsonSchema is the subset of JSON Schema the balancer checks submits against: type, enum,
+// required, properties, additionalProperties, items, minLength, pattern, minItems, minimum
+// and maximum. Annotations are ignored, any other keyword is refused when the schema is
+// loaded rather than silently not checked.
+type jsonSchema struct {
+	Type                 schemaTypes            `json:"type"`
+	Enum                 []any                  `json:"enum"`
+	Required             []string               `json:"required"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	AdditionalProperties json.RawMessage        `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	MinLength            *int                   `json:"minLength"`
+	Pattern              string                 `json:"pattern"`
+	MinItems             *int                   `json:"minItems"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+
+	pattern *regexp.Regexp
+	// noAdditional is set by "additionalProperties": false, additional holds a schema for them
+	noAdditional bool
+	additional   *jsonSchema
+}
+
+// schemaKeywords are the keywords jsonSchema checks
+var schemaKeywords = []string{
+	"type", "enum", "required", "properties", "additionalProperties", "items",
+	"minLength", "pattern", "minItems", "minimum", "maximum",
+}
+
+// schemaAnnotations don't constrain a body and may appear anywhere
+var schemaAnnotations = []string{"$schema", "$id", "$comment", "title", "description", "default", "examples"}
+
+// schemaTypes accepts both "type": "string" and "type": ["string", "null"]
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*t = schemaTypes{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return fmt.Errorf("type must be a string or an array of strings")
+	}
+	*t = many
+	return nil
+}
+
+// loadSubmitSchema reads MEDEA_SUBMIT_SCHEMA: "builtin" or the path of a schema file
+func loadSubmitSchema(source string) (*jsonSchema, error) {
+	data := builtinSubmitSchema
+	if source != "builtin" {
+		var err error
+		if data, err = os.ReadFile(source); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkKeywords(data, ""); err != nil {
+		return nil, err
+	}
+	var s jsonSchema
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	if err := s.compile(""); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// checkKeywords refuses keywords jsonSchema doesn't implement, like $ref, oneOf or allOf,
+// anywhere in the schema
+func checkKeywords(data json.RawMessage, at string) error {
+	var keywords map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keywords); err != nil {
+		// A schema that isn't an object is reported by the decoding that follows
+		return nil
+	}
+	names := make([]string, 0, len(keywords))
+	for name := range keywords {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !slices.Contains(schemaKeywords, name) && !slices.Contains(schemaAnnotations, name) {
+			return fmt.Errorf("%s: unsupported schema keyword %q", schemaPath(at), name)
+		}
+	}
+	var properties map[string]json.RawMessage
+	if raw, ok := keywords["properties"]; ok && json.Unmarshal(raw, &properties) == nil {
+		for name, prop := range properties {
+			if err := checkKeywords(prop, joinPath(at, name)); err != nil {
+				return err
+			}
+		}
+	}
+	if raw, ok := keywords["additionalProperties"]; ok {
+		if err := checkKeywords(raw, at+".*"); err != nil {
+			return err
+		}
+	}
+	if raw, ok := keywords["items"]; ok {
+		return checkKeywords(raw, at+"[]")
+	}
+	return nil
+}
+
+// compile checks the keywords and prepares patterns and additionalProperties
+func (s *jsonSchema) compile(at string) error {
+	for _, t := range s.Type {
+		switch t {
+		case "object", "array", "string", "number", "integer", "boolean", "null":
+		default:
+			return fmt.Errorf("%s: unknown type %q", schemaPath(at), t)
+		}
+	}
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("%s: %v", schemaPath(at), err)
+		}
+		s.pattern = re
+	}
+	if len(s.AdditionalProperties) > 0 {
+		var allowed bool
+		if err := json.Unmarshal(s.AdditionalProperties, &allowed); err == nil {
+			s.noAdditional = !allowed
+		} else {
+			s.additional = &jsonSchema{}
+			if err := json.Unmarshal(s.AdditionalProperties, s.additional); err != nil {
+				return fmt.Errorf("%s: additionalProperties: %v", schemaPath(at), err)
+			}
+			if err := s.additional.compile(at + ".*"); err != nil {
+				return err
+			}
+		}
+	}
+	for name, prop := range s.Properties {
+		if err := prop.compile(joinPath(at, name)); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.compile(at + "[]")
+	}
+	return nil
+}
+
+// validate checks a body against the schema and returns every violation, missing fields
+// of an object first, then its present ones by name
+func (s *jsonSchema) validate(body []byte) ([]string, error) {
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		return nil, err
+	}
+	var problems []string
+	s.check(v, "", &problems)
+	return problems, nil
+}
+
+func (s *jsonSchema) check(v any, at string, problems *[]string) {
+	report := func(format string, args ...any) {
+		*problems = append(*problems, schemaPath(at)+": "+fmt.Sprintf(format, args...))
+	}
+
+	if len(s.Type) > 0 && !slices.ContainsFunc(s.Type, func(t string) bool { return hasType(v, t) }) {
+		report("expected %s, got %s", strings.Join(s.Type, " or "), typeName(v))
+		return
+	}
+	if len(s.Enum) > 0 && !slices.ContainsFunc(s.Enum, func(e any) bool { return jsonEqual(e, v) }) {
+		allowed := make([]string, len(s.Enum))
+		for i, e := range s.Enum {
+			b, _ := json.Marshal(e)
+			allowed[i] = string(b)
+		}
+		report("must be one of %s", strings.Join(allowed, ", "))
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				*problems = append(*problems, schemaPath(joinPath(at, name))+": is required")
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			switch prop, known := s.Properties[name]; {
+			case known:
+				prop.check(v[name], joinPath(at, name), problems)
+			case s.noAdditional:
+				*problems = append(*problems, schemaPath(joinPath(at, name))+": is not allowed")
+			case s.additional != nil:
+				s.additional.check(v[name], joinPath(at, name), problems)
+			}
+		}
+	case []any:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			report("must have at least %d items", *s.MinItems)
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.check(item, fmt.Sprintf("%s[%d]", at, i), problems)
+			}
+		}
+	case string:
+		if s.MinLength != nil && len([]rune(v)) < *s.MinLength {
+			report("must be at least %d characters long", *s.MinLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			report("must match %s", s.Pattern)
+		}
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			report("must be at least %v", *s.Minimum)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			report("must be at most %v", *s.Maximum)
+		}
+	}
+}
+
+// hasType reports whether a decoded JSON value is of a JSON Schema type
+func hasType(v any, t string) bool {
+	switch v := v.(type) {
+	case map[string]any:
+		return t == "object"
+	case []any:
+		return t == "array"
+	case string:
+		return t == "string"
+	case float64:
+		return t == "number" || t == "integer" && v == math.Trunc(v)
+	case bool:
+		return t == "boolean"
+	case nil:
+		return t == "null"
+	}
+	return false
+}
+
+func typeName(v any) string {
+	switch v.(type) {
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return "null"
+}
+
+func jsonEqual(a, b any) bool {
+	ab, _ := json.Marshal(a)
+	bb, _ := json.Marshal(b)
+	return string(ab) == string(bb)
+}
+
+func joinPath(at, name string) string {
+	if at == "" {
+		return name
+	}
+	return at + "." + name
+}
+
+// schemaPath names a location in the body for error messages, "body" for the whole body
+func schemaPath(at string) string {
+	if at == "" {
+		return "body"
+	}
+	return at
+}
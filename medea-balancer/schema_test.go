@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func writeSchema(t *testing.T, schema string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "schema.json")
+	if err := os.WriteFile(path, []byte(schema), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadSubmitSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  string
+		wantErr string
+	}{
+		{"supported keywords", `{"type": "object", "required": ["a"], "properties": {"a": {"type": "string", "minLength": 1, "pattern": "^x"}}}`, ""},
+		{"annotations", `{"$schema": "http://json-schema.org/draft-07/schema#", "title": "Submit", "properties": {"a": {"description": "A", "default": "x"}}}`, ""},
+		{"ref", `{"$ref": "#/definitions/submit"}`, `body: unsupported schema keyword "$ref"`},
+		{"nested oneOf", `{"properties": {"a": {"oneOf": [{"type": "string"}]}}}`, `a: unsupported schema keyword "oneOf"`},
+		{"anyOf in items", `{"properties": {"a": {"items": {"anyOf": []}}}}`, `a[]: unsupported schema keyword "anyOf"`},
+		{"allOf in additionalProperties", `{"additionalProperties": {"allOf": []}}`, `.*: unsupported schema keyword "allOf"`},
+		{"format", `{"properties": {"a": {"type": "string", "format": "email"}}}`, `a: unsupported schema keyword "format"`},
+		{"unknown type", `{"type": "text"}`, `body: unknown type "text"`},
+		{"invalid pattern", `{"pattern": "("}`, "body: error parsing regexp"},
+	}
+	for _, tt := range tests {
+		_, err := loadSubmitSchema(writeSchema(t, tt.schema))
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestValidateBuiltinSchema(t *testing.T) {
+	schema, err := loadSubmitSchema("builtin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{"minimal", `{"resourceKind": "WorkflowTemplate", "submitOptions": {"parameters": ["a=1"]}}`, nil},
+		{"missing fields", `{"resourceName": "tpl"}`, []string{"resourceKind: is required", "submitOptions: is required"}},
+		{"not an object", `[]`, []string{"body: expected object, got array"}},
+		{"empty kind", `{"resourceKind": "", "submitOptions": {"parameters": []}}`, []string{"resourceKind: must be at least 1 characters long"}},
+		{"parameter types", `{"resourceKind": "k", "submitOptions": {"parameters": ["a=1", 2, "flag"]}}`,
+			[]string{"submitOptions.parameters[1]: expected string, got number", "submitOptions.parameters[2]: must match ^[^=]+="}},
+		{"placement", `{"resourceKind": "k", "submitOptions": {"parameters": []}, "placement": {"priority": "urgent", "minFreeCpu": -1, "zone": "a"}}`,
+			[]string{"placement.minFreeCpu: must be at least 0", `placement.priority: must be one of "normal", "high"`, "placement.zone: is not allowed"}},
+		{"unknown top-level field", `{"resourceKind": "k", "submitOptions": {"parameters": []}, "extra": 1}`, nil},
+	}
+	for _, tt := range tests {
+		got, err := schema.validate([]byte(tt.body))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: problems = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestValidateTypes(t *testing.T) {
+	schema, err := loadSubmitSchema(writeSchema(t, `{"properties": {
+		"n": {"type": "integer", "maximum": 10},
+		"s": {"type": ["string", "null"]},
+		"l": {"type": "array", "minItems": 2},
+		"m": {"additionalProperties": {"type": "boolean"}}
+	}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		body string
+		want []string
+	}{
+		{`{"n": 3, "s": null, "l": [1, 2], "m": {"x": true}}`, nil},
+		{`{"n": 3.5}`, []string{"n: expected integer, got number"}},
+		{`{"n": 11}`, []string{"n: must be at most 10"}},
+		{`{"s": 1}`, []string{"s: expected string or null, got number"}},
+		{`{"l": [1]}`, []string{"l: must have at least 2 items"}},
+		{`{"m": {"x": "yes"}}`, []string{"m.x: expected boolean, got string"}},
+	}
+	for _, tt := range tests {
+		got, err := schema.validate([]byte(tt.body))
+		if err != nil {
+			t.Errorf("validate(%s): %v", tt.body, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("validate(%s) = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
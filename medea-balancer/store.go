@@ -0,0 +1,538 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// WorkflowRecord is a single placement stored in the workflows table
+type WorkflowRecord struct {
+	Name      string `json:"workflowName"`
+	Template  string `json:"workflowTemplate"`
+	Namespace string `json:"namespace"`
+	Cluster   string `json:"cluster"`
+	Balancer  string `json:"balancer,omitempty"`
+	// CostCenter is derived from the namespace by MEDEA_COST_CENTERS, empty when no rule matched
+	CostCenter string  `json:"costCenter,omitempty"`
+	CPU        float64 `json:"cpuTotal"`
+	// RAM is in GB whatever MEDEA_MEMORY_UNIT is
+	RAM float64 `json:"memTotal"`
+	// ScoutFreeCPU and ScoutFreeMem are the headroom scout reported on Cluster when it
+	// picked it, nil when scout didn't report one
+	ScoutFreeCPU *float64   `json:"scoutFreeCpu,omitempty"`
+	ScoutFreeMem *float64   `json:"scoutFreeMem,omitempty"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	DeletedAt    *time.Time `json:"deletedAt,omitempty"`
+}
+
+// ActiveCount is the number of active workflows of a namespace on a cluster
+type ActiveCount struct {
+	Namespace string
+	Cluster   string
+	Count     int
+}
+
+// Store abstracts the database that tracks where workflows were placed
+type Store interface {
+	Init() error
+	SaveWorkflow(rec WorkflowRecord) error
+	SaveWorkflows(recs []WorkflowRecord) error
+	GetCluster(wfName, ns string) (string, error)
+	LastTemplateCluster(template, ns string) (string, error)
+	ActiveWorkflowExists(wfName, ns string) (bool, error)
+	MarkDeleted(wfName, ns string) error
+	PurgeDeleted(grace time.Duration) (int64, error)
+	CountActive() (int, error)
+	ActiveUsage(ns string) (cpu, mem float64, err error)
+	ActiveCounts() ([]ActiveCount, error)
+	ListWorkflows(ns, cluster string, limit, offset int) ([]WorkflowRecord, error)
+	ExportWorkflows(since, until time.Time, fn func(WorkflowRecord) error) error
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// sqliteAvailable is set when the binary is built with the sqlite tag
+var sqliteAvailable bool
+
+// Columns added after the initial schema, applied on every start
+var addedColumns = []string{
+	"balancer VARCHAR(255)",
+	"deleted_at TIMESTAMP",
+	"cpu_total DOUBLE PRECISION",
+	"mem_total DOUBLE PRECISION",
+	"scout_free_cpu DOUBLE PRECISION",
+	"scout_free_mem DOUBLE PRECISION",
+	"cost_center VARCHAR(255)",
+}
+
+// sqlStore implements Store on database/sql. The same queries serve Postgres and SQLite,
+// only the DDL differs between dialects.
+type sqlStore struct {
+	db *sql.DB
+	// read is an optional replica for read-only lookups, nil means use db
+	read    *sql.DB
+	dialect string
+}
+
+// postgresSSLModes are the sslmode values lib/pq understands
+var postgresSSLModes = []string{"disable", "require", "verify-ca", "verify-full"}
+
+// postgresDSN turns a POSTGRESQL_*URL value (host:port, optionally with /db and ?params) into a
+// connection URL with the escaped credentials, POSTGRESQL_DB and POSTGRESQL_SSLMODE of c
+func postgresDSN(c *Config, hostURL string) (string, error) {
+	u, err := url.Parse("postgres://" + hostURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" || u.User != nil {
+		return "", fmt.Errorf("expected host:port[/db][?params] without credentials")
+	}
+	u.User = url.UserPassword(c.PgUser, c.PgPass)
+	if c.PgDB != "" {
+		if db := strings.TrimPrefix(u.Path, "/"); db != "" && db != c.PgDB {
+			return "", fmt.Errorf("names database %q but POSTGRESQL_DB is %q", db, c.PgDB)
+		}
+		u.Path = "/" + c.PgDB
+	}
+	if c.PgSSLMode != "" {
+		q := u.Query()
+		q.Set("sslmode", c.PgSSLMode)
+		u.RawQuery = q.Encode()
+	}
+	return u.String(), nil
+}
+
+// openStore opens a database for the given driver: "postgres" (default) or "sqlite"
+func openStore(driver, dsn string) (*sqlStore, error) {
+	switch driver {
+	case "", "postgres":
+		driver = "postgres"
+	case "sqlite":
+		if !sqliteAvailable {
+			return nil, fmt.Errorf("DB_DRIVER=sqlite requires a build with -tags sqlite")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported DB_DRIVER %q", driver)
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if driver == "postgres" {
+		tunePool(db)
+	}
+	return &sqlStore{db: db, dialect: driver}, nil
+}
+
+// tunePool applies the POSTGRESQL_MAX_OPEN, POSTGRESQL_MAX_IDLE and POSTGRESQL_CONN_LIFETIME
+// limits, so bursts of submits queue for a connection instead of exhausting the server's
+func tunePool(db *sql.DB) {
+	cfg := currentConfig.Load()
+	db.SetMaxOpenConns(cfg.PgMaxOpen)
+	db.SetMaxIdleConns(cfg.PgMaxIdle)
+	db.SetConnMaxLifetime(cfg.PgConnLifetime)
+}
+
+const (
+	// Backoff between connection attempts at startup, doubling up to connectMaxBackoff
+	connectRetryBackoff = 500 * time.Millisecond
+	connectMaxBackoff   = 5 * time.Second
+	// connectAttemptTimeout bounds a single ping, so a hanging server can't stall startup
+	connectAttemptTimeout = 5 * time.Second
+)
+
+// waitForDB pings the database until it answers, retrying with backoff for up to timeout
+// (POSTGRESQL_CONNECT_TIMEOUT). A timeout of 0 tries exactly once.
+func waitForDB(s Store, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	backoff := connectRetryBackoff
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), connectAttemptTimeout)
+		err := s.Ping(ctx)
+		cancel()
+		if err == nil {
+			if attempt > 1 {
+				slog.Info("Connected to database", "attempts", attempt)
+			}
+			return nil
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return fmt.Errorf("giving up after %d attempts in %s: %w", attempt, timeout, err)
+		}
+		// The last attempt happens right at the deadline
+		backoff = min(backoff, remaining)
+		slog.Warn("Database connection attempt failed", "attempt", attempt, "retry_in", backoff.String(), "error", err)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, connectMaxBackoff)
+	}
+}
+
+// openReplica attaches a read replica used by GetCluster and ListWorkflows
+func (s *sqlStore) openReplica(dsn string) error {
+	read, err := sql.Open(s.dialect, dsn)
+	if err != nil {
+		return err
+	}
+	tunePool(read)
+	s.read = read
+	return nil
+}
+
+// reader returns the handle for read-only queries
+func (s *sqlStore) reader() *sql.DB {
+	if s.read != nil {
+		return s.read
+	}
+	return s.db
+}
+
+func (s *sqlStore) Init() error {
+	idColumn := "id SERIAL PRIMARY KEY"
+	if s.dialect == "sqlite" {
+		idColumn = "id INTEGER PRIMARY KEY AUTOINCREMENT"
+	}
+
+	// Create table on startup
+	query := `CREATE TABLE IF NOT EXISTS workflows (
+		` + idColumn + `,
+		workflowname VARCHAR(255) NOT NULL,
+		workflowtemplate VARCHAR(255) NOT NULL,
+		namespace VARCHAR(255) NOT NULL,
+		cluster VARCHAR(255) NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);`
+	if _, err := s.db.Exec(query); err != nil {
+		return err
+	}
+
+	// Migrations for tables created by earlier versions
+	for _, col := range addedColumns {
+		if err := s.addColumn("workflows", col); err != nil {
+			slog.Warn("Adding column failed", "column", col, "error", err)
+		}
+	}
+	return s.uniqueWorkflowNames()
+}
+
+// uniqueIndex makes workflow names unique per namespace, SaveWorkflows upserts against it
+const uniqueIndex = `CREATE UNIQUE INDEX IF NOT EXISTS workflows_workflowname_namespace ON workflows (workflowname, namespace)`
+
+// uniqueWorkflowNames adds uniqueIndex. Tables of earlier versions, which inserted a row per
+// submit, may hold several rows of a workflow: all but the newest are removed first.
+func (s *sqlStore) uniqueWorkflowNames() error {
+	if _, err := s.db.Exec(uniqueIndex); err == nil {
+		return nil
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`DELETE FROM workflows WHERE id NOT IN
+		(SELECT MAX(id) FROM workflows GROUP BY workflowname, namespace)`)
+	if err != nil {
+		return fmt.Errorf("removing duplicate workflow rows: %w", err)
+	}
+	if _, err := tx.Exec(uniqueIndex); err != nil {
+		return fmt.Errorf("adding unique index: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	slog.Info("Removed duplicate workflow rows and made workflow names unique per namespace", "count", n)
+	return nil
+}
+
+// addColumn adds a column unless it already exists
+func (s *sqlStore) addColumn(table, column string) error {
+	if s.dialect == "postgres" {
+		_, err := s.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s", table, column))
+		return err
+	}
+	// SQLite has no IF NOT EXISTS for columns
+	_, err := s.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", table, column))
+	if err != nil && strings.Contains(err.Error(), "duplicate column") {
+		return nil
+	}
+	return err
+}
+
+func (s *sqlStore) SaveWorkflow(rec WorkflowRecord) error {
+	return s.SaveWorkflows([]WorkflowRecord{rec})
+}
+
+// SaveWorkflows upserts all records with a single multi-row INSERT. A workflow name already
+// recorded in the namespace, e.g. after a resubmit, gets its row replaced by the new placement.
+func (s *sqlStore) SaveWorkflows(recs []WorkflowRecord) error {
+	recs = lastPerWorkflow(recs)
+	if len(recs) == 0 {
+		return nil
+	}
+	// Record to database: id, workflowname, workflowtemplate, namespace, cluster, balancer, cpu_total, mem_total,
+	// scout_free_cpu, scout_free_mem, cost_center
+	var values []string
+	var args []any
+	for _, rec := range recs {
+		n := len(args)
+		values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8, n+9, n+10))
+		args = append(args, rec.Name, rec.Template, rec.Namespace, rec.Cluster, rec.Balancer, rec.CPU, rec.RAM, rec.ScoutFreeCPU, rec.ScoutFreeMem,
+			sql.NullString{String: rec.CostCenter, Valid: rec.CostCenter != ""})
+	}
+	query := `INSERT INTO workflows (workflowname, workflowtemplate, namespace, cluster, balancer, cpu_total, mem_total, scout_free_cpu, scout_free_mem, cost_center) VALUES ` + strings.Join(values, ", ") + `
+		ON CONFLICT (workflowname, namespace) DO UPDATE SET workflowtemplate = excluded.workflowtemplate,
+			cluster = excluded.cluster, balancer = excluded.balancer, cpu_total = excluded.cpu_total,
+			mem_total = excluded.mem_total, scout_free_cpu = excluded.scout_free_cpu,
+			scout_free_mem = excluded.scout_free_mem, cost_center = excluded.cost_center, created_at = CURRENT_TIMESTAMP, deleted_at = NULL`
+	_, err := s.db.Exec(query, args...)
+	return err
+}
+
+// lastPerWorkflow keeps the last record of each workflow, Postgres refuses an upsert that
+// touches the same row twice
+func lastPerWorkflow(recs []WorkflowRecord) []WorkflowRecord {
+	type key struct{ name, ns string }
+	last := make(map[key]int, len(recs))
+	for i, rec := range recs {
+		last[key{rec.Name, rec.Namespace}] = i
+	}
+	if len(last) == len(recs) {
+		return recs
+	}
+	kept := make([]WorkflowRecord, 0, len(last))
+	for i, rec := range recs {
+		if last[key{rec.Name, rec.Namespace}] == i {
+			kept = append(kept, rec)
+		}
+	}
+	return kept
+}
+
+func (s *sqlStore) GetCluster(wfName, ns string) (string, error) {
+	var cluster string
+	// Search for cluster by workflow name and namespace
+	query := `SELECT cluster FROM workflows WHERE workflowname = $1 AND namespace = $2 ORDER BY id DESC LIMIT 1`
+	err := s.reader().QueryRow(query, wfName, ns).Scan(&cluster)
+	if err == sql.ErrNoRows && s.read != nil {
+		// A fresh submit may not have reached the replica yet
+		err = s.db.QueryRow(query, wfName, ns).Scan(&cluster)
+	}
+	return cluster, err
+}
+
+// LastTemplateCluster returns the cluster of the newest workflow of a template in a namespace
+func (s *sqlStore) LastTemplateCluster(template, ns string) (string, error) {
+	var cluster string
+	query := `SELECT cluster FROM workflows WHERE workflowtemplate = $1 AND namespace = $2 ORDER BY created_at DESC, id DESC LIMIT 1`
+	err := s.reader().QueryRow(query, template, ns).Scan(&cluster)
+	return cluster, err
+}
+
+// ActiveWorkflowExists reports whether a non-deleted record with this name exists
+func (s *sqlStore) ActiveWorkflowExists(wfName, ns string) (bool, error) {
+	var n int
+	query := `SELECT COUNT(*) FROM workflows WHERE workflowname = $1 AND namespace = $2 AND deleted_at IS NULL`
+	err := s.db.QueryRow(query, wfName, ns).Scan(&n)
+	return n > 0, err
+}
+
+// MarkDeleted soft-deletes the records of a workflow, they stay available for lookups
+func (s *sqlStore) MarkDeleted(wfName, ns string) error {
+	query := `UPDATE workflows SET deleted_at = CURRENT_TIMESTAMP WHERE workflowname = $1 AND namespace = $2 AND deleted_at IS NULL`
+	_, err := s.db.Exec(query, wfName, ns)
+	return err
+}
+
+// PurgeDeleted removes the records soft-deleted more than grace ago. The cutoff is computed by
+// the database from the clock deleted_at was set with, so the session time zone can't shift it.
+func (s *sqlStore) PurgeDeleted(grace time.Duration) (int64, error) {
+	query := `DELETE FROM workflows WHERE deleted_at IS NOT NULL AND deleted_at < CURRENT_TIMESTAMP - $1::interval`
+	arg := fmt.Sprintf("%d milliseconds", grace.Milliseconds())
+	if s.dialect == "sqlite" {
+		query = `DELETE FROM workflows WHERE deleted_at IS NOT NULL AND deleted_at < datetime('now', $1)`
+		arg = fmt.Sprintf("-%.3f seconds", grace.Seconds())
+	}
+	res, err := s.db.Exec(query, arg)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// CountActive returns the number of non-deleted workflows across all namespaces
+func (s *sqlStore) CountActive() (int, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM workflows WHERE deleted_at IS NULL`).Scan(&n)
+	return n, err
+}
+
+// ActiveUsage sums the resources of the non-deleted workflows of a namespace, memory in GB
+func (s *sqlStore) ActiveUsage(ns string) (cpu, mem float64, err error) {
+	query := `SELECT COALESCE(SUM(cpu_total), 0), COALESCE(SUM(mem_total), 0) FROM workflows WHERE namespace = $1 AND deleted_at IS NULL`
+	err = s.db.QueryRow(query, ns).Scan(&cpu, &mem)
+	return cpu, mem, err
+}
+
+// ActiveCounts groups the non-deleted workflows by namespace and cluster
+func (s *sqlStore) ActiveCounts() ([]ActiveCount, error) {
+	rows, err := s.reader().Query(`SELECT namespace, cluster, COUNT(*) FROM workflows
+		WHERE deleted_at IS NULL GROUP BY namespace, cluster`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []ActiveCount
+	for rows.Next() {
+		var c ActiveCount
+		if err := rows.Scan(&c.Namespace, &c.Cluster, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+// ListWorkflows returns the records of a namespace, newest first. An empty cluster matches
+// every cluster, a zero limit returns all records after offset.
+func (s *sqlStore) ListWorkflows(ns, cluster string, limit, offset int) ([]WorkflowRecord, error) {
+	query := `SELECT workflowname, workflowtemplate, namespace, cluster, COALESCE(balancer, ''),
+			COALESCE(cpu_total, 0), COALESCE(mem_total, 0), created_at
+		FROM workflows WHERE namespace = $1`
+	args := []any{ns}
+	if cluster != "" {
+		args = append(args, cluster)
+		query += fmt.Sprintf(" AND cluster = $%d", len(args))
+	}
+	// Resubmits keep the id of their row, created_at tells when they were placed
+	query += " ORDER BY created_at DESC, id DESC"
+	switch {
+	case limit > 0:
+		args = append(args, limit, offset)
+		query += fmt.Sprintf(" LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+	case offset > 0:
+		args = append(args, offset)
+		if s.dialect == "sqlite" {
+			// SQLite only takes OFFSET after a LIMIT, -1 means no limit
+			query += fmt.Sprintf(" LIMIT -1 OFFSET $%d", len(args))
+		} else {
+			query += fmt.Sprintf(" OFFSET $%d", len(args))
+		}
+	}
+	rows, err := s.reader().Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []WorkflowRecord{}
+	for rows.Next() {
+		var rec WorkflowRecord
+		if err := rows.Scan(&rec.Name, &rec.Template, &rec.Namespace, &rec.Cluster, &rec.Balancer, &rec.CPU, &rec.RAM, &rec.CreatedAt); err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+// exportBatch is how many rows ExportWorkflows fetches from the Postgres cursor at a time
+const exportBatch = 500
+
+// ExportWorkflows calls fn for every record created in [since, until), oldest first.
+// Rows are read through a cursor, the result set is never held in memory. Zero times are open ends.
+func (s *sqlStore) ExportWorkflows(since, until time.Time, fn func(WorkflowRecord) error) error {
+	if until.IsZero() {
+		until = time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	// created_at and deleted_at have no time zone and hold CURRENT_TIMESTAMP, which Postgres
+	// writes in the session's zone and SQLite in UTC. Postgres reads them as instants in that
+	// zone, SQLite gets the bounds as UTC text in its own format, so both compare like with like.
+	created, deleted := "created_at::timestamptz", "deleted_at::timestamptz"
+	args := []any{since, until}
+	if s.dialect == "sqlite" {
+		created, deleted = "created_at", "deleted_at"
+		args = []any{since.UTC().Format(sqliteTimeFormat), until.UTC().Format(sqliteTimeFormat)}
+	}
+	query := fmt.Sprintf(`SELECT workflowname, workflowtemplate, namespace, cluster, COALESCE(balancer, ''),
+			COALESCE(cpu_total, 0), COALESCE(mem_total, 0), COALESCE(cost_center, ''), %[1]s, %[2]s
+		FROM workflows WHERE %[1]s >= $1 AND %[1]s < $2 ORDER BY %[1]s, id`, created, deleted)
+
+	if s.dialect == "sqlite" {
+		// SQLite steps through the result as rows are read, the query is its own cursor
+		rows, err := s.reader().Query(query, args...)
+		if err != nil {
+			return err
+		}
+		_, err = scanExported(rows, fn)
+		return err
+	}
+
+	// Postgres sends a plain query's whole result at once, a server-side cursor hands it out in batches
+	tx, err := s.reader().BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DECLARE workflows_export NO SCROLL CURSOR FOR `+query, args...); err != nil {
+		return err
+	}
+	for {
+		rows, err := tx.Query(fmt.Sprintf(`FETCH %d FROM workflows_export`, exportBatch))
+		if err != nil {
+			return err
+		}
+		n, err := scanExported(rows, fn)
+		if err != nil || n < exportBatch {
+			return err
+		}
+	}
+}
+
+// sqliteTimeFormat is how SQLite's CURRENT_TIMESTAMP writes times, with fractions of a second added
+const sqliteTimeFormat = "2006-01-02 15:04:05.999999999"
+
+// scanExported calls fn for each row of an export query and closes rows. It returns how many rows it read.
+func scanExported(rows *sql.Rows, fn func(WorkflowRecord) error) (int, error) {
+	defer rows.Close()
+	n := 0
+	for rows.Next() {
+		var rec WorkflowRecord
+		var deleted sql.NullTime
+		if err := rows.Scan(&rec.Name, &rec.Template, &rec.Namespace, &rec.Cluster, &rec.Balancer,
+			&rec.CPU, &rec.RAM, &rec.CostCenter, &rec.CreatedAt, &deleted); err != nil {
+			return n, err
+		}
+		if deleted.Valid {
+			rec.DeletedAt = &deleted.Time
+		}
+		if err := fn(rec); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, rows.Err()
+}
+
+func (s *sqlStore) Ping(ctx context.Context) error {
+	if s.read != nil {
+		if err := s.read.PingContext(ctx); err != nil {
+			return fmt.Errorf("read replica: %w", err)
+		}
+	}
+	return s.db.PingContext(ctx)
+}
+
+func (s *sqlStore) Close() error {
+	if s.read != nil {
+		s.read.Close()
+	}
+	return s.db.Close()
+}
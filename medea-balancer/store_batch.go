@@ -0,0 +1,176 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// batchStore buffers placement records and writes them with one multi-row INSERT,
+// either when the buffer reaches size or every interval. Records still in the buffer
+// are lost if the process dies, Close flushes them on a normal shutdown.
+type batchStore struct {
+	Store
+
+	size     int
+	interval time.Duration
+
+	mu  sync.Mutex
+	buf []WorkflowRecord
+	// flushMu orders writes: a MarkDeleted waits for the batch that carries the record
+	flushMu sync.Mutex
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newBatchStore(s Store, size int, interval time.Duration) *batchStore {
+	b := &batchStore{
+		Store:    s,
+		size:     size,
+		interval: interval,
+		done:     make(chan struct{}),
+	}
+	b.wg.Add(1)
+	go b.loop()
+	return b
+}
+
+// SaveWorkflow queues the record, flushing right away when the batch is full
+func (b *batchStore) SaveWorkflow(rec WorkflowRecord) error {
+	b.mu.Lock()
+	b.buf = append(b.buf, rec)
+	full := len(b.buf) >= b.size
+	b.mu.Unlock()
+
+	if full {
+		b.flush()
+	}
+	return nil
+}
+
+// GetCluster also looks at records that are not flushed yet
+func (b *batchStore) GetCluster(wfName, ns string) (string, error) {
+	b.mu.Lock()
+	for i := len(b.buf) - 1; i >= 0; i-- {
+		if b.buf[i].Name == wfName && b.buf[i].Namespace == ns {
+			cluster := b.buf[i].Cluster
+			b.mu.Unlock()
+			return cluster, nil
+		}
+	}
+	b.mu.Unlock()
+	return b.Store.GetCluster(wfName, ns)
+}
+
+// LastTemplateCluster also looks at records that are not flushed yet
+func (b *batchStore) LastTemplateCluster(template, ns string) (string, error) {
+	b.mu.Lock()
+	for i := len(b.buf) - 1; i >= 0; i-- {
+		if b.buf[i].Template == template && b.buf[i].Namespace == ns {
+			cluster := b.buf[i].Cluster
+			b.mu.Unlock()
+			return cluster, nil
+		}
+	}
+	b.mu.Unlock()
+	return b.Store.LastTemplateCluster(template, ns)
+}
+
+// ActiveWorkflowExists also looks at records that are not flushed yet
+func (b *batchStore) ActiveWorkflowExists(wfName, ns string) (bool, error) {
+	b.mu.Lock()
+	for _, rec := range b.buf {
+		if rec.Name == wfName && rec.Namespace == ns {
+			b.mu.Unlock()
+			return true, nil
+		}
+	}
+	b.mu.Unlock()
+	return b.Store.ActiveWorkflowExists(wfName, ns)
+}
+
+// ActiveUsage flushes first so buffered records are counted
+func (b *batchStore) ActiveUsage(ns string) (float64, float64, error) {
+	b.flush()
+	return b.Store.ActiveUsage(ns)
+}
+
+// CountActive flushes first so buffered records are counted
+func (b *batchStore) CountActive() (int, error) {
+	b.flush()
+	return b.Store.CountActive()
+}
+
+// ActiveCounts flushes first so buffered records are counted
+func (b *batchStore) ActiveCounts() ([]ActiveCount, error) {
+	b.flush()
+	return b.Store.ActiveCounts()
+}
+
+// ListWorkflows flushes first so buffered records are listed
+func (b *batchStore) ListWorkflows(ns, cluster string, limit, offset int) ([]WorkflowRecord, error) {
+	b.flush()
+	return b.Store.ListWorkflows(ns, cluster, limit, offset)
+}
+
+// ExportWorkflows flushes first so buffered records are exported
+func (b *batchStore) ExportWorkflows(since, until time.Time, fn func(WorkflowRecord) error) error {
+	b.flush()
+	return b.Store.ExportWorkflows(since, until, fn)
+}
+
+// MarkDeleted flushes first so buffered records are marked too. The upsert of a batch clears
+// deleted_at, so no batch may be written between the flush and the update.
+func (b *batchStore) MarkDeleted(wfName, ns string) error {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+	b.flushLocked()
+	return b.Store.MarkDeleted(wfName, ns)
+}
+
+func (b *batchStore) loop() {
+	defer b.wg.Done()
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.flush()
+		case <-b.done:
+			return
+		}
+	}
+}
+
+// flush writes the buffered records, after any batch that is being written
+func (b *batchStore) flush() {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+	b.flushLocked()
+}
+
+func (b *batchStore) flushLocked() {
+	b.mu.Lock()
+	recs := b.buf
+	b.buf = nil
+	b.mu.Unlock()
+
+	if len(recs) == 0 {
+		return
+	}
+	if err := b.Store.SaveWorkflows(recs); err != nil {
+		dbWriteFailuresTotal.Add(float64(len(recs)))
+		slog.Error("Writing workflow batch to DB failed", "count", len(recs), "error", err)
+		return
+	}
+	slog.Info("Flushed workflow batch to DB", "count", len(recs))
+}
+
+// Close stops the flush loop, writes what is left and closes the underlying store
+func (b *batchStore) Close() error {
+	close(b.done)
+	b.wg.Wait()
+	b.flush()
+	return b.Store.Close()
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// memStore keeps records in memory with the upsert semantics of sqlStore. A write waits
+// for release when it is set, to hold a batch in flight.
+type memStore struct {
+	Store
+	mu      sync.Mutex
+	recs    map[[2]string]WorkflowRecord
+	writes  int
+	release chan struct{}
+}
+
+func newMemStore() *memStore {
+	return &memStore{recs: make(map[[2]string]WorkflowRecord)}
+}
+
+func (s *memStore) SaveWorkflows(recs []WorkflowRecord) error {
+	if s.release != nil {
+		<-s.release
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writes++
+	for _, rec := range recs {
+		rec.DeletedAt = nil
+		s.recs[[2]string{rec.Name, rec.Namespace}] = rec
+	}
+	return nil
+}
+
+func (s *memStore) MarkDeleted(wfName, ns string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rec, ok := s.recs[[2]string{wfName, ns}]; ok && rec.DeletedAt == nil {
+		now := time.Now()
+		rec.DeletedAt = &now
+		s.recs[[2]string{wfName, ns}] = rec
+	}
+	return nil
+}
+
+func (s *memStore) ActiveWorkflowExists(wfName, ns string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.recs[[2]string{wfName, ns}]
+	return ok && rec.DeletedAt == nil, nil
+}
+
+func (s *memStore) CountActive() (int, error) {
+	counts, _ := s.ActiveCounts()
+	n := 0
+	for _, c := range counts {
+		n += c.Count
+	}
+	return n, nil
+}
+
+func (s *memStore) ActiveCounts() ([]ActiveCount, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var counts []ActiveCount
+	for _, rec := range s.recs {
+		if rec.DeletedAt == nil {
+			counts = append(counts, ActiveCount{Namespace: rec.Namespace, Cluster: rec.Cluster, Count: 1})
+		}
+	}
+	return counts, nil
+}
+
+func (s *memStore) ListWorkflows(ns, cluster string, limit, offset int) ([]WorkflowRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var recs []WorkflowRecord
+	for _, rec := range s.recs {
+		if rec.Namespace == ns {
+			recs = append(recs, rec)
+		}
+	}
+	return recs, nil
+}
+
+func (s *memStore) Close() error { return nil }
+
+// stored returns how many records reached s and in how many writes
+func (s *memStore) stored() (recs, writes int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.recs), s.writes
+}
+
+func TestBatchStoreFlush(t *testing.T) {
+	rec := func(name string) WorkflowRecord {
+		return WorkflowRecord{Name: name, Template: "tpl", Namespace: "ns", Cluster: "east"}
+	}
+	tests := []struct {
+		name     string
+		size     int
+		interval time.Duration
+		saves    int
+		close    bool
+		// wantRecs reach the underlying store in wantWrites multi-row INSERTs
+		wantRecs, wantWrites int
+	}{
+		{"buffered below the size", 3, time.Hour, 2, false, 0, 0},
+		{"flushed at the size", 3, time.Hour, 3, false, 3, 1},
+		{"flushed per full batch", 2, time.Hour, 5, false, 4, 2},
+		{"flushed by the interval", 100, 10 * time.Millisecond, 2, false, 2, 1},
+		{"flushed on shutdown", 100, time.Hour, 2, true, 2, 1},
+	}
+	for _, tt := range tests {
+		mem := newMemStore()
+		b := newBatchStore(mem, tt.size, tt.interval)
+		for i := range tt.saves {
+			if err := b.SaveWorkflow(rec(string(rune('a' + i)))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if tt.close {
+			b.Close()
+		} else if tt.interval < time.Second {
+			deadline := time.Now().Add(time.Second)
+			for n, _ := mem.stored(); n < tt.wantRecs && time.Now().Before(deadline); n, _ = mem.stored() {
+				time.Sleep(5 * time.Millisecond)
+			}
+		}
+		if n, writes := mem.stored(); n != tt.wantRecs || writes != tt.wantWrites {
+			t.Errorf("%s: %d records in %d writes, want %d in %d", tt.name, n, writes, tt.wantRecs, tt.wantWrites)
+		}
+		if !tt.close {
+			b.Close()
+		}
+	}
+}
+
+func TestBatchStoreReadsSeeBuffered(t *testing.T) {
+	mem := newMemStore()
+	b := newBatchStore(mem, 100, time.Hour)
+	defer b.Close()
+	for _, name := range []string{"wf-1", "wf-2"} {
+		b.SaveWorkflow(WorkflowRecord{Name: name, Template: "tpl", Namespace: "ns", Cluster: "east"})
+	}
+	if n, err := b.CountActive(); err != nil || n != 2 {
+		t.Errorf("CountActive = %d, %v, want the 2 buffered records", n, err)
+	}
+	b.SaveWorkflow(WorkflowRecord{Name: "wf-3", Template: "tpl", Namespace: "ns", Cluster: "east"})
+	if counts, err := b.ActiveCounts(); err != nil || len(counts) != 3 {
+		t.Errorf("ActiveCounts = %v, %v, want 3 records", counts, err)
+	}
+	b.SaveWorkflow(WorkflowRecord{Name: "wf-4", Template: "tpl", Namespace: "ns", Cluster: "east"})
+	if recs, err := b.ListWorkflows("ns", "", 0, 0); err != nil || len(recs) != 4 {
+		t.Errorf("ListWorkflows = %d records, %v, want 4", len(recs), err)
+	}
+}
+
+func TestBatchStoreDeleteDuringFlush(t *testing.T) {
+	mem := newMemStore()
+	mem.release = make(chan struct{})
+	b := newBatchStore(mem, 100, time.Hour)
+	b.SaveWorkflow(WorkflowRecord{Name: "wf-1", Template: "tpl", Namespace: "ns", Cluster: "east"})
+
+	// A flush takes the record out of the buffer and is held before its write
+	flushed := make(chan struct{})
+	go func() {
+		b.flush()
+		close(flushed)
+	}()
+	for {
+		b.mu.Lock()
+		taken := len(b.buf) == 0
+		b.mu.Unlock()
+		if taken {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	deleted := make(chan error, 1)
+	go func() { deleted <- b.MarkDeleted("wf-1", "ns") }()
+	select {
+	case err := <-deleted:
+		t.Fatalf("MarkDeleted returned (%v) before the batch with the record was written", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(mem.release)
+	<-flushed
+	if err := <-deleted; err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := mem.ActiveWorkflowExists("wf-1", "ns"); ok {
+		t.Error("the batch write undid MarkDeleted")
+	}
+	b.Close()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// mirrorQueueSize bounds the writes waiting for the mirror, later ones are dropped
+const mirrorQueueSize = 1000
+
+// mirrorWrite is a write replayed on the mirror, name identifies it in logs
+type mirrorWrite struct {
+	name string
+	fn   func(Store) error
+}
+
+// mirrorStore copies every write that succeeded on the primary to a secondary database,
+// e.g. during a migration. Mirror writes run in order on a background goroutine; their
+// failures are logged and counted but never reach the caller. Reads only use the primary.
+type mirrorStore struct {
+	Store
+
+	mirror Store
+	queue  chan mirrorWrite
+	wg     sync.WaitGroup
+}
+
+func newMirrorStore(primary, mirror Store) *mirrorStore {
+	m := &mirrorStore{
+		Store:  primary,
+		mirror: mirror,
+		queue:  make(chan mirrorWrite, mirrorQueueSize),
+	}
+	// The table is created on the mirror before the first write, without holding off startup
+	m.enqueue(mirrorWrite{name: "init", fn: func(s Store) error { return s.Init() }})
+	m.wg.Add(1)
+	go m.loop()
+	return m
+}
+
+func (m *mirrorStore) SaveWorkflow(rec WorkflowRecord) error {
+	if err := m.Store.SaveWorkflow(rec); err != nil {
+		return err
+	}
+	m.enqueue(mirrorWrite{name: "save " + rec.Name, fn: func(s Store) error { return s.SaveWorkflow(rec) }})
+	return nil
+}
+
+func (m *mirrorStore) SaveWorkflows(recs []WorkflowRecord) error {
+	if err := m.Store.SaveWorkflows(recs); err != nil {
+		return err
+	}
+	m.enqueue(mirrorWrite{name: "save batch", fn: func(s Store) error { return s.SaveWorkflows(recs) }})
+	return nil
+}
+
+func (m *mirrorStore) MarkDeleted(wfName, ns string) error {
+	if err := m.Store.MarkDeleted(wfName, ns); err != nil {
+		return err
+	}
+	m.enqueue(mirrorWrite{name: "delete " + wfName, fn: func(s Store) error { return s.MarkDeleted(wfName, ns) }})
+	return nil
+}
+
+func (m *mirrorStore) PurgeDeleted(grace time.Duration) (int64, error) {
+	n, err := m.Store.PurgeDeleted(grace)
+	if err != nil {
+		return n, err
+	}
+	m.enqueue(mirrorWrite{name: "purge", fn: func(s Store) error {
+		_, err := s.PurgeDeleted(grace)
+		return err
+	}})
+	return n, nil
+}
+
+// enqueue hands a write to the mirror goroutine, dropping it when the mirror falls too far behind
+func (m *mirrorStore) enqueue(w mirrorWrite) {
+	select {
+	case m.queue <- w:
+	default:
+		mirrorWriteFailuresTotal.Inc()
+		slog.Warn("Mirror DB queue is full, dropping write", "write", w.name)
+	}
+}
+
+func (m *mirrorStore) loop() {
+	defer m.wg.Done()
+	for w := range m.queue {
+		if err := w.fn(m.mirror); err != nil {
+			mirrorWriteFailuresTotal.Inc()
+			slog.Error("Writing to mirror DB failed", "write", w.name, "error", err)
+		}
+	}
+}
+
+// Close writes what is queued for the mirror and closes both databases
+func (m *mirrorStore) Close() error {
+	close(m.queue)
+	m.wg.Wait()
+	m.mirror.Close()
+	return m.Store.Close()
+}
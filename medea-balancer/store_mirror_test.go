@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mirrorTarget is a memStore that can fail every write or hold it until block is closed
+type mirrorTarget struct {
+	*memStore
+	err   error
+	block chan struct{}
+}
+
+func (s *mirrorTarget) Init() error { return s.err }
+
+func (s *mirrorTarget) SaveWorkflow(rec WorkflowRecord) error {
+	if s.block != nil {
+		<-s.block
+	}
+	if s.err != nil {
+		return s.err
+	}
+	return s.memStore.SaveWorkflows([]WorkflowRecord{rec})
+}
+
+func (s *mirrorTarget) MarkDeleted(wfName, ns string) error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.memStore.MarkDeleted(wfName, ns)
+}
+
+func TestMirrorStore(t *testing.T) {
+	argo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"kind": "Workflow", "metadata": {"name": "wf-1"}}`))
+	}))
+	defer argo.Close()
+	scout := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"cluster": %q}`, argo.URL)
+	}))
+	defer scout.Close()
+	cfg := &Config{APIVersion: "1", APIVersions: []string{"1"}, ResourceKinds: defaultResourceKinds, MemoryUnit: memoryGB, ProxyTimeout: 5 * time.Second}
+	defer func() { store = nil }()
+	submit := func() int {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/workflows/batch-a/submit", strings.NewReader(`{"resourceKind": "WorkflowTemplate", "resourceName": "tpl"}`))
+		r.SetPathValue("namespace", "batch-a")
+		r = r.WithContext(context.WithValue(r.Context(), configKey{}, cfg))
+		w := httptest.NewRecorder()
+		handleSubmit(w, r, scout.URL)
+		return w.Code
+	}
+
+	tests := []struct {
+		name       string
+		mirrorErr  error
+		wantMirror int // records on the mirror after the submit and a delete
+	}{
+		{"both databases get the write", nil, 1},
+		{"mirror failures stay off the request path", errors.New("mirror down"), 0},
+	}
+	for _, tt := range tests {
+		primary := &mirrorTarget{memStore: newMemStore()}
+		mirror := &mirrorTarget{memStore: newMemStore(), err: tt.mirrorErr}
+		m := newMirrorStore(primary, mirror)
+		store = m
+		failures := counterValue(t, mirrorWriteFailuresTotal)
+
+		if code := submit(); code != http.StatusOK {
+			t.Fatalf("%s: submit answered %d", tt.name, code)
+		}
+		if err := m.MarkDeleted("wf-1", "batch-a"); err != nil {
+			t.Errorf("%s: MarkDeleted = %v", tt.name, err)
+		}
+		// Close waits for the queued mirror writes
+		m.Close()
+
+		if n, _ := primary.stored(); n != 1 {
+			t.Errorf("%s: %d records on the primary, want 1", tt.name, n)
+		}
+		if n, _ := mirror.stored(); n != tt.wantMirror {
+			t.Errorf("%s: %d records on the mirror, want %d", tt.name, n, tt.wantMirror)
+		}
+		if tt.wantMirror > 0 {
+			if ok, _ := mirror.ActiveWorkflowExists("wf-1", "batch-a"); ok {
+				t.Errorf("%s: the delete didn't reach the mirror", tt.name)
+			}
+		}
+		// Init, save and delete each fail once on a broken mirror
+		wantFailures := 0.0
+		if tt.mirrorErr != nil {
+			wantFailures = 3
+		}
+		if d := counterValue(t, mirrorWriteFailuresTotal) - failures; d != wantFailures {
+			t.Errorf("%s: %v mirror failures counted, want %v", tt.name, d, wantFailures)
+		}
+	}
+
+	// A hung mirror doesn't hold up the primary write
+	mirror := &mirrorTarget{memStore: newMemStore(), block: make(chan struct{})}
+	m := newMirrorStore(&mirrorTarget{memStore: newMemStore()}, mirror)
+	done := make(chan error, 1)
+	go func() { done <- m.SaveWorkflow(WorkflowRecord{Name: "wf-2", Namespace: "batch-a", Cluster: "east"}) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Error("SaveWorkflow waited for the mirror")
+	}
+	close(mirror.block)
+	m.Close()
+	if n, _ := mirror.stored(); n != 1 {
+		t.Errorf("%d records on the mirror once it recovered, want 1", n)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build sqlite
+
+package main
+
+// SQLite support is opt-in to keep the default binary free of the driver:
+// go build -tags sqlite -o medea-balancer .
+import _ "modernc.org/sqlite"
+
+func init() {
+	sqliteAvailable = true
+}
@@ -0,0 +1,96 @@
+//go:build sqlite
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTestStore(t *testing.T) *sqlStore {
+	t.Helper()
+	s, err := openStore("sqlite", filepath.Join(t.TempDir(), "medea.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestCostCenterStored(t *testing.T) {
+	s := openTestStore(t)
+	for _, rec := range []WorkflowRecord{
+		{Name: "tagged", Template: "tpl", Namespace: "team-a-batch", Cluster: "east", CostCenter: "cc-a"},
+		{Name: "untagged", Template: "tpl", Namespace: "other", Cluster: "east"},
+	} {
+		if err := s.SaveWorkflow(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := make(map[string]string)
+	if err := s.ExportWorkflows(time.Time{}, time.Time{}, func(rec WorkflowRecord) error {
+		got[rec.Name] = rec.CostCenter
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got["tagged"] != "cc-a" || got["untagged"] != "" || len(got) != 2 {
+		t.Errorf("exported cost centers %v, want tagged=cc-a and untagged empty", got)
+	}
+	var null bool
+	if err := s.db.QueryRow(`SELECT cost_center IS NULL FROM workflows WHERE workflowname = 'untagged'`).Scan(&null); err != nil || !null {
+		t.Errorf("cost_center of an untagged record is not NULL (%v)", err)
+	}
+}
+
+func TestReadReplica(t *testing.T) {
+	currentConfig.Store(&Config{})
+	defer currentConfig.Store(nil)
+	s := openTestStore(t)
+	// The replica is another database, so every row shows which handle answered
+	replicaPath := filepath.Join(t.TempDir(), "replica.db")
+	replica, err := openStore("sqlite", replicaPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replica.Close()
+	if err := replica.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.openReplica(replicaPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := replica.SaveWorkflow(WorkflowRecord{Name: "wf-1", Template: "tpl", Namespace: "ns", Cluster: "replica"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Writes go to the primary only
+	for _, name := range []string{"wf-1", "wf-2"} {
+		if err := s.SaveWorkflow(WorkflowRecord{Name: name, Template: "tpl", Namespace: "ns", Cluster: "primary"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if recs, err := replica.ListWorkflows("ns", "", 0, 0); err != nil || len(recs) != 1 {
+		t.Fatalf("replica holds %v (%v), want only its own record", names(recs), err)
+	}
+
+	// Reads go to the replica
+	if cluster, err := s.GetCluster("wf-1", "ns"); err != nil || cluster != "replica" {
+		t.Errorf("GetCluster(wf-1) = %q, %v, want the replica's answer", cluster, err)
+	}
+	if recs, err := s.ListWorkflows("ns", "", 0, 0); err != nil || len(recs) != 1 || recs[0].Cluster != "replica" {
+		t.Errorf("ListWorkflows = %+v, %v, want the replica's record", recs, err)
+	}
+	// A submit the replica hasn't caught up with is looked up on the primary
+	if cluster, err := s.GetCluster("wf-2", "ns"); err != nil || cluster != "primary" {
+		t.Errorf("GetCluster(wf-2) = %q, %v, want the primary's answer", cluster, err)
+	}
+	// Lookups that guard writes stay on the primary
+	if ok, err := s.ActiveWorkflowExists("wf-2", "ns"); err != nil || !ok {
+		t.Errorf("ActiveWorkflowExists(wf-2) = %v, %v, want the primary's answer", ok, err)
+	}
+}
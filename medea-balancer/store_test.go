@@ -0,0 +1,383 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// storeBackends open an empty store per backend. SQLite needs a build with -tags sqlite,
+// Postgres a scratch database in MEDEA_TEST_POSTGRES_URL, whose workflows table is dropped.
+var storeBackends = []struct {
+	name string
+	open func(t *testing.T) *sqlStore
+}{
+	{"postgres", func(t *testing.T) *sqlStore {
+		dsn := os.Getenv("MEDEA_TEST_POSTGRES_URL")
+		if dsn == "" {
+			t.Skip("MEDEA_TEST_POSTGRES_URL is not set")
+		}
+		if currentConfig.Load() == nil {
+			currentConfig.Store(&Config{})
+			t.Cleanup(func() { currentConfig.Store(nil) })
+		}
+		s, err := openStore("postgres", dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.db.Exec(`DROP TABLE IF EXISTS workflows`); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}},
+	{"sqlite", func(t *testing.T) *sqlStore {
+		if !sqliteAvailable {
+			t.Skip("SQLite needs -tags sqlite")
+		}
+		s, err := openStore("sqlite", filepath.Join(t.TempDir(), "medea.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}},
+}
+
+// age moves a timestamp column of a workflow back by d, as if it had been set d earlier
+func age(t *testing.T, s *sqlStore, column, name string, d time.Duration) {
+	t.Helper()
+	query := fmt.Sprintf(`UPDATE workflows SET %[1]s = %[1]s - $1::interval WHERE workflowname = $2`, column)
+	arg := fmt.Sprintf("%d seconds", int(d.Seconds()))
+	if s.dialect == "sqlite" {
+		query = fmt.Sprintf(`UPDATE workflows SET %[1]s = datetime(%[1]s, $1) WHERE workflowname = $2`, column)
+		arg = "-" + arg
+	}
+	if _, err := s.db.Exec(query, arg, name); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// names lists the workflow names of recs in order
+func names(recs []WorkflowRecord) []string {
+	var out []string
+	for _, rec := range recs {
+		out = append(out, rec.Name)
+	}
+	return out
+}
+
+// TestStoreContract runs the same checks against every backend, so they behave alike
+func TestStoreContract(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, s *sqlStore)
+	}{
+		{"SaveWorkflows upserts resubmits", func(t *testing.T, s *sqlStore) {
+			if err := s.SaveWorkflows([]WorkflowRecord{
+				{Name: "wf-1", Template: "tpl", Namespace: "ns", Cluster: "east", CPU: 1},
+				{Name: "wf-2", Template: "tpl", Namespace: "ns", Cluster: "east"},
+				{Name: "wf-1", Template: "tpl", Namespace: "ns", Cluster: "west", CPU: 2},
+				{Name: "wf-1", Template: "tpl", Namespace: "other", Cluster: "east"},
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if cluster, err := s.GetCluster("wf-1", "ns"); err != nil || cluster != "west" {
+				t.Errorf("GetCluster = %q, %v, want the last placement west", cluster, err)
+			}
+			recs, err := s.ListWorkflows("ns", "", 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(recs) != 2 {
+				t.Errorf("ListWorkflows = %v, want one row per workflow", names(recs))
+			}
+			// A resubmit of a deleted workflow makes it active again
+			if err := s.MarkDeleted("wf-2", "ns"); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.SaveWorkflows([]WorkflowRecord{{Name: "wf-2", Template: "tpl", Namespace: "ns", Cluster: "west"}}); err != nil {
+				t.Fatal(err)
+			}
+			if ok, err := s.ActiveWorkflowExists("wf-2", "ns"); err != nil || !ok {
+				t.Errorf("resubmitted workflow active = %v, %v, want true", ok, err)
+			}
+			if err := s.SaveWorkflows(nil); err != nil {
+				t.Errorf("SaveWorkflows(nil) = %v", err)
+			}
+		}},
+		{"MarkDeleted keeps the record for lookups", func(t *testing.T, s *sqlStore) {
+			for _, name := range []string{"wf-1", "wf-2"} {
+				if err := s.SaveWorkflow(WorkflowRecord{Name: name, Template: "tpl", Namespace: "ns", Cluster: "east", CPU: 2, RAM: 4}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := s.MarkDeleted("wf-1", "ns"); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.MarkDeleted("missing", "ns"); err != nil {
+				t.Errorf("MarkDeleted of an unknown workflow = %v", err)
+			}
+			if ok, err := s.ActiveWorkflowExists("wf-1", "ns"); err != nil || ok {
+				t.Errorf("deleted workflow active = %v, %v, want false", ok, err)
+			}
+			if cluster, err := s.GetCluster("wf-1", "ns"); err != nil || cluster != "east" {
+				t.Errorf("GetCluster of a deleted workflow = %q, %v, want east", cluster, err)
+			}
+			if n, err := s.CountActive(); err != nil || n != 1 {
+				t.Errorf("CountActive = %d, %v, want 1", n, err)
+			}
+			if cpu, mem, err := s.ActiveUsage("ns"); err != nil || cpu != 2 || mem != 4 {
+				t.Errorf("ActiveUsage = %v, %v, %v, want 2 and 4", cpu, mem, err)
+			}
+			counts, err := s.ActiveCounts()
+			if err != nil || !slices.Equal(counts, []ActiveCount{{Namespace: "ns", Cluster: "east", Count: 1}}) {
+				t.Errorf("ActiveCounts = %v, %v, want 1 on ns/east", counts, err)
+			}
+		}},
+		{"PurgeDeleted removes only records deleted before the grace", func(t *testing.T, s *sqlStore) {
+			for _, name := range []string{"old", "recent", "active"} {
+				if err := s.SaveWorkflow(WorkflowRecord{Name: name, Template: "tpl", Namespace: "ns", Cluster: "east"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, name := range []string{"old", "recent"} {
+				if err := s.MarkDeleted(name, "ns"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			age(t, s, "deleted_at", "old", 2*time.Hour)
+			if n, err := s.PurgeDeleted(time.Hour); err != nil || n != 1 {
+				t.Errorf("PurgeDeleted = %d, %v, want 1", n, err)
+			}
+			recs, err := s.ListWorkflows("ns", "", 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := names(recs); len(got) != 2 || slices.Contains(got, "old") {
+				t.Errorf("left %v, want recent and active", got)
+			}
+		}},
+		{"ListWorkflows filters and pages newest first", func(t *testing.T, s *sqlStore) {
+			for _, rec := range []WorkflowRecord{
+				{Name: "wf-1", Cluster: "east"}, {Name: "wf-2", Cluster: "west"}, {Name: "wf-3", Cluster: "east"},
+			} {
+				rec.Template, rec.Namespace = "tpl", "ns"
+				if err := s.SaveWorkflow(rec); err != nil {
+					t.Fatal(err)
+				}
+			}
+			pages := []struct {
+				cluster       string
+				limit, offset int
+				want          []string
+			}{
+				{"", 0, 0, []string{"wf-3", "wf-2", "wf-1"}},
+				{"east", 0, 0, []string{"wf-3", "wf-1"}},
+				{"", 2, 1, []string{"wf-2", "wf-1"}},
+				{"", 0, 2, []string{"wf-1"}},
+			}
+			for _, p := range pages {
+				recs, err := s.ListWorkflows("ns", p.cluster, p.limit, p.offset)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := names(recs); !slices.Equal(got, p.want) {
+					t.Errorf("ListWorkflows(%q, %d, %d) = %v, want %v", p.cluster, p.limit, p.offset, got, p.want)
+				}
+			}
+		}},
+		{"ExportWorkflows streams every record oldest first", func(t *testing.T, s *sqlStore) {
+			for _, name := range []string{"wf-1", "wf-2", "wf-3"} {
+				if err := s.SaveWorkflow(WorkflowRecord{Name: name, Template: "tpl", Namespace: "ns", Cluster: "east", CostCenter: "cc"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := s.MarkDeleted("wf-2", "ns"); err != nil {
+				t.Fatal(err)
+			}
+			var got []WorkflowRecord
+			if err := s.ExportWorkflows(time.Time{}, time.Time{}, func(rec WorkflowRecord) error {
+				got = append(got, rec)
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(names(got), []string{"wf-1", "wf-2", "wf-3"}) {
+				t.Fatalf("exported %v, want wf-1, wf-2, wf-3", names(got))
+			}
+			for _, rec := range got {
+				if deleted := rec.Name == "wf-2"; (rec.DeletedAt != nil) != deleted || rec.CostCenter != "cc" {
+					t.Errorf("exported %s with deletedAt %v and cost center %q", rec.Name, rec.DeletedAt, rec.CostCenter)
+				}
+			}
+		}},
+		{"SaveWorkflow records the placing balancer", func(t *testing.T, s *sqlStore) {
+			// A row of a version without the balancer column reads as placed by no one
+			if _, err := s.db.Exec(`INSERT INTO workflows (workflowname, workflowtemplate, namespace, cluster) VALUES ('wf-old', 'tpl', 'ns', 'east')`); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.SaveWorkflow(WorkflowRecord{Name: "wf-new", Template: "tpl", Namespace: "ns", Cluster: "east", Balancer: "balancer-eu-1"}); err != nil {
+				t.Fatal(err)
+			}
+			recs, err := s.ListWorkflows("ns", "", 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]string)
+			for _, rec := range recs {
+				got[rec.Name] = rec.Balancer
+			}
+			if len(got) != 2 || got["wf-new"] != "balancer-eu-1" || got["wf-old"] != "" {
+				t.Errorf("recorded balancers %v, want wf-new on balancer-eu-1 and wf-old on none", got)
+			}
+		}},
+		{"LastTemplateCluster returns the newest cluster of the template", func(t *testing.T, s *sqlStore) {
+			for _, rec := range []WorkflowRecord{
+				{Name: "wf-1", Template: "tpl", Namespace: "ns", Cluster: "east"},
+				{Name: "wf-2", Template: "tpl", Namespace: "ns", Cluster: "west"},
+				{Name: "wf-3", Template: "other", Namespace: "ns", Cluster: "north"},
+				{Name: "wf-4", Template: "tpl", Namespace: "elsewhere", Cluster: "south"},
+			} {
+				if err := s.SaveWorkflow(rec); err != nil {
+					t.Fatal(err)
+				}
+			}
+			age(t, s, "created_at", "wf-1", time.Hour)
+			if cluster, err := s.LastTemplateCluster("tpl", "ns"); err != nil || cluster != "west" {
+				t.Errorf("LastTemplateCluster = %q, %v, want west", cluster, err)
+			}
+			if _, err := s.LastTemplateCluster("never-run", "ns"); !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("LastTemplateCluster of an unknown template = %v, want sql.ErrNoRows", err)
+			}
+		}},
+		{"ExportWorkflows filters by creation time in any zone", func(t *testing.T, s *sqlStore) {
+			if s.dialect == "postgres" {
+				// One connection, so the session zone below applies to every query
+				s.db.SetMaxOpenConns(1)
+				if _, err := s.db.Exec(`SET TIME ZONE 'Asia/Kolkata'`); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, name := range []string{"wf-old", "wf-new"} {
+				if err := s.SaveWorkflow(WorkflowRecord{Name: name, Template: "tpl", Namespace: "ns", Cluster: "east"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			age(t, s, "created_at", "wf-old", 2*time.Hour)
+			// The bounds come in a zone that is neither UTC nor the session's
+			hourAgo := time.Now().Add(-time.Hour).In(time.FixedZone("UTC-7", -7*3600))
+			windows := []struct {
+				name         string
+				since, until time.Time
+				want         []string
+			}{
+				{"open", time.Time{}, time.Time{}, []string{"wf-old", "wf-new"}},
+				{"since", hourAgo, time.Time{}, []string{"wf-new"}},
+				{"until", time.Time{}, hourAgo, []string{"wf-old"}},
+				{"empty", hourAgo.Add(-30 * time.Minute), hourAgo, nil},
+			}
+			for _, win := range windows {
+				var got []WorkflowRecord
+				if err := s.ExportWorkflows(win.since, win.until, func(rec WorkflowRecord) error {
+					got = append(got, rec)
+					return nil
+				}); err != nil {
+					t.Fatal(err)
+				}
+				if !slices.Equal(names(got), win.want) {
+					t.Errorf("%s: exported %v, want %v", win.name, names(got), win.want)
+				}
+				// Exported times are the instants the rows were written
+				for _, rec := range got {
+					if rec.Name == "wf-new" && time.Since(rec.CreatedAt).Abs() > time.Minute {
+						t.Errorf("%s: wf-new exported as created at %v", win.name, rec.CreatedAt)
+					}
+				}
+			}
+		}},
+	}
+	for _, backend := range storeBackends {
+		for _, tt := range tests {
+			t.Run(backend.name+"/"+tt.name, func(t *testing.T) {
+				s := backend.open(t)
+				t.Cleanup(func() { s.Close() })
+				if err := s.Init(); err != nil {
+					t.Fatal(err)
+				}
+				tt.run(t, s)
+			})
+		}
+	}
+}
+
+// TestInitDedupesWorkflows starts from a table of a version that inserted a row per submit
+func TestInitDedupesWorkflows(t *testing.T) {
+	for _, backend := range storeBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			s := backend.open(t)
+			t.Cleanup(func() { s.Close() })
+			idColumn := "id SERIAL PRIMARY KEY"
+			if s.dialect == "sqlite" {
+				idColumn = "id INTEGER PRIMARY KEY AUTOINCREMENT"
+			}
+			if _, err := s.db.Exec(`CREATE TABLE workflows (
+				` + idColumn + `,
+				workflowname VARCHAR(255) NOT NULL,
+				workflowtemplate VARCHAR(255) NOT NULL,
+				namespace VARCHAR(255) NOT NULL,
+				cluster VARCHAR(255) NOT NULL,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			)`); err != nil {
+				t.Fatal(err)
+			}
+			for _, row := range [][3]string{
+				{"wf-1", "ns", "east"},
+				{"wf-2", "ns", "east"},
+				{"wf-1", "ns", "west"},
+				{"wf-1", "other", "east"},
+				{"wf-1", "ns", "north"},
+			} {
+				if _, err := s.db.Exec(`INSERT INTO workflows (workflowname, workflowtemplate, namespace, cluster) VALUES ($1, 'tpl', $2, $3)`, row[0], row[1], row[2]); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := s.Init(); err != nil {
+				t.Fatalf("Init over duplicate rows: %v", err)
+			}
+			var rows int
+			if err := s.db.QueryRow(`SELECT COUNT(*) FROM workflows`).Scan(&rows); err != nil || rows != 3 {
+				t.Errorf("%d rows left (%v), want one per workflow and namespace", rows, err)
+			}
+			// The newest row of a workflow is the one kept
+			for _, tt := range []struct{ name, ns, want string }{
+				{"wf-1", "ns", "north"},
+				{"wf-2", "ns", "east"},
+				{"wf-1", "other", "east"},
+			} {
+				if cluster, err := s.GetCluster(tt.name, tt.ns); err != nil || cluster != tt.want {
+					t.Errorf("GetCluster(%s, %s) = %q, %v, want %q", tt.name, tt.ns, cluster, err, tt.want)
+				}
+			}
+
+			// Saves now update the remaining row instead of adding one
+			if err := s.SaveWorkflow(WorkflowRecord{Name: "wf-1", Template: "tpl", Namespace: "ns", Cluster: "south"}); err != nil {
+				t.Fatal(err)
+			}
+			if cluster, err := s.GetCluster("wf-1", "ns"); err != nil || cluster != "south" {
+				t.Errorf("GetCluster after a resubmit = %q, %v, want south", cluster, err)
+			}
+			if err := s.db.QueryRow(`SELECT COUNT(*) FROM workflows`).Scan(&rows); err != nil || rows != 3 {
+				t.Errorf("%d rows after a resubmit (%v), want 3", rows, err)
+			}
+			// Restarts find the index in place
+			if err := s.Init(); err != nil {
+				t.Errorf("second Init: %v", err)
+			}
+		})
+	}
+}
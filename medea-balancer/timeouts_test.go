@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServerCutsOffSlowHeaders(t *testing.T) {
+	cfg := &Config{StartupConfig: StartupConfig{ReadTimeout: time.Second, ReadHeaderTimeout: 100 * time.Millisecond, WriteTimeout: time.Second}}
+	srv := httptest.NewUnstartedServer(nil)
+	srv.Config = newServer(cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.Start()
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// Headers that never end, as a slowloris client sends them
+	if _, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: medea\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	start := time.Now()
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Fatalf("the connection was not closed: %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("the connection was closed after %v, want about the header timeout", d)
+	}
+}
+
+func TestExportOutlastsWriteTimeout(t *testing.T) {
+	// Each row takes longer than the whole write timeout
+	store = &slowExportStore{rows: 3, delay: 150 * time.Millisecond}
+	defer func() { store = nil }()
+	cfg := &Config{StartupConfig: StartupConfig{ReadTimeout: time.Second, ReadHeaderTimeout: time.Second, WriteTimeout: 100 * time.Millisecond}}
+	srv := httptest.NewUnstartedServer(nil)
+	srv.Config = newServer(cfg, http.HandlerFunc(handleExport))
+	srv.Start()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + exportPath + "?format=csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	lines := 0
+	for sc := bufio.NewScanner(resp.Body); sc.Scan(); {
+		lines++
+	}
+	if lines != 4 {
+		t.Errorf("export streamed %d lines, want the header and 3 rows", lines)
+	}
+}
+
+// slowExportStore exports rows slowly, like a large table
+type slowExportStore struct {
+	Store
+	rows  int
+	delay time.Duration
+}
+
+func (s *slowExportStore) ExportWorkflows(since, until time.Time, fn func(WorkflowRecord) error) error {
+	for i := range s.rows {
+		time.Sleep(s.delay)
+		if err := fn(WorkflowRecord{Name: fmt.Sprintf("wf-%d", i)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// webhookQueueSize bounds the events waiting per destination, later ones are dropped
+	webhookQueueSize = 1000
+	// webhookTimeout bounds a single delivery
+	webhookTimeout = 10 * time.Second
+)
+
+// placementWebhooks posts every placement to each MEDEA_PLACEMENT_WEBHOOKS destination.
+// Each destination has its own queue and goroutine, so a slow one neither delays submits nor
+// the others, and it never gets more than one POST at a time. With a batch size above 1 events
+// are sent as a JSON array once the batch is full or its oldest event has waited batchInterval.
+// A nil *placementWebhooks is valid and discards everything.
+type placementWebhooks struct {
+	dests []*webhookDest
+	wg    sync.WaitGroup
+}
+
+type webhookDest struct {
+	url    string
+	events chan WorkflowRecord
+}
+
+// Global placement webhooks, nil when disabled
+var webhooks *placementWebhooks
+
+func newPlacementWebhooks(urls []string, batchSize int, batchInterval time.Duration) *placementWebhooks {
+	p := &placementWebhooks{}
+	client := &http.Client{Timeout: webhookTimeout}
+	for _, url := range urls {
+		d := &webhookDest{url: url, events: make(chan WorkflowRecord, webhookQueueSize)}
+		p.dests = append(p.dests, d)
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			d.loop(client, batchSize, batchInterval)
+		}()
+	}
+	return p
+}
+
+// Send queues a placement for every destination
+func (p *placementWebhooks) Send(rec WorkflowRecord) {
+	if p == nil {
+		return
+	}
+	if rec.CreatedAt.IsZero() {
+		rec.CreatedAt = time.Now().UTC()
+	}
+	for _, d := range p.dests {
+		select {
+		case d.events <- rec:
+		default:
+			webhookFailuresTotal.Inc()
+			slog.Warn("Webhook queue is full, dropping placement", "url", d.url, "workflow", rec.Name, "namespace", rec.Namespace)
+		}
+	}
+}
+
+// Close delivers the queued events and stops the destinations
+func (p *placementWebhooks) Close() {
+	if p == nil {
+		return
+	}
+	for _, d := range p.dests {
+		close(d.events)
+	}
+	p.wg.Wait()
+}
+
+func (d *webhookDest) loop(client *http.Client, batchSize int, batchInterval time.Duration) {
+	if batchSize <= 1 {
+		for rec := range d.events {
+			d.post(client, rec, 1)
+		}
+		return
+	}
+
+	var batch []WorkflowRecord
+	timer := time.NewTimer(batchInterval)
+	timer.Stop()
+	flush := func() {
+		timer.Stop()
+		if len(batch) > 0 {
+			d.post(client, batch, len(batch))
+			batch = nil
+		}
+	}
+	for {
+		select {
+		case rec, ok := <-d.events:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, rec)
+			if len(batch) == 1 {
+				timer.Reset(batchInterval)
+			}
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-timer.C:
+			flush()
+		}
+	}
+}
+
+// post delivers payload, a single event or a batch of n events
+func (d *webhookDest) post(client *http.Client, payload any, n int) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	resp, err := client.Post(d.url, "application/json", bytes.NewReader(body))
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			err = fmt.Errorf("status %d", resp.StatusCode)
+		}
+	}
+	if err != nil {
+		webhookFailuresTotal.Add(float64(n))
+		slog.Error("Webhook delivery failed", "url", d.url, "count", n, "error", err)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// webhookSink is a webhook destination that hands over each POST body
+func webhookSink(t *testing.T) (*httptest.Server, chan []byte) {
+	t.Helper()
+	bodies := make(chan []byte, 100)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- body
+	}))
+	t.Cleanup(srv.Close)
+	return srv, bodies
+}
+
+// nextBatch waits for the next POST and returns the names of the events in it
+func nextBatch(t *testing.T, bodies chan []byte, wait time.Duration) []string {
+	t.Helper()
+	select {
+	case body := <-bodies:
+		var recs []WorkflowRecord
+		if err := json.Unmarshal(body, &recs); err != nil {
+			t.Fatalf("POST %s is not an array of events: %v", body, err)
+		}
+		return names(recs)
+	case <-time.After(wait):
+		return nil
+	}
+}
+
+// sendPlacements sends n placements named wf-0 onwards
+func sendPlacements(p *placementWebhooks, n int) {
+	for i := range n {
+		p.Send(WorkflowRecord{Name: fmt.Sprintf("wf-%d", i), Namespace: "batch-a", Cluster: "east"})
+	}
+}
+
+func TestWebhookSingleEvents(t *testing.T) {
+	srv, bodies := webhookSink(t)
+	p := newPlacementWebhooks([]string{srv.URL}, 1, time.Hour)
+	sendPlacements(p, 2)
+	p.Close()
+	for i := range 2 {
+		var rec WorkflowRecord
+		if err := json.Unmarshal(<-bodies, &rec); err != nil || rec.Name != fmt.Sprintf("wf-%d", i) || rec.CreatedAt.IsZero() {
+			t.Errorf("event %d delivered as %+v, %v", i, rec, err)
+		}
+	}
+}
+
+func TestWebhookBatchByCount(t *testing.T) {
+	srv, bodies := webhookSink(t)
+	other, otherBodies := webhookSink(t)
+	// The interval never runs out here, only full batches are sent
+	p := newPlacementWebhooks([]string{srv.URL, other.URL}, 3, time.Hour)
+	sendPlacements(p, 7)
+	for _, dest := range []chan []byte{bodies, otherBodies} {
+		if got := nextBatch(t, dest, 5*time.Second); fmt.Sprint(got) != "[wf-0 wf-1 wf-2]" {
+			t.Errorf("first batch %v", got)
+		}
+		if got := nextBatch(t, dest, 5*time.Second); fmt.Sprint(got) != "[wf-3 wf-4 wf-5]" {
+			t.Errorf("second batch %v", got)
+		}
+		if got := nextBatch(t, dest, 100*time.Millisecond); got != nil {
+			t.Errorf("a partial batch %v was sent before the interval", got)
+		}
+	}
+	// What is left goes out on Close
+	p.Close()
+	for _, dest := range []chan []byte{bodies, otherBodies} {
+		if got := nextBatch(t, dest, time.Second); fmt.Sprint(got) != "[wf-6]" {
+			t.Errorf("batch on close %v, want [wf-6]", got)
+		}
+	}
+}
+
+func TestWebhookBatchByInterval(t *testing.T) {
+	srv, bodies := webhookSink(t)
+	p := newPlacementWebhooks([]string{srv.URL}, 100, 50*time.Millisecond)
+	defer p.Close()
+	start := time.Now()
+	sendPlacements(p, 2)
+	got := nextBatch(t, bodies, 5*time.Second)
+	if fmt.Sprint(got) != "[wf-0 wf-1]" {
+		t.Fatalf("batch %v, want [wf-0 wf-1]", got)
+	}
+	if d := time.Since(start); d < 40*time.Millisecond {
+		t.Errorf("batch sent after %v, before the interval", d)
+	}
+	// The next event starts a new interval
+	sendPlacements(p, 1)
+	if got := nextBatch(t, bodies, 5*time.Second); fmt.Sprint(got) != "[wf-0]" {
+		t.Errorf("batch %v, want [wf-0]", got)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// resourceCache keeps the last Prometheus result per namespace and query.
+// Entries are served while younger than the TTL; they are replaced, never evicted.
+type resourceCache struct {
+	mu      sync.Mutex
+	entries map[cacheKey]cacheEntry
+}
+
+type cacheKey struct {
+	namespace string
+	query     string
+}
+
+type cacheEntry struct {
+	values    map[string]float64
+	fetchedAt time.Time
+}
+
+// Global cache of Prometheus results, only filled when caching, stale serving or the query rate cap is enabled
+var cache = resourceCache{entries: make(map[cacheKey]cacheEntry)}
+
+// get returns the entry for key if it is younger than maxAge
+func (c *resourceCache) get(key cacheKey, maxAge time.Duration) (map[string]float64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || time.Since(e.fetchedAt) >= maxAge {
+		return nil, false
+	}
+	return e.values, true
+}
+
+func (c *resourceCache) put(key cacheKey, values map[string]float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = cacheEntry{values: values, fetchedAt: time.Now()}
+}
+
+// cachedResources is fetchResources behind the cache; a zero TTL disables caching.
+// When Prometheus fails and MEDEA_SCOUT_MAX_STALE allows it, or the query is shed by the rate cap
+// and PROMETHEUS_QUERY_MAX_STALE allows it, the last result is served and stale is true.
+func cachedResources(ctx context.Context, namespace string, q promQuery) (values map[string]float64, stale bool, err error) {
+	key := cacheKey{namespace: namespace, query: q.Template}
+	if cfg.CacheTTL > 0 {
+		if values, ok := cache.get(key, cfg.CacheTTL); ok {
+			cacheHitsTotal.Inc()
+			return values, false, nil
+		}
+	}
+	values, err = fetchResources(ctx, cfg.PrometheusURL, namespace, q)
+	if ctx.Err() != nil {
+		return nil, false, err
+	}
+	if errors.Is(err, errThrottled) {
+		// Shedding a query: a recent enough cached result beats a 503
+		if values, ok := cache.get(key, cfg.ShedMaxStale); ok {
+			return values, true, nil
+		}
+		return nil, false, err
+	}
+	if err != nil {
+		if cfg.MaxStale > 0 {
+			if values, ok := cache.get(key, cfg.MaxStale); ok {
+				slog.Warn("Prometheus query failed, serving cached metrics", "namespace", namespace, "error", err)
+				return values, true, nil
+			}
+		}
+		return nil, false, err
+	}
+	if cfg.CacheTTL > 0 || cfg.MaxStale > 0 || cfg.PrometheusRPS > 0 {
+		cache.put(key, values)
+	}
+	return values, false, nil
+}
+
+// warmCache refreshes the entries of the hot namespaces every interval,
+// which is shorter than the TTL so those namespaces never see a cold cache.
+// It returns when ctx is done.
+func warmCache(ctx context.Context, namespaces []string, interval time.Duration) {
+	refresh := func() {
+		for _, ns := range namespaces {
+			for _, q := range placementQueries() {
+				values, err := fetchResources(ctx, cfg.PrometheusURL, ns, q)
+				if ctx.Err() != nil {
+					return
+				}
+				if err != nil {
+					slog.Warn("Cache warm-up failed", "namespace", ns, "error", err)
+					continue
+				}
+				cache.put(cacheKey{namespace: ns, query: q.Template}, values)
+			}
+		}
+	}
+
+	refresh()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			refresh()
+		}
+	}
+}
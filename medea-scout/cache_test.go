@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestCachedResourcesShedMaxStale(t *testing.T) {
+	q := promQuery{Name: "cpu", Template: `free_cpu{namespace="%s"} or free_cpu{namespace="%s"}`}
+	key := cacheKey{namespace: "batch-a", query: q.Template}
+	defer func() {
+		cfg, promLimiter = Config{}, nil
+		cache = resourceCache{entries: make(map[cacheKey]cacheEntry)}
+	}()
+
+	tests := []struct {
+		name      string
+		age       time.Duration // of the cached result, 0 for none
+		maxStale  time.Duration
+		wantStale bool
+	}{
+		{"recent result", 10 * time.Second, time.Minute, true},
+		{"result too old", 2 * time.Minute, time.Minute, false},
+		{"no cached result", 0, time.Minute, false},
+		{"cache answers disabled", 10 * time.Second, 0, false},
+	}
+	for _, tt := range tests {
+		cfg = Config{PrometheusRPS: 1, ShedMaxStale: tt.maxStale, ClusterLabel: "cluster"}
+		// An empty bucket that refills far too slowly sheds every query
+		promLimiter = &tokenBucket{rate: 1e-9, burst: 1, last: time.Now()}
+		cache = resourceCache{entries: make(map[cacheKey]cacheEntry)}
+		if tt.age > 0 {
+			cache.entries[key] = cacheEntry{values: map[string]float64{"c1": 4}, fetchedAt: time.Now().Add(-tt.age)}
+		}
+
+		values, stale, err := cachedResources(context.Background(), "batch-a", q)
+		if tt.wantStale {
+			if err != nil || !stale || values["c1"] != 4 {
+				t.Errorf("%s: got %v, stale %v, err %v, want the cached result flagged stale", tt.name, values, stale, err)
+			}
+		} else if !errors.Is(err, errThrottled) {
+			t.Errorf("%s: got %v, err %v, want errThrottled", tt.name, values, err)
+		}
+	}
+}
+
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := c.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestCachedResourcesTTL(t *testing.T) {
+	defer func() {
+		cfg = Config{}
+		cache = resourceCache{entries: make(map[cacheKey]cacheEntry)}
+	}()
+	var queries atomic.Int32
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries.Add(1)
+		w.Write([]byte(`{"status": "success", "data": {"result": [{"metric": {"cluster": "c1"}, "value": [0, "4"]}]}}`))
+	}))
+	defer prom.Close()
+	cfg = Config{PrometheusURL: prom.URL, ClusterLabel: "cluster", CacheTTL: time.Hour}
+	cache = resourceCache{entries: make(map[cacheKey]cacheEntry)}
+	q := promQuery{Name: "cpu", Template: `free_cpu{namespace="%s"} or free_cpu{namespace="%s"}`}
+
+	hits := counterValue(t, cacheHitsTotal)
+	for i := range 3 {
+		values, _, err := cachedResources(context.Background(), "batch-a", q)
+		if err != nil || values["c1"] != 4 {
+			t.Fatalf("call %d: got %v, %v", i, values, err)
+		}
+	}
+	if n := queries.Load(); n != 1 {
+		t.Errorf("Prometheus queried %d times within the TTL, want 1", n)
+	}
+	if got := counterValue(t, cacheHitsTotal) - hits; got != 2 {
+		t.Errorf("cache hits counted %v, want 2", got)
+	}
+
+	// Another namespace is another key
+	if _, _, err := cachedResources(context.Background(), "batch-b", q); err != nil {
+		t.Fatal(err)
+	}
+	// An expired entry is fetched again
+	cache.mu.Lock()
+	e := cache.entries[cacheKey{namespace: "batch-a", query: q.Template}]
+	e.fetchedAt = time.Now().Add(-2 * time.Hour)
+	cache.entries[cacheKey{namespace: "batch-a", query: q.Template}] = e
+	cache.mu.Unlock()
+	if _, _, err := cachedResources(context.Background(), "batch-a", q); err != nil {
+		t.Fatal(err)
+	}
+	if n := queries.Load(); n != 3 {
+		t.Errorf("Prometheus queried %d times, want 3 after another namespace and an expired entry", n)
+	}
+}
+
+func TestWarmCacheRefreshesBeforeExpiry(t *testing.T) {
+	defer func() {
+		cfg = Config{}
+		cache = resourceCache{entries: make(map[cacheKey]cacheEntry)}
+	}()
+	var queries atomic.Int32
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries.Add(1)
+		w.Write([]byte(`{"status": "success", "data": {"result": [{"metric": {"cluster": "c1"}, "value": [0, "4"]}]}}`))
+	}))
+	defer prom.Close()
+	cfg = Config{PrometheusURL: prom.URL, ClusterLabel: "cluster", MemoryUnit: memoryGB, CacheTTL: 200 * time.Millisecond}
+	cache = resourceCache{entries: make(map[cacheKey]cacheEntry)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		warmCache(ctx, []string{"hot"}, 50*time.Millisecond)
+	}()
+
+	// The first refresh runs right away
+	warm := func() bool {
+		for _, q := range placementQueries() {
+			if _, ok := cache.get(cacheKey{namespace: "hot", query: q.Template}, cfg.CacheTTL); !ok {
+				return false
+			}
+		}
+		return true
+	}
+	for start := time.Now(); !warm(); time.Sleep(5 * time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatal("the hot namespace was never warmed")
+		}
+	}
+	// Well past the TTL every lookup of the hot namespace is still a hit
+	deadline := time.Now().Add(500 * time.Millisecond)
+	for ; time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if !warm() {
+			t.Fatalf("the hot namespace missed the cache %v after warming", 500*time.Millisecond-time.Until(deadline))
+		}
+	}
+	cancel()
+	<-done
+
+	// Both queries on start and on each of the ticks
+	if n := queries.Load(); n < 2*5 {
+		t.Errorf("Prometheus queried %d times, want the hot namespace refreshed every interval", n)
+	}
+	// Other namespaces aren't warmed
+	if _, ok := cache.get(cacheKey{namespace: "cold", query: placementQueries()[0].Template}, cfg.CacheTTL); ok {
+		t.Error("a namespace that isn't hot was cached")
+	}
+}
+
+func TestStaleMetricsDuringOutage(t *testing.T) {
+	defer func() {
+		cfg = Config{}
+		cache = resourceCache{entries: make(map[cacheKey]cacheEntry)}
+	}()
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusBadGateway)
+	}))
+	defer prom.Close()
+
+	tests := []struct {
+		name       string
+		age        time.Duration // of the cached metrics, 0 for none
+		maxStale   time.Duration
+		wantStatus int
+	}{
+		{"recent metrics", 30 * time.Second, time.Minute, http.StatusOK},
+		{"metrics too old", 2 * time.Minute, time.Minute, http.StatusInternalServerError},
+		{"no cached metrics", 0, time.Minute, http.StatusInternalServerError},
+		{"stale serving disabled", 30 * time.Second, 0, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		cfg = Config{
+			PrometheusURL: prom.URL, NamespacePattern: regexp.MustCompile(`^[a-z0-9-]+$`), ClusterLabel: "cluster", MemoryUnit: memoryGB,
+			MaxStale: tt.maxStale, Strategy: strategyRandom, TieBreaker: tieName,
+		}
+		cache = resourceCache{entries: make(map[cacheKey]cacheEntry)}
+		if tt.age > 0 {
+			queries := placementQueries()
+			for i, free := range []map[string]float64{{"east": 16}, {"east": 64}} {
+				cache.entries[cacheKey{namespace: "batch-a", query: queries[i].Template}] = cacheEntry{values: free, fetchedAt: time.Now().Add(-tt.age)}
+			}
+		}
+		body, _ := json.Marshal(RequestPayload{Namespace: "batch-a", CPU: 4, RAM: 8})
+		w := httptest.NewRecorder()
+		handleRequest(w, httptest.NewRequest(http.MethodPost, "/api/request", bytes.NewReader(body)))
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: answered %d, want %d", tt.name, w.Code, tt.wantStatus)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		// Placements on cached metrics say so, in the body and in a header
+		var got ResponsePayload
+		if err := json.NewDecoder(w.Body).Decode(&got); err != nil || got.Cluster != "east" || !got.Stale {
+			t.Errorf("%s: answered %+v, %v, want east flagged stale", tt.name, got, err)
+		}
+		if w.Header().Get("X-Medea-Stale") != "true" {
+			t.Errorf("%s: no X-Medea-Stale header", tt.name)
+		}
+	}
+}
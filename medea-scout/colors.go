@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sync"
+	"syscall"
+)
+
+// Colors of the clusters in a blue/green pair
+const (
+	colorBlue  = "blue"
+	colorGreen = "green"
+)
+
+// activeColors holds the active color per namespace pattern. It starts from
+// MEDEA_SCOUT_ACTIVE_COLORS and is changed at runtime through the admin endpoint. Changes are
+// kept in MEDEA_SCOUT_COLORS_FILE, shared by all replicas: each re-reads it when it changed, so
+// a flip sent to one replica reaches them all. Without the file the colors can't be changed.
+type activeColors struct {
+	mu       sync.Mutex
+	patterns []KeyValue
+	file     string
+	// read is the file as last read, every write replaces it with a new one
+	read fs.FileInfo
+}
+
+// Active colors of this scout instance, set up in main
+var colors activeColors
+
+// errColorsFixed refuses changes when no colors file is configured
+var errColorsFixed = errors.New("active colors can only be changed with MEDEA_SCOUT_COLORS_FILE set")
+
+// refresh re-reads the colors file when it changed since the last read. A missing file keeps
+// the current colors, a broken one is logged and ignored. a.mu must be held.
+func (a *activeColors) refresh() {
+	if a.file == "" {
+		return
+	}
+	info, err := os.Stat(a.file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		slog.Warn("Reading the colors file failed, keeping the current colors", "file", a.file, "error", err)
+		return
+	}
+	if a.read != nil && os.SameFile(info, a.read) && info.ModTime().Equal(a.read.ModTime()) {
+		return
+	}
+	patterns, err := readColorsFile(a.file)
+	if err != nil {
+		slog.Warn("Reading the colors file failed, keeping the current colors", "file", a.file, "error", err)
+		return
+	}
+	a.patterns, a.read = patterns, info
+}
+
+// readColorsFile reads the active colors as written by writeColorsFile
+func readColorsFile(file string) ([]KeyValue, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var changes []ColorChange
+	if err := json.Unmarshal(data, &changes); err != nil {
+		return nil, err
+	}
+	patterns := make([]KeyValue, 0, len(changes))
+	for _, c := range changes {
+		if c.Namespace == "" || c.Color == "" {
+			return nil, errors.New("every entry needs a namespace and a color")
+		}
+		if err := validColorChange(c); err != nil {
+			return nil, fmt.Errorf("%s: %w", c.Namespace, err)
+		}
+		patterns = append(patterns, KeyValue{Key: c.Namespace, Value: c.Color})
+	}
+	return patterns, nil
+}
+
+// writeColorsFile replaces the colors file at once, so readers never see half of it
+func writeColorsFile(file string, patterns []KeyValue) error {
+	changes := make([]ColorChange, 0, len(patterns))
+	for _, kv := range patterns {
+		changes = append(changes, ColorChange{Namespace: kv.Key, Color: kv.Value})
+	}
+	data, err := json.MarshalIndent(changes, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// colorOf returns the active color of the first pattern matching ns, "" if none matches
+func (a *activeColors) colorOf(ns string) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.refresh()
+	for _, kv := range a.patterns {
+		if ok, _ := path.Match(kv.Key, ns); ok {
+			return kv.Value
+		}
+	}
+	return ""
+}
+
+// set makes color active for pattern, flipping it when color is empty, and returns the new color.
+// Unknown patterns are added after the configured ones. The change is made on the latest
+// colors file while holding a lock next to it, so concurrent flips on other replicas are
+// neither lost nor applied twice.
+func (a *activeColors) set(pattern, color string) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file == "" {
+		return "", errColorsFixed
+	}
+	lock, err := os.OpenFile(a.file+".lock", os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return "", err
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return "", err
+	}
+	// Another replica may have changed the file a moment ago
+	a.read = nil
+	a.refresh()
+
+	patterns := append([]KeyValue(nil), a.patterns...)
+	i := slices.IndexFunc(patterns, func(kv KeyValue) bool { return kv.Key == pattern })
+	switch {
+	case i >= 0 && color == "":
+		color = otherColor(patterns[i].Value)
+	case color == "":
+		color = colorGreen
+	}
+	if i >= 0 {
+		patterns[i].Value = color
+	} else {
+		patterns = append(patterns, KeyValue{Key: pattern, Value: color})
+	}
+	if err := writeColorsFile(a.file, patterns); err != nil {
+		return "", err
+	}
+	a.patterns = patterns
+	a.read, _ = os.Stat(a.file)
+	return color, nil
+}
+
+// list returns the active color of every pattern in matching order
+func (a *activeColors) list() []ColorChange {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.refresh()
+	out := make([]ColorChange, 0, len(a.patterns))
+	for _, kv := range a.patterns {
+		out = append(out, ColorChange{Namespace: kv.Key, Color: kv.Value})
+	}
+	return out
+}
+
+func otherColor(color string) string {
+	if color == colorBlue {
+		return colorGreen
+	}
+	return colorBlue
+}
+
+// inactiveCluster reports whether cluster belongs to a blue/green pair and is not of the color
+// active for ns. Clusters outside MEDEA_SCOUT_CLUSTER_PAIRS and namespaces without an active
+// color are not restricted.
+func inactiveCluster(cluster, ns string) bool {
+	if len(cfg.ClusterPairs) == 0 {
+		return false
+	}
+	active := colors.colorOf(ns)
+	if active == "" {
+		return false
+	}
+	for _, pair := range cfg.ClusterPairs {
+		switch cluster {
+		case pair.Key:
+			return active != colorBlue
+		case pair.Value:
+			return active != colorGreen
+		}
+	}
+	return false
+}
+
+// ColorChange is the body of a flip, an empty color switches to the other one.
+// The listing uses it for the current colors too.
+type ColorChange struct {
+	Namespace string `json:"namespace"`
+	Color     string `json:"color,omitempty"`
+}
+
+// validColorChange checks the pattern and color of a change, an empty color flips
+func validColorChange(c ColorChange) error {
+	if _, err := path.Match(c.Namespace, ""); err != nil {
+		return errors.New("Invalid namespace pattern")
+	}
+	if c.Color != "" && c.Color != colorBlue && c.Color != colorGreen {
+		return errors.New("Color must be blue or green")
+	}
+	return nil
+}
+
+// handleColors lists the active color per namespace pattern (GET) or changes one (POST)
+func handleColors(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		var change ColorChange
+		if err := json.NewDecoder(r.Body).Decode(&change); err != nil || change.Namespace == "" {
+			http.Error(w, "Expected {\"namespace\": \"<pattern>\", \"color\": \"blue|green\"}", http.StatusBadRequest)
+			return
+		}
+		if err := validColorChange(change); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		color, err := colors.set(change.Namespace, change.Color)
+		if errors.Is(err, errColorsFixed) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			slog.Error("Saving the active colors failed", "file", colors.file, "error", err)
+			http.Error(w, "Saving the active colors failed", http.StatusInternalServerError)
+			return
+		}
+		change.Color = color
+		slog.Info("Active color changed", "namespace", change.Namespace, "color", change.Color)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(colors.list())
+}
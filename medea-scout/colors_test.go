@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestColorsSharedBetweenReplicas(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "colors.json")
+	initial := []KeyValue{{Key: "team-a-*", Value: colorBlue}}
+	a := &activeColors{patterns: append([]KeyValue(nil), initial...), file: file}
+	b := &activeColors{patterns: append([]KeyValue(nil), initial...), file: file}
+
+	if got, err := a.set("team-a-*", ""); err != nil || got != colorGreen {
+		t.Fatalf("flip on one replica = %q, %v, want green", got, err)
+	}
+	if got := b.colorOf("team-a-x"); got != colorGreen {
+		t.Errorf("other replica sees %q after the flip, want green", got)
+	}
+	if _, err := b.set("etl", colorBlue); err != nil {
+		t.Fatal(err)
+	}
+	if got := a.colorOf("etl"); got != colorBlue {
+		t.Errorf("new pattern set on the other replica reads %q, want blue", got)
+	}
+
+	// Concurrent flips on both replicas all count, an even number lands on the same color
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := a
+			if i%2 == 1 {
+				r = b
+			}
+			if _, err := r.set("team-a-*", ""); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := a.colorOf("team-a-x"); got != colorGreen {
+		t.Errorf("after 10 concurrent flips the color is %q, want green", got)
+	}
+
+	// A restarted replica picks the file up over its initial colors
+	restarted := &activeColors{patterns: append([]KeyValue(nil), initial...), file: file}
+	if got := restarted.colorOf("team-a-x"); got != colorGreen {
+		t.Errorf("restarted replica reads %q, want green from the file", got)
+	}
+}
+
+func TestColorFlipNeedsFile(t *testing.T) {
+	defer func() { colors = activeColors{} }()
+	colors = activeColors{patterns: []KeyValue{{Key: "team-a-*", Value: colorBlue}}}
+
+	w := httptest.NewRecorder()
+	handleColors(w, httptest.NewRequest(http.MethodPost, "/api/v1/colors", strings.NewReader(`{"namespace": "team-a-*"}`)))
+	if w.Code != http.StatusConflict {
+		t.Errorf("flip without a colors file answered %d, want 409", w.Code)
+	}
+	if got := colors.colorOf("team-a-x"); got != colorBlue {
+		t.Errorf("refused flip changed the color to %q", got)
+	}
+}
+
+func TestPlacementFollowsActiveColor(t *testing.T) {
+	defer func() { cfg, colors = Config{}, activeColors{} }()
+	cfg = Config{ClusterPairs: []KeyValue{{Key: "blue-1", Value: "green-1"}}}
+	colors = activeColors{patterns: []KeyValue{{Key: "team-a-*", Value: colorBlue}}, file: filepath.Join(t.TempDir(), "colors.json")}
+
+	tests := []struct {
+		cluster, ns string
+		want        bool
+	}{
+		{"blue-1", "team-a-x", false},
+		{"green-1", "team-a-x", true},
+		{"green-1", "other", false},
+		{"unpaired", "team-a-x", false},
+	}
+	check := func(when string) {
+		for _, tt := range tests {
+			if got := inactiveCluster(tt.cluster, tt.ns); got != tt.want {
+				t.Errorf("%s: inactiveCluster(%s, %s) = %v, want %v", when, tt.cluster, tt.ns, got, tt.want)
+			}
+		}
+	}
+	check("blue active")
+
+	w := httptest.NewRecorder()
+	handleColors(w, httptest.NewRequest(http.MethodPost, "/api/v1/colors", strings.NewReader(`{"namespace": "team-a-*"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("flip answered %d: %s", w.Code, w.Body)
+	}
+	for i := range tests {
+		if tests[i].cluster != "unpaired" && tests[i].ns != "other" {
+			tests[i].want = !tests[i].want
+		}
+	}
+	check("after the flip")
+}
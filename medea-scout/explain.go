@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// explainLog receives one structured record per placement with the full reasoning.
+// It is the default logger, or nil when MEDEA_SCOUT_EXPLAIN_LEVEL is unset.
+var explainLog *slog.Logger
+
+// candidateInfo is one cluster considered for a placement
+type candidateInfo struct {
+	Cluster string  `json:"cluster"`
+	FreeCPU float64 `json:"freeCpu"`
+	FreeRAM float64 `json:"freeRam"`
+	Fits    bool    `json:"fits"`
+}
+
+// explainEnabled reports whether placements are logged, so the hot path
+// only collects candidates when the record would actually be written
+func explainEnabled() bool {
+	return explainLog != nil && explainLog.Enabled(context.Background(), cfg.ExplainLevel)
+}
+
+// logPlacement writes the placement explanation; selected is empty when nothing fit
+func logPlacement(ctx context.Context, req RequestPayload, candidates []candidateInfo, reason NoFitReason, strategy, selected string, stale bool) {
+	explainLog.Log(ctx, cfg.ExplainLevel, "placement",
+		slog.String("namespace", req.Namespace),
+		slog.Float64("cpu", req.CPU),
+		slog.Float64("ram", req.RAM),
+		slog.Any("placement", req.Placement),
+		slog.Any("candidates", candidates),
+		slog.Int("excluded", reason.Excluded),
+		slog.String("strategy", strategy),
+		slog.String("selected", selected),
+		slog.Bool("stale", stale),
+	)
+}
+
+// envLevel reads a slog level (debug, info, warn, error) from env
+func envLevel(key string, def slog.Level) slog.Level {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.ToUpper(v))); err != nil {
+		fatalf("Invalid %s: %v", key, err)
+	}
+	return level
+}
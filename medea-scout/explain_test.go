@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"log/slog"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPlacementExplained(t *testing.T) {
+	defer func() {
+		cfg, explainLog = Config{}, nil
+		cache = resourceCache{entries: make(map[cacheKey]cacheEntry)}
+	}()
+	cpus := map[string]float64{"east": 16, "west": 2}
+	mems := map[string]float64{"east": 64, "west": 64}
+
+	tests := []struct {
+		name         string
+		explainLevel slog.Level
+		cpu          float64
+		wantLogged   bool
+		wantSelected string
+	}{
+		{"placed", slog.LevelInfo, 4, true, "east"},
+		{"nothing fits", slog.LevelInfo, 32, true, ""},
+		{"below the logger's level", slog.LevelDebug, 4, false, ""},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		explainLog = slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+		cfg = Config{
+			NamespacePattern: regexp.MustCompile(`^[a-z0-9-]+$`), ClusterLabel: "cluster", MemoryUnit: memoryGB,
+			CacheTTL: time.Hour, Strategy: strategyMostCPU, TieBreaker: tieName, ExplainLevel: tt.explainLevel,
+		}
+		placeWith(t, cpus, mems, RequestPayload{Namespace: "batch-a", CPU: tt.cpu, RAM: 8})
+		if !tt.wantLogged {
+			if buf.Len() != 0 {
+				t.Errorf("%s: logged %s", tt.name, buf.String())
+			}
+			continue
+		}
+
+		var rec struct {
+			Msg        string          `json:"msg"`
+			Namespace  string          `json:"namespace"`
+			Candidates []candidateInfo `json:"candidates"`
+			Strategy   string          `json:"strategy"`
+			Selected   string          `json:"selected"`
+		}
+		if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+			t.Fatalf("%s: %v in %q", tt.name, err, buf.String())
+		}
+		// Every cluster considered, with its free capacity, whether it was picked or not
+		slices.SortFunc(rec.Candidates, func(a, b candidateInfo) int { return cmp.Compare(a.Cluster, b.Cluster) })
+		want := []candidateInfo{
+			{Cluster: "east", FreeCPU: 16, FreeRAM: 64, Fits: tt.cpu <= 16},
+			{Cluster: "west", FreeCPU: 2, FreeRAM: 64, Fits: false},
+		}
+		if rec.Msg != "placement" || rec.Namespace != "batch-a" || !slices.Equal(rec.Candidates, want) {
+			t.Errorf("%s: logged %+v, want candidates %+v", tt.name, rec, want)
+		}
+		if rec.Selected != tt.wantSelected {
+			t.Errorf("%s: logged selection %q, want %q", tt.name, rec.Selected, tt.wantSelected)
+		}
+		// The strategy is logged with the tie-breaker that settled equal scores
+		if tt.wantSelected != "" && rec.Strategy != strategyMostCPU+"/"+tieName {
+			t.Errorf("%s: logged strategy %q", tt.name, rec.Strategy)
+		}
+	}
+}
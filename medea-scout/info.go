@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"medea/internal/registry"
+)
+
+// scoutInfo is the effective configuration that decides placements, as served by GET /info.
+// Secrets (the admin token, Prometheus credentials) are never part of it.
+type scoutInfo struct {
+	PrometheusURL string `json:"prometheusUrl"`
+	ClusterLabel  string `json:"clusterLabel"`
+	OwnerLabel    string `json:"ownerLabel,omitempty"`
+	MemoryUnit    string `json:"memoryUnit"`
+
+	Strategy        string `json:"strategy"`
+	TieBreaker      string `json:"tieBreaker"`
+	PlacementWindow string `json:"placementWindow,omitempty"`
+	SeedEpoch       string `json:"seedEpoch,omitempty"`
+	SeedRotation    string `json:"seedRotation,omitempty"`
+
+	ReservationTTL string  `json:"reservationTtl"`
+	FailurePenalty float64 `json:"failurePenalty"`
+	PenaltyWindow  string  `json:"penaltyWindow,omitempty"`
+	CanaryCluster  string  `json:"canaryCluster,omitempty"`
+	CanaryPercent  float64 `json:"canaryPercent,omitempty"`
+
+	SoftDimensions []string `json:"softDimensions,omitempty"`
+	Overcommit     float64  `json:"overcommit,omitempty"`
+
+	NamespacePattern string             `json:"namespacePattern"`
+	NamespaceOwners  []string           `json:"namespaceOwners,omitempty"`
+	ClusterClasses   []string           `json:"clusterClasses,omitempty"`
+	ClusterPairs     []string           `json:"clusterPairs,omitempty"`
+	ExcludeClusters  []string           `json:"excludeClusters,omitempty"`
+	ClusterWeights   map[string]float64 `json:"clusterWeights,omitempty"`
+	Registry         []registry.Cluster `json:"registry,omitempty"`
+	RegistryMode     string             `json:"registryMode,omitempty"`
+
+	Queries      map[string]infoQuery `json:"queries"`
+	CacheTTL     string               `json:"cacheTtl"`
+	MaxStale     string               `json:"maxStale"`
+	ShedMaxStale string               `json:"shedMaxStale,omitempty"`
+
+	// ConfigHash digests everything above, replicas with the same hash place alike
+	ConfigHash string `json:"configHash"`
+}
+
+type infoQuery struct {
+	Template string `json:"template"`
+	Fallback string `json:"fallback,omitempty"`
+}
+
+// currentInfo collects the effective configuration of this replica
+func currentInfo() scoutInfo {
+	info := scoutInfo{
+		PrometheusURL: redactURL(cfg.PrometheusURL),
+		ClusterLabel:  cfg.ClusterLabel,
+		OwnerLabel:    cfg.OwnerLabel,
+		MemoryUnit:    cfg.MemoryUnit,
+
+		Strategy:   cfg.Strategy,
+		TieBreaker: cfg.TieBreaker,
+		SeedEpoch:  cfg.SeedEpoch,
+
+		ReservationTTL: cfg.ReservationTTL.String(),
+		FailurePenalty: cfg.FailurePenalty,
+		CanaryCluster:  cfg.CanaryCluster,
+
+		NamespacePattern: cfg.NamespacePattern.String(),
+		NamespaceOwners:  formatKeyValues(cfg.NamespaceOwners),
+		ClusterClasses:   formatKeyValues(cfg.ClusterClasses),
+		ClusterPairs:     formatKeyValues(cfg.ClusterPairs),
+		ExcludeClusters:  cfg.ExcludeClusters,
+		ClusterWeights:   cfg.ClusterWeights,
+		Registry:         cfg.Registry.Clusters(),
+
+		Queries:  make(map[string]infoQuery),
+		CacheTTL: cfg.CacheTTL.String(),
+		MaxStale: cfg.MaxStale.String(),
+	}
+	// Settings that only matter with another one set are left out otherwise
+	switch cfg.TieBreaker {
+	case tiePlacements:
+		info.PlacementWindow = cfg.PlacementWindow.String()
+	case tieNamespace:
+		info.SeedRotation = cfg.SeedRotation.String()
+	}
+	if cfg.FailurePenalty > 0 {
+		info.PenaltyWindow = cfg.PenaltyWindow.String()
+	}
+	if cfg.CanaryCluster != "" {
+		info.CanaryPercent = cfg.CanaryPercent
+	}
+	if cfg.PrometheusRPS > 0 {
+		info.ShedMaxStale = cfg.ShedMaxStale.String()
+	}
+	if cfg.Registry.Enabled() {
+		info.RegistryMode = cfg.Registry.Mode
+	}
+	if cfg.SoftCPU {
+		info.SoftDimensions = append(info.SoftDimensions, "cpu")
+	}
+	if cfg.SoftRAM {
+		info.SoftDimensions = append(info.SoftDimensions, "ram")
+	}
+	if len(info.SoftDimensions) > 0 {
+		info.Overcommit = cfg.Overcommit
+	}
+	for _, q := range placementQueries() {
+		info.Queries[q.Name] = infoQuery{Template: q.Template, Fallback: q.Fallback}
+	}
+
+	body, _ := json.Marshal(info)
+	sum := sha256.Sum256(body)
+	info.ConfigHash = hex.EncodeToString(sum[:8])
+	return info
+}
+
+// redactURL hides the password of a URL, and the username too since it may be a token
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "<unparsable>"
+	}
+	if u.User != nil {
+		u.User = url.User("redacted")
+	}
+	q := u.Query()
+	for key := range q {
+		q.Set(key, "redacted")
+	}
+	if len(q) > 0 {
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
+
+func formatKeyValues(pairs []KeyValue) []string {
+	items := make([]string, 0, len(pairs))
+	for _, kv := range pairs {
+		items = append(items, kv.Key+"="+kv.Value)
+	}
+	return items
+}
+
+// handleInfo answers with the effective placement configuration, so replicas can be compared
+func handleInfo(w http.ResponseWriter, r *http.Request) {
+	if !cfg.Info {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentInfo())
+}
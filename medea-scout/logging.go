@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// setupLogging installs the default slog logger: JSON lines for the log pipeline, or text with
+// LOG_FORMAT=text for local runs, dropping records below LOG_LEVEL (debug, info, warn, error).
+// MEDEA_SCOUT_LOG_LEVEL, which used to set the level of the placement log only, is still read
+// when LOG_LEVEL is unset.
+func setupLogging() {
+	level := envLevel("LOG_LEVEL", envLevel("MEDEA_SCOUT_LOG_LEVEL", slog.LevelInfo))
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	switch format := strings.ToLower(os.Getenv("LOG_FORMAT")); format {
+	case "", "json":
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid LOG_FORMAT %q: expected json or text\n", format)
+		os.Exit(1)
+	}
+	slog.SetDefault(slog.New(requestIDHandler{handler}))
+}
+
+// requestIDKey holds the balancer's X-Request-Id in a request context
+type requestIDKey struct{}
+
+// withRequestID stores the X-Request-Id of r, set by the balancer, in its context so the
+// records logged for it carry the request_id
+func withRequestID(r *http.Request) *http.Request {
+	id := r.Header.Get("X-Request-Id")
+	if id == "" {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+}
+
+// requestIDHandler adds the request_id of the request a record was logged for
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, rec slog.Record) error {
+	if id, _ := ctx.Value(requestIDKey{}).(string); id != "" {
+		rec.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, rec)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
+
+// fatal logs an error with its attributes and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// fatalf logs a formatted error and exits, for config errors that are plain messages
+func fatalf(format string, args ...any) {
+	fatal(fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestIDLogged(t *testing.T) {
+	var logs bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(requestIDHandler{slog.NewJSONHandler(&logs, nil)}))
+	defer func() {
+		slog.SetDefault(prev)
+		cfg = Config{}
+		cache = resourceCache{entries: make(map[cacheKey]cacheEntry)}
+		reservations = reservationStore{byCluster: make(map[string][]Reservation)}
+	}()
+	cfg = Config{
+		NamespacePattern: regexp.MustCompile(`^[a-z0-9-]+$`), ClusterLabel: "cluster", MemoryUnit: memoryGB,
+		CacheTTL: time.Hour, Strategy: strategyRandom, TieBreaker: tieName, ReservationTTL: time.Minute,
+	}
+	queries := placementQueries()
+	for _, q := range queries {
+		cache.entries[cacheKey{namespace: "batch-a", query: q.Template}] = cacheEntry{values: map[string]float64{"east": 8}, fetchedAt: time.Now()}
+	}
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		body    string
+		msg     string
+	}{
+		{"placement", handleRequest, `{"namespace": "batch-a", "cpu": 1, "ram": 1}`, "Placement requested"},
+		{"feedback", handleFeedback, `{"cluster": "east", "namespace": "batch-a", "outcome": "failure"}`, "Released the reservation after a failed submit"},
+	}
+	for _, tt := range tests {
+		logs.Reset()
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+		r.Header.Set("X-Request-Id", "req-"+tt.name)
+		w := httptest.NewRecorder()
+		tt.handler(w, r)
+		if w.Code >= 300 {
+			t.Fatalf("%s: answered %d %q", tt.name, w.Code, w.Body.String())
+		}
+		found := false
+		for sc := bufio.NewScanner(&logs); sc.Scan(); {
+			var rec map[string]any
+			if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+				t.Fatal(err)
+			}
+			if rec["msg"] == tt.msg {
+				found = rec["level"] == "INFO" && rec["request_id"] == "req-"+tt.name
+			}
+		}
+		if !found {
+			t.Errorf("%s: no %q line at info with the request_id, logged:\n%s", tt.name, tt.msg, logs.String())
+		}
+	}
+}
@@ -1,26 +1,257 @@
 package main
 
 import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"math"
 	"math/rand"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
+
+	"medea/internal/placement"
+	"medea/internal/registry"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/sync/errgroup"
 )
 
+// Config stores application configuration from Environment Variables
+type Config struct {
+	PrometheusURL string
+	Port          string
+	TLSCert       string
+	TLSKey        string
+	MTLSCA        string
+	// ClusterLabel is the Prometheus label that identifies a cluster
+	ClusterLabel string
+	// ShutdownTimeout is how long in-flight requests may run after SIGINT/SIGTERM
+	ShutdownTimeout time.Duration
+
+	// OwnerLabel is an optional Prometheus label naming the team that owns a cluster,
+	// NamespaceOwners maps namespace patterns to the owner whose clusters they may use
+	OwnerLabel      string
+	NamespaceOwners []KeyValue
+
+	// ClusterClasses maps cluster names to the class a placement block may ask for
+	ClusterClasses []KeyValue
+
+	// ExcludeClusters are never selected, e.g. while drained for maintenance
+	ExcludeClusters []string
+	// Registry is the canonical cluster list, nil without MEDEA_CLUSTER_REGISTRY
+	Registry *registry.Registry
+	// ClusterWeights bias the random tie-breaker towards preferred clusters, unlisted ones weigh 1
+	ClusterWeights map[string]float64
+
+	// ClusterPairs are blue=green cluster pairs, ActiveColors the initial color per namespace pattern
+	ClusterPairs []KeyValue
+	ActiveColors []KeyValue
+	// ColorsFile keeps the colors changed at runtime, shared by all replicas
+	ColorsFile string
+
+	// TLS settings for talking to Prometheus
+	PrometheusCA       string
+	PrometheusInsecure bool
+
+	// Distinct status codes per no-fit cause instead of a plain 404
+	DetailedStatus bool
+	// RetryAfter is sent with no-fit answers, 0 omits the header
+	RetryAfter time.Duration
+
+	// CacheTTL enables caching of Prometheus results; HotNamespaces are refreshed every WarmInterval
+	CacheTTL      time.Duration
+	HotNamespaces []string
+	WarmInterval  time.Duration
+	// NamespacePattern is what a requested namespace must match before it is put into PromQL
+	NamespacePattern *regexp.Regexp
+
+	// ExplainLevel is the level of the per-placement explanation, LOG_LEVEL decides whether it is written
+	ExplainLevel slog.Level
+
+	// Strategy ranks the suitable clusters: random (all equal), most-cpu, most-mem or most-free
+	Strategy string
+	// TieBreaker decides between equally good clusters: random, name, placements within PlacementWindow or namespace
+	TieBreaker      string
+	PlacementWindow time.Duration
+	// SeedEpoch and SeedRotation seed the namespace tie-breaker, changing either reshuffles namespaces
+	SeedEpoch    string
+	SeedRotation time.Duration
+
+	// ReservationTTL is how long a placement holds its capacity (0 disables reservations)
+	ReservationTTL time.Duration
+	// FailurePenalty is the share of free capacity a reported submit failure takes from its cluster,
+	// decaying over PenaltyWindow (0 disables penalties)
+	FailurePenalty float64
+	PenaltyWindow  time.Duration
+	// AdminToken guards the admin endpoints, empty disables them
+	AdminToken string
+
+	// PrometheusRPS caps outbound queries (0 disables), a query waits at most PrometheusQueryWait for a token.
+	// A shed query is answered from cached results up to ShedMaxStale old.
+	PrometheusRPS       float64
+	PrometheusBurst     int
+	PrometheusQueryWait time.Duration
+	ShedMaxStale        time.Duration
+
+	// Export the free capacity of candidate clusters as gauges, for at most MetricsMaxNamespaces namespaces
+	CapacityMetrics      bool
+	MetricsMaxNamespaces int
+
+	// TokenTTL is how long a placement token keeps resolving to its cluster
+	TokenTTL time.Duration
+
+	// CanaryCluster only gets CanaryPercent of the placements it is suitable for
+	CanaryCluster string
+	CanaryPercent float64
+
+	// Unit of the RAM query results and of all memory amounts in answers: GB (default) or MiB
+	MemoryUnit string
+
+	// Per-dimension PromQL for free CPU and RAM (in MemoryUnit), empty uses the built-in queries
+	CPUQuery string
+	RAMQuery string
+
+	// Per-dimension PromQL used when the main query fails or returns nothing
+	CPUFallbackQuery string
+	RAMFallbackQuery string
+
+	// SoftCPU and SoftRAM mark dimensions that may be overcommitted by Overcommit, a share of the
+	// request; hard dimensions must fit the free capacity
+	SoftCPU    bool
+	SoftRAM    bool
+	Overcommit float64
+
+	// PrometheusTimeout bounds each Prometheus call including reading the answer, 0 waits forever
+	PrometheusTimeout time.Duration
+
+	// MaxStale is how old cached results may be when served during a Prometheus outage, 0 disables it
+	MaxStale time.Duration
+
+	// Serve the effective configuration at GET /info (MEDEA_SCOUT_INFO)
+	Info bool
+
+	// SimulateMaxRequests caps the requests of one POST /api/simulate, 0 disables simulations
+	SimulateMaxRequests int
+}
+
+// Global configuration, loaded once in main
+var cfg Config
+
+// HTTP client shared by all Prometheus queries
+var promClient = http.DefaultClient
+
+// Clusters observed in Prometheus results
+var seen seenClusters
+
+// Owner of each cluster, read from OwnerLabel
+var owners = struct {
+	sync.Mutex
+	byCluster map[string]string
+}{byCluster: make(map[string]string)}
+
+// KeyValue is one "key=value" item of a list from env
+type KeyValue struct {
+	Key   string
+	Value string
+}
+
 // RequestPayload describes the incoming JSON
 type RequestPayload struct {
-	Namespace    string  `json:"namespace"`
-	CPU          float64 `json:"cpu"`
-	RAM          float64 `json:"ram"`
+	Namespace string  `json:"namespace"`
+	CPU       float64 `json:"cpu"`
+	RAM       float64 `json:"ram"`
+	// Clusters optionally restricts selection to the listed clusters
+	Clusters []string `json:"clusters,omitempty"`
+	// ExcludeClusters are never selected, on top of SCOUT_EXCLUDE_CLUSTERS
+	ExcludeClusters []string `json:"excludeClusters,omitempty"`
+	// Verbose adds the per-cluster free capacity to "not found" answers
+	Verbose bool `json:"verbose,omitempty"`
+	// PreferredCluster is returned when it is suitable, otherwise selection is unchanged
+	PreferredCluster string `json:"preferredCluster,omitempty"`
+	// DryRun asks for a placement without reserving capacity
+	DryRun bool `json:"dryRun,omitempty"`
+	// PlacementToken co-locates requests sharing it on one cluster while it is suitable
+	PlacementToken string `json:"placementToken,omitempty"`
+	// Placement holds the client's placement constraints, forwarded by the balancer
+	Placement *placement.Constraints `json:"placement,omitempty"`
+	// RAMUnit is the unit of RAM and MinFreeRAM: GB (default) or MiB
+	RAMUnit string `json:"ramUnit,omitempty"`
+}
+
+// placementAllows reports whether the constraints permit cluster at all, regardless of capacity.
+// High-priority requests never get the canary cluster, even when it is the only one that fits.
+func placementAllows(p *placement.Constraints, cluster string) bool {
+	if p == nil {
+		return true
+	}
+	if slices.Contains(p.Exclude, cluster) || p.High() && cfg.CanaryCluster != "" && cluster == cfg.CanaryCluster {
+		return false
+	}
+	return p.ClusterClass == "" || clusterClass(cluster) == p.ClusterClass
+}
+
+// clusterClass returns the class of a cluster in MEDEA_SCOUT_CLUSTER_CLASSES, else its class in
+// the registry, "" if it has none
+func clusterClass(cluster string) string {
+	for _, kv := range cfg.ClusterClasses {
+		if kv.Key == cluster {
+			return kv.Value
+		}
+	}
+	c, _ := cfg.Registry.Lookup(cluster)
+	return c.Class
 }
 
 // ResponsePayload describes the outgoing JSON
 type ResponsePayload struct {
 	Cluster string `json:"cluster"`
+	// Stale is set when the placement used cached metrics because Prometheus was unreachable
+	Stale bool `json:"stale,omitempty"`
+	// FreeCPU and FreeMem are left on the cluster after subtracting the request, memory in the
+	// request's RAMUnit, which is named again in RAMUnit
+	FreeCPU *float64 `json:"freeCpu,omitempty"`
+	FreeMem *float64 `json:"freeMem,omitempty"`
+	RAMUnit string   `json:"ramUnit,omitempty"`
+}
+
+// Capacity is the free CPU and RAM of a cluster
+type Capacity struct {
+	CPU float64 `json:"cpu"`
+	RAM float64 `json:"ram"`
+}
+
+// NoFitReason breaks down why no cluster was suitable
+type NoFitReason struct {
+	ClustersSeen    int `json:"clustersSeen"`
+	InsufficientCPU int `json:"insufficientCpu"`
+	InsufficientRAM int `json:"insufficientRam"`
+	InsufficientAll int `json:"insufficientBoth"`
+	// Excluded counts clusters reported by Prometheus but filtered out by the request or owner
+	Excluded int `json:"excluded"`
+}
+
+// NotFoundPayload is returned when no cluster fits; Free is only filled in verbose mode
+type NotFoundPayload struct {
+	Error  string              `json:"error"`
+	Reason NoFitReason         `json:"reason"`
+	Free   map[string]Capacity `json:"free,omitempty"`
 }
 
 // PrometheusResponse for deserializing the response from Prometheus
@@ -28,29 +259,71 @@ type PrometheusResponse struct {
 	Status string `json:"status"`
 	Data   struct {
 		Result []struct {
-			Metric struct {
-				Cluster string `json:"cluster"`
-			} `metric`
-			Value []interface{} `json:"value"`
+			Metric map[string]string `json:"metric"`
+			Value  []interface{}     `json:"value"`
 		} `json:"result"`
 	} `json:"data"`
 }
 
-// fetchResources makes a request to Prometheus and returns a map of [cluster]value
-func fetchResources(pURL, namespace, queryTemplate string) (map[string]float64, error) {
+// fetchResources makes a request to Prometheus and returns a map of [cluster]value.
+// Failures are recorded per query for /api/v1/query-errors and the metrics, except those
+// caused by ctx ending, e.g. when the client went away.
+func fetchResources(ctx context.Context, pURL, namespace string, q promQuery) (map[string]float64, error) {
+	results, err := queryPrometheus(ctx, pURL, namespace, q.Template)
+	if err != nil && !errors.Is(err, errThrottled) && ctx.Err() == nil {
+		queryErrors.record(q.Name, err)
+	}
+	// A gap in a recording rule shows up as an error or an empty result, the raw fallback may still work
+	if q.Fallback != "" && !errors.Is(err, errThrottled) && ctx.Err() == nil && (err != nil || len(results) == 0) {
+		results, err = queryPrometheus(ctx, pURL, namespace, q.Fallback)
+		if err != nil && !errors.Is(err, errThrottled) && ctx.Err() == nil {
+			queryErrors.record(q.Name+"-fallback", err)
+		}
+	}
+	return results, err
+}
+
+// errPrometheusTimeout marks queries that hit PROMETHEUS_TIMEOUT, answered with a 504
+var errPrometheusTimeout = errors.New("prometheus query timed out")
+
+// timeoutError wraps err in errPrometheusTimeout when the client's timeout ended the query
+func timeoutError(err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return fmt.Errorf("%w after %s: %v", errPrometheusTimeout, cfg.PrometheusTimeout, err)
+	}
+	return err
+}
+
+func queryPrometheus(ctx context.Context, pURL, namespace, queryTemplate string) (map[string]float64, error) {
 	results := make(map[string]float64)
-	query := fmt.Sprintf(queryTemplate, namespace, namespace)
+	// The namespace is validated by the handler, escaping keeps it inside the label value regardless
+	ns := promLabelValue(namespace)
+	query := fmt.Sprintf(queryTemplate, ns, ns)
 	apiURL := fmt.Sprintf("%s/api/v1/query?query=%s", pURL, url.QueryEscape(query))
 
-	resp, err := http.Get(apiURL)
+	if promLimiter != nil {
+		if err := promLimiter.wait(ctx, cfg.PrometheusQueryWait); err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
 	if err != nil {
 		return nil, err
 	}
+	resp, err := promClient.Do(req)
+	if err != nil {
+		return nil, timeoutError(err)
+	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("prometheus returned status %d", resp.StatusCode)
+	}
 
 	var pResp PrometheusResponse
 	if err := json.NewDecoder(resp.Body).Decode(&pResp); err != nil {
-		return nil, err
+		return nil, timeoutError(err)
 	}
 
 	for _, res := range pResp.Data.Result {
@@ -66,69 +339,866 @@ func fetchResources(pURL, namespace, queryTemplate string) (map[string]float64,
 		if err != nil {
 			continue
 		}
-		results[res.Metric.Cluster] = val
+		cluster, ok := res.Metric[cfg.ClusterLabel]
+		if !ok {
+			continue
+		}
+		results[cluster] = val
+		if cfg.OwnerLabel != "" {
+			owners.Lock()
+			owners.byCluster[cluster] = res.Metric[cfg.OwnerLabel]
+			owners.Unlock()
+		}
 	}
+	seen.touch(results)
 	return results, nil
 }
 
 func main() {
 	rand.Seed(time.Now().UnixNano())
 
-	pURL := os.Getenv("PROMETHEUS_URL") 
-	port := os.Getenv("MEDEA_SCOUT_PORT") 
-	if port == "" {
-		port = "8080"
+	// Logs are JSON lines unless LOG_FORMAT=text
+	setupLogging()
+	cfg = loadConfig()
+
+	var err error
+	promClient, err = prometheusClient(cfg.PrometheusCA, cfg.PrometheusInsecure, cfg.PrometheusTimeout)
+	if err != nil {
+		fatal("Configuring Prometheus TLS failed", "error", err)
+	}
+
+	if os.Getenv("MEDEA_SCOUT_EXPLAIN_LEVEL") != "" {
+		explainLog = slog.Default()
 	}
 
-	http.HandleFunc("/api/request", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
+	colors.patterns, colors.file = append([]KeyValue(nil), cfg.ActiveColors...), cfg.ColorsFile
+
+	if cfg.PrometheusRPS > 0 {
+		promLimiter = newTokenBucket(cfg.PrometheusRPS, max(cfg.PrometheusBurst, 1))
+	}
+
+	http.HandleFunc("/api/request", handleRequest)
+	http.HandleFunc("POST /api/feedback", handleFeedback)
+	http.HandleFunc("POST /api/simulate", handleSimulate)
+	http.HandleFunc("GET /api/v1/seen-clusters", handleSeenClusters)
+	http.HandleFunc("GET /api/v1/reservations", requireAdmin(handleReservations))
+	http.HandleFunc("GET /api/v1/query-errors", requireAdmin(handleQueryErrors))
+	http.HandleFunc("GET /api/v1/colors", requireAdmin(handleColors))
+	http.HandleFunc("POST /api/v1/colors", requireAdmin(handleColors))
+	http.HandleFunc("GET /info", handleInfo)
+	http.HandleFunc("GET /healthz", handleHealthz)
+	http.HandleFunc("GET /readyz", handleReadyz)
+	http.Handle("GET /metrics", promhttp.Handler())
+
+	srv := &http.Server{Addr: ":" + cfg.Port}
+
+	// Optional TLS with mandatory client certificates (mTLS)
+	if cfg.MTLSCA != "" {
+		tlsCfg, err := mtlsConfig(cfg.MTLSCA)
+		if err != nil {
+			fatal("Loading mTLS CA failed", "error", err)
 		}
+		srv.TLSConfig = tlsCfg
+	}
 
-		var req RequestPayload
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid JSON", http.StatusBadRequest)
-			return
+	// On SIGINT/SIGTERM stop accepting connections and let in-flight requests finish
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if len(cfg.HotNamespaces) > 0 {
+		go warmCache(ctx, cfg.HotNamespaces, cfg.WarmInterval)
+	}
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		<-ctx.Done()
+		slog.Info("Shutting down, draining in-flight requests", "timeout", cfg.ShutdownTimeout.String())
+		drainCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(drainCtx); err != nil {
+			slog.Warn("Drain period over, closing remaining connections", "error", err)
+			srv.Close()
 		}
+	}()
+
+	slog.Info("Medea Scout starting", "port", cfg.Port, "prometheus", cfg.PrometheusURL)
+	if cfg.TLSCert != "" {
+		err = srv.ListenAndServeTLS(cfg.TLSCert, cfg.TLSKey)
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
+		fatal("Serving failed", "error", err)
+	}
+	<-drained
+	slog.Info("Medea Scout stopped")
+}
 
-		needCPU := float64(req.CPU)
-		needRAM := float64(req.RAM)
+// handleRequest picks a cluster with enough free CPU and RAM for the request
+func handleRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 
-		// PromQL templates for CPU and RAM
-		cpuQ := `kube_resourcequota{namespace="%s",resource="limits.cpu",type="hard"} - on(cluster) kube_resourcequota{namespace="%s",resource="limits.cpu",type="used"}`
-		ramQ := `(kube_resourcequota{namespace="%s",resource="limits.memory",type="hard"} - on(cluster) kube_resourcequota{namespace="%s",resource="limits.memory",type="used"})/1024^3`
+	r = withRequestID(r)
+	start := time.Now()
+	var req RequestPayload
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	slog.InfoContext(r.Context(), "Placement requested", "namespace", req.Namespace, "cpu", req.CPU, "ram", req.RAM, "dry_run", req.DryRun)
+
+	if err := validateRequest(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-		cpus, errCPU := fetchResources(pURL, req.Namespace, cpuQ)
-		mems, errRAM := fetchResources(pURL, req.Namespace, ramQ)
+	// Namespaces mapped to an owner only see that owner's clusters
+	requiredOwner := namespaceOwner(req.Namespace)
+	needCPU, needRAM, fitCPU, fitRAM := requestNeeds(req)
 
-		if errCPU != nil || errRAM != nil {
-			http.Error(w, "Prometheus communication error", http.StatusInternalServerError)
-			return
+	cpus, mems, stale, err := namespaceResources(r.Context(), req.Namespace)
+	if r.Context().Err() != nil {
+		// The client is gone, nobody reads the answer
+		return
+	}
+	if errors.Is(err, errThrottled) {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Prometheus query rate limit reached", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Prometheus query failed", "namespace", req.Namespace, "error", err)
+		writePrometheusError(w, err)
+		return
+	}
+
+	// Answers based on cached metrics from before a Prometheus outage are flagged
+	if stale {
+		w.Header().Set("X-Medea-Stale", "true")
+	}
+
+	explain := explainEnabled()
+	var candidates []candidateInfo
+	export := cfg.CapacityMetrics && exportNamespace(req.Namespace)
+
+	var suitable []string
+	suitableCPU := make(map[string]float64)
+	suitableRAM := make(map[string]float64)
+	var reason NoFitReason
+	for cluster, cVal := range cpus {
+		if len(req.Clusters) > 0 && !slices.Contains(req.Clusters, cluster) || excludedCluster(req, cluster) || !registeredCluster(cluster) {
+			reason.Excluded++
+			continue
 		}
+		if !ownedBy(cluster, requiredOwner) || !placementAllows(req.Placement, cluster) || inactiveCluster(cluster, req.Namespace) {
+			reason.Excluded++
+			continue
+		}
+		reason.ClustersSeen++
+		freeCPU, freeRAM := adjustedFree(cluster, req.Namespace, cVal, mems[cluster])
+		cpuOK, ramOK := dimensionFits(cfg.SoftCPU, freeCPU, fitCPU, needCPU), dimensionFits(cfg.SoftRAM, freeRAM, fitRAM, needRAM)
+		if export {
+			recordCapacity(cluster, req.Namespace, freeCPU, freeRAM)
+		}
+		if explain {
+			candidates = append(candidates, candidateInfo{Cluster: cluster, FreeCPU: freeCPU, FreeRAM: freeRAM, Fits: cpuOK && ramOK})
+		}
+		switch {
+		case cpuOK && ramOK:
+			suitable = append(suitable, cluster)
+			suitableCPU[cluster] = freeCPU
+			suitableRAM[cluster] = freeRAM
+		case !cpuOK && !ramOK:
+			reason.InsufficientAll++
+		case !cpuOK:
+			reason.InsufficientCPU++
+		default:
+			reason.InsufficientRAM++
+		}
+	}
 
-		var suitable []string
-		for cluster, cVal := range cpus {
-			// Compare available resources in the cluster with requirements
-			if cVal >= needCPU && mems[cluster] >= needRAM {
-				suitable = append(suitable, cluster)
+	if len(suitable) == 0 {
+		status, msg := noFitStatus(reason)
+		if explain {
+			logPlacement(r.Context(), req, candidates, reason, "none", "", stale)
+		}
+		resp := NotFoundPayload{Error: msg, Reason: reason}
+		if req.Verbose {
+			resp.Free = make(map[string]Capacity)
+			for cluster, cVal := range cpus {
+				resp.Free[cluster] = Capacity{CPU: cVal, RAM: mems[cluster]}
 			}
 		}
-
-		if len(suitable) == 0 {
-			http.Error(w, "No suitable clusters found", http.StatusNotFound)
-			return
+		// Hint clients at when capacity may be back instead of letting them retry blindly
+		if cfg.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(cfg.RetryAfter.Seconds()))))
 		}
-
-		// Return a random cluster from the suitable list
-		selected := suitable[rand.Intn(len(suitable))]
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(ResponsePayload{Cluster: selected})
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	// Return the preferred cluster if it fits, otherwise all suitable clusters tie and the tie-breaker decides
+	selected, strategy := "", cfg.TieBreaker
+	// High-priority requests never count the canary as suitable, so the gate never opens for them
+	canary, eligible := canaryGate(suitable)
+	tokenCluster, tokenOK := "", false
+	if req.PlacementToken != "" {
+		tokenCluster, tokenOK = tokens.get(req.PlacementToken)
+	}
+	switch {
+	case tokenOK && slices.Contains(suitable, tokenCluster):
+		// Co-location with earlier requests of the same token wins over everything else
+		selected, strategy = tokenCluster, "token"
+	case canary != "":
+		selected, strategy = canary, "canary"
+	case req.PreferredCluster != "" && slices.Contains(eligible, req.PreferredCluster):
+		selected, strategy = req.PreferredCluster, "preferred"
+	default:
+		selected, strategy = rankClusters(eligible, suitableCPU, suitableRAM, needCPU, needRAM, req.Namespace)
+	}
+	if cfg.TieBreaker == tiePlacements {
+		placements.record(selected)
+	}
+	// The TTL counts from the last placement with the token
+	if req.PlacementToken != "" && !req.DryRun {
+		tokens.set(req.PlacementToken, selected, cfg.TokenTTL)
+	}
+	// Hold the capacity until Prometheus catches up, dry runs place nothing
+	if cfg.ReservationTTL > 0 && !req.DryRun {
+		reservations.add(selected, Reservation{
+			Namespace: req.Namespace, CPU: needCPU, RAM: needRAM, Expires: time.Now().Add(cfg.ReservationTTL),
+		})
+	}
+	if explain {
+		logPlacement(r.Context(), req, candidates, reason, strategy, selected, stale)
+	}
+	slog.DebugContext(r.Context(), "Cluster selected", "namespace", req.Namespace, "cluster", selected, "strategy", strategy,
+		"latency_ms", time.Since(start).Milliseconds())
+	w.Header().Set("Content-Type", "application/json")
+	// The headroom is answered in the unit the request came in, not in scout's
+	ramUnit := cmp.Or(req.RAMUnit, memoryGB)
+	freeCPU, freeMem := suitableCPU[selected]-needCPU, memoryInRequestUnit(suitableRAM[selected]-needRAM, ramUnit)
+	json.NewEncoder(w).Encode(ResponsePayload{Cluster: selected, Stale: stale, FreeCPU: &freeCPU, FreeMem: &freeMem, RAMUnit: ramUnit})
+}
+
+// writePrometheusError answers a failed Prometheus lookup: 504 when it timed out, so the
+// balancer can tell a slow Prometheus from a broken one, 500 otherwise
+func writePrometheusError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errPrometheusTimeout) {
+		http.Error(w, fmt.Sprintf("Prometheus query timed out after %s", cfg.PrometheusTimeout), http.StatusGatewayTimeout)
+		return
+	}
+	http.Error(w, "Prometheus communication error", http.StatusInternalServerError)
+}
+
+// validateRequest checks the parts of a request that end up in PromQL or conversions
+func validateRequest(req RequestPayload) error {
+	// The namespace ends up in PromQL, anything but a plain namespace name is refused
+	if !cfg.NamespacePattern.MatchString(req.Namespace) {
+		return errors.New("Invalid namespace")
+	}
+	// The request's memory is compared in scout's unit, whatever unit the balancer uses
+	if req.RAMUnit != "" && req.RAMUnit != memoryGB && req.RAMUnit != memoryMiB {
+		return errors.New("Invalid ramUnit, expected GB or MiB")
+	}
+	return nil
+}
+
+// requestNeeds returns what a request takes from a cluster and what must be free for it to fit,
+// both in MemoryUnit
+func requestNeeds(req RequestPayload) (needCPU, needRAM, fitCPU, fitRAM float64) {
+	needCPU = float64(req.CPU)
+	needRAM = convertMemory(req.RAM, req.RAMUnit)
+	// Headroom asked for by the client has to be free on top of the request, but isn't reserved
+	fitCPU, fitRAM = needCPU, needRAM
+	if req.Placement != nil {
+		fitCPU += req.Placement.MinFreeCPU
+		fitRAM += convertMemory(req.Placement.MinFreeRAM, req.RAMUnit)
+	}
+	return needCPU, needRAM, fitCPU, fitRAM
+}
+
+// namespaceResources returns the free CPU and RAM per cluster for a namespace. CPU and RAM are
+// queried at once, a slow Prometheus then costs one round trip instead of two. The first
+// failure cancels the other query and is returned.
+func namespaceResources(ctx context.Context, namespace string) (cpus, mems map[string]float64, stale bool, err error) {
+	queries := placementQueries()
+	var staleCPU, staleRAM bool
+	// A failed query cancels the other one, the lookup fails either way
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() (err error) {
+		cpus, staleCPU, err = cachedResources(gctx, namespace, queries[0])
+		return err
 	})
+	g.Go(func() (err error) {
+		mems, staleRAM, err = cachedResources(gctx, namespace, queries[1])
+		return err
+	})
+	if err := g.Wait(); err != nil {
+		return nil, nil, false, err
+	}
+	return cpus, mems, staleCPU || staleRAM, nil
+}
+
+// excludedCluster reports whether cluster is excluded by SCOUT_EXCLUDE_CLUSTERS or by the request
+func excludedCluster(req RequestPayload, cluster string) bool {
+	return slices.Contains(cfg.ExcludeClusters, cluster) || slices.Contains(req.ExcludeClusters, cluster)
+}
+
+// dimensionFits reports whether fit, the request plus its headroom, fits into free. A soft
+// dimension may fall short by MEDEA_SCOUT_OVERCOMMIT of the request need.
+func dimensionFits(soft bool, free, fit, need float64) bool {
+	if soft {
+		return free+cfg.Overcommit*need >= fit
+	}
+	return free >= fit
+}
+
+// adjustedFree is the free capacity Prometheus reported for a cluster minus what recent
+// placements hold and what failure penalties take
+func adjustedFree(cluster, namespace string, cpu, ram float64) (float64, float64) {
+	if cfg.ReservationTTL > 0 {
+		heldCPU, heldRAM := reservations.held(cluster, namespace)
+		cpu, ram = cpu-heldCPU, ram-heldRAM
+	}
+	// Clusters that recently failed submits look smaller until the penalty has decayed
+	if cfg.FailurePenalty > 0 {
+		f := penalties.factor(cluster)
+		cpu, ram = penalized(cpu, f), penalized(ram, f)
+	}
+	return cpu, ram
+}
+
+// rankClusters picks among suitable clusters: the strategy narrows them down to the best scoring
+// ones, the tie-breaker picks among those. It returns the cluster and the strategy used.
+func rankClusters(eligible []string, freeCPU, freeRAM map[string]float64, needCPU, needRAM float64, namespace string) (string, string) {
+	strategy := cfg.TieBreaker
+	if cfg.Strategy != strategyRandom {
+		eligible = bestScoring(eligible, freeCPU, freeRAM, needCPU, needRAM)
+		strategy = cfg.Strategy + "/" + cfg.TieBreaker
+	}
+	return breakTie(eligible, namespace, freeCPU), strategy
+}
+
+// namespaceOwner returns the owner a namespace is restricted to, or "" if unrestricted
+func namespaceOwner(ns string) string {
+	for _, kv := range cfg.NamespaceOwners {
+		if ok, _ := path.Match(kv.Key, ns); ok {
+			return kv.Value
+		}
+	}
+	return ""
+}
+
+// ownedBy reports whether the cluster may be used by a namespace restricted to owner
+func ownedBy(cluster, owner string) bool {
+	if owner == "" {
+		return true
+	}
+	owners.Lock()
+	defer owners.Unlock()
+	return owners.byCluster[cluster] == owner
+}
+
+// promLabelValue escapes s for use inside a double-quoted PromQL label matcher
+func promLabelValue(s string) string {
+	q := strconv.Quote(s)
+	return q[1 : len(q)-1]
+}
+
+// promQuery is a PromQL template with the dimension it measures. A template receives the
+// requested namespace twice, for its two %s verbs.
+type promQuery struct {
+	Name     string
+	Template string
+	// Fallback is tried when Template fails or returns nothing, empty means none
+	Fallback string
+}
+
+// Built-in PromQL for the free quota of a namespace; on(cluster) is adapted to the configured labels
+const (
+	defaultCPUQuery = `kube_resourcequota{namespace="%s",resource="limits.cpu",type="hard"} - on(cluster) kube_resourcequota{namespace="%s",resource="limits.cpu",type="used"}`
+	defaultRAMQuery = `(kube_resourcequota{namespace="%s",resource="limits.memory",type="hard"} - on(cluster) kube_resourcequota{namespace="%s",resource="limits.memory",type="used"})/1024^3`
+)
 
-	fmt.Printf("Medea Scout starting on :%s (Prometheus: %s)\n", port, pURL)
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		fmt.Fprintf(os.Stderr, "Server failed: %v\n", err)
-		os.Exit(1)
+// Memory units, set by MEDEA_SCOUT_MEMORY_UNIT for Prometheus results and by ramUnit for requests.
+// GB are 1024^3 bytes.
+const (
+	memoryGB  = "GB"
+	memoryMiB = "MiB"
+)
+
+// convertMemory converts v from unit ("" means GB) to scout's memory unit
+func convertMemory(v float64, unit string) float64 {
+	if unit == "" {
+		unit = memoryGB
+	}
+	switch {
+	case unit == cfg.MemoryUnit:
+		return v
+	case unit == memoryGB:
+		return v * 1024
+	default:
+		return v / 1024
+	}
+}
+
+// memoryInRequestUnit converts v from scout's memory unit to unit, the inverse of convertMemory
+func memoryInRequestUnit(v float64, unit string) float64 {
+	switch {
+	case unit == cfg.MemoryUnit:
+		return v
+	case unit == memoryGB:
+		return v / 1024
+	default:
+		return v * 1024
+	}
+}
+
+// namespaceVerbs is the number of %s verbs of a query template, each receives the namespace
+const namespaceVerbs = 2
+
+// checkQueryTemplate checks that q has exactly namespaceVerbs %s verbs. %% is the only other
+// verb allowed, for PromQL's modulo operator.
+func checkQueryTemplate(q string) error {
+	verbs := 0
+	for i := 0; i < len(q); i++ {
+		if q[i] != '%' {
+			continue
+		}
+		i++
+		switch {
+		case i == len(q):
+			return fmt.Errorf("ends with a lone %%")
+		case q[i] == 's':
+			verbs++
+		case q[i] != '%':
+			return fmt.Errorf("has the verb %%%c, only %%s and %%%% are allowed", q[i])
+		}
+	}
+	if verbs != namespaceVerbs {
+		return fmt.Errorf("has %d %%s verbs, want %d: the namespace is passed twice", verbs, namespaceVerbs)
+	}
+	return nil
+}
+
+// placementQueries returns the PromQL templates for free CPU and RAM, matched on the configured cluster label
+func placementQueries() [2]promQuery {
+	onLabels := cfg.ClusterLabel
+	if cfg.OwnerLabel != "" {
+		// Binary operators only keep the on(...) labels, so the owner must be listed too
+		onLabels += ", " + cfg.OwnerLabel
+	}
+	onLabel := "on(" + onLabels + ")"
+	cpuQ, ramQ := cfg.CPUQuery, cfg.RAMQuery
+	if cpuQ == "" {
+		cpuQ = strings.ReplaceAll(defaultCPUQuery, "on(cluster)", onLabel)
+	}
+	if ramQ == "" {
+		ramQ = strings.ReplaceAll(defaultRAMQuery, "on(cluster)", onLabel)
+		if cfg.MemoryUnit == memoryMiB {
+			ramQ = strings.Replace(ramQ, "/1024^3", "/1024^2", 1)
+		}
+	}
+	return [2]promQuery{
+		{Name: "cpu", Template: cpuQ, Fallback: cfg.CPUFallbackQuery},
+		{Name: "ram", Template: ramQ, Fallback: cfg.RAMFallbackQuery},
+	}
+}
+
+// handleSeenClusters lists every cluster reported by Prometheus recently, with when it was last seen
+func handleSeenClusters(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(seen.list())
+}
+
+// Timeout of the Prometheus query behind /readyz
+const readyTimeout = 2 * time.Second
+
+// handleHealthz is the liveness probe: the process is up and serving
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok\n"))
+}
+
+// handleReadyz is the readiness probe: a trivial query confirms Prometheus answers queries,
+// not just that it is up. It bypasses the query rate limit and error counters.
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cfg.PrometheusURL+"/api/v1/query?query=vector(1)", nil)
+	if err == nil {
+		var resp *http.Response
+		if resp, err = promClient.Do(req); err == nil {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				err = fmt.Errorf("prometheus returned status %d", resp.StatusCode)
+			}
+		}
+	}
+	if err != nil {
+		http.Error(w, "prometheus unreachable: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+// SeenCluster is a cluster observed in Prometheus results
+type SeenCluster struct {
+	Cluster  string    `json:"cluster"`
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+// seenClusters remembers when each cluster last appeared in a Prometheus result
+type seenClusters struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+func (s *seenClusters) touch(clusters map[string]float64) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.last == nil {
+		s.last = make(map[string]time.Time)
+	}
+	for cluster := range clusters {
+		s.last[cluster] = now
+	}
+}
+
+func (s *seenClusters) list() []SeenCluster {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]SeenCluster, 0, len(s.last))
+	for cluster, t := range s.last {
+		list = append(list, SeenCluster{Cluster: cluster, LastSeen: t})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Cluster < list[j].Cluster })
+	return list
+}
+
+// noFitStatus maps the no-fit reason to the response status and message.
+// Without MEDEA_SCOUT_DETAILED_STATUS every cause is a 404, as before.
+func noFitStatus(reason NoFitReason) (int, string) {
+	if !cfg.DetailedStatus {
+		return http.StatusNotFound, "No suitable clusters found"
+	}
+	switch {
+	case reason.ClustersSeen == 0 && reason.Excluded == 0:
+		return http.StatusNotFound, "No clusters reported by Prometheus"
+	case reason.ClustersSeen == 0:
+		return http.StatusServiceUnavailable, "All clusters are excluded"
+	default:
+		return http.StatusInsufficientStorage, "Insufficient quota in all clusters"
+	}
+}
+
+func loadConfig() Config {
+	c := Config{
+		PrometheusURL: os.Getenv("PROMETHEUS_URL"),
+		Port:          os.Getenv("MEDEA_SCOUT_PORT"),
+		TLSCert:       os.Getenv("MEDEA_TLS_CERT"),
+		TLSKey:        os.Getenv("MEDEA_TLS_KEY"),
+		MTLSCA:        os.Getenv("MEDEA_MTLS_CA"),
+		ClusterLabel:  os.Getenv("MEDEA_SCOUT_CLUSTER_LABEL"),
+
+		ShutdownTimeout: envDuration("MEDEA_SCOUT_SHUTDOWN_TIMEOUT", 15*time.Second),
+
+		OwnerLabel:      os.Getenv("MEDEA_SCOUT_OWNER_LABEL"),
+		NamespaceOwners: envKeyValues("MEDEA_SCOUT_NAMESPACE_OWNERS"),
+		ClusterClasses:  envKeyValues("MEDEA_SCOUT_CLUSTER_CLASSES"),
+
+		ClusterPairs: envKeyValues("MEDEA_SCOUT_CLUSTER_PAIRS"),
+		ActiveColors: envKeyValues("MEDEA_SCOUT_ACTIVE_COLORS"),
+		ColorsFile:   os.Getenv("MEDEA_SCOUT_COLORS_FILE"),
+
+		PrometheusCA:       os.Getenv("PROMETHEUS_CA_BUNDLE"),
+		PrometheusInsecure: os.Getenv("PROMETHEUS_INSECURE_SKIP_VERIFY") == "true",
+
+		DetailedStatus: os.Getenv("MEDEA_SCOUT_DETAILED_STATUS") == "true",
+		RetryAfter:     envDuration("MEDEA_SCOUT_RETRY_AFTER", 0),
+
+		CacheTTL:      envDuration("SCOUT_CACHE_TTL", envDuration("MEDEA_SCOUT_CACHE_TTL", 10*time.Second)),
+		HotNamespaces: envList("MEDEA_SCOUT_HOT_NAMESPACES"),
+		MaxStale:      envDuration("MEDEA_SCOUT_MAX_STALE", 0),
+
+		ExcludeClusters: envList("SCOUT_EXCLUDE_CLUSTERS"),
+
+		PrometheusTimeout: envDuration("PROMETHEUS_TIMEOUT", 5*time.Second),
+
+		Overcommit: envFloat("MEDEA_SCOUT_OVERCOMMIT", 0),
+
+		Strategy:        os.Getenv("SCOUT_STRATEGY"),
+		TieBreaker:      os.Getenv("MEDEA_SCOUT_TIE_BREAKER"),
+		PlacementWindow: envDuration("MEDEA_SCOUT_PLACEMENT_WINDOW", 5*time.Minute),
+		SeedEpoch:       os.Getenv("MEDEA_SCOUT_SEED_EPOCH"),
+		SeedRotation:    envDuration("MEDEA_SCOUT_SEED_ROTATION", 0),
+
+		PrometheusRPS:       envFloat("PROMETHEUS_QUERY_RPS", 0),
+		PrometheusBurst:     envInt("PROMETHEUS_QUERY_BURST", 1),
+		PrometheusQueryWait: envDuration("PROMETHEUS_QUERY_MAX_WAIT", time.Second),
+
+		CapacityMetrics:      os.Getenv("MEDEA_SCOUT_CAPACITY_METRICS") == "true",
+		MetricsMaxNamespaces: envInt("MEDEA_SCOUT_METRICS_MAX_NAMESPACES", 50),
+
+		TokenTTL: envDuration("MEDEA_SCOUT_TOKEN_TTL", 10*time.Minute),
+
+		CanaryCluster: os.Getenv("MEDEA_SCOUT_CANARY_CLUSTER"),
+		CanaryPercent: envFloat("MEDEA_SCOUT_CANARY_PERCENT", 10),
+
+		MemoryUnit:       os.Getenv("MEDEA_SCOUT_MEMORY_UNIT"),
+		CPUQuery:         os.Getenv("SCOUT_CPU_QUERY"),
+		RAMQuery:         os.Getenv("SCOUT_RAM_QUERY"),
+		CPUFallbackQuery: os.Getenv("SCOUT_CPU_FALLBACK_QUERY"),
+		RAMFallbackQuery: os.Getenv("SCOUT_RAM_FALLBACK_QUERY"),
+
+		ReservationTTL: envDuration("MEDEA_SCOUT_RESERVATION_TTL", 0),
+		FailurePenalty: envFloat("MEDEA_SCOUT_FAILURE_PENALTY", 0),
+		PenaltyWindow:  envDuration("MEDEA_SCOUT_PENALTY_WINDOW", 10*time.Minute),
+		AdminToken:     os.Getenv("MEDEA_SCOUT_ADMIN_TOKEN"),
+
+		ExplainLevel: envLevel("MEDEA_SCOUT_EXPLAIN_LEVEL", slog.LevelInfo),
+
+		Info:                os.Getenv("MEDEA_SCOUT_INFO") != "false",
+		SimulateMaxRequests: envInt("MEDEA_SCOUT_SIMULATE_MAX_REQUESTS", 1000),
+	}
+	if c.Port == "" {
+		c.Port = "8080"
+	}
+	if c.ClusterLabel == "" {
+		c.ClusterLabel = "cluster"
+	}
+
+	if c.CanaryPercent > 100 {
+		fatalf("MEDEA_SCOUT_CANARY_PERCENT must be between 0 and 100")
+	}
+
+	for _, dim := range envList("MEDEA_SCOUT_SOFT_DIMENSIONS") {
+		switch strings.ToLower(dim) {
+		case "cpu":
+			c.SoftCPU = true
+		case "ram":
+			c.SoftRAM = true
+		default:
+			fatalf("Invalid MEDEA_SCOUT_SOFT_DIMENSIONS item %q, expected cpu or ram", dim)
+		}
 	}
-}
\ No newline at end of file
+	for _, kv := range envKeyValues("SCOUT_CLUSTER_WEIGHTS") {
+		w, err := strconv.ParseFloat(kv.Value, 64)
+		if err != nil || w <= 0 || math.IsInf(w, 0) {
+			fatalf("Invalid SCOUT_CLUSTER_WEIGHTS weight %q for %s, expected a positive number", kv.Value, kv.Key)
+		}
+		if c.ClusterWeights == nil {
+			c.ClusterWeights = make(map[string]float64)
+		}
+		c.ClusterWeights[kv.Key] = w
+	}
+
+	loadRegistry(&c)
+
+	if c.Overcommit < 0 || c.Overcommit > 1 {
+		fatalf("MEDEA_SCOUT_OVERCOMMIT must be between 0 and 1")
+	}
+
+	if c.FailurePenalty > 1 {
+		fatalf("MEDEA_SCOUT_FAILURE_PENALTY must be between 0 and 1")
+	}
+	if c.FailurePenalty > 0 && c.PenaltyWindow <= 0 {
+		fatalf("MEDEA_SCOUT_PENALTY_WINDOW must be positive when MEDEA_SCOUT_FAILURE_PENALTY is set")
+	}
+
+	for _, kv := range c.ActiveColors {
+		if kv.Value != colorBlue && kv.Value != colorGreen {
+			fatalf("Invalid MEDEA_SCOUT_ACTIVE_COLORS color %q for %s, expected blue or green", kv.Value, kv.Key)
+		}
+	}
+
+	switch c.MemoryUnit {
+	case "":
+		c.MemoryUnit = memoryGB
+	case memoryGB, memoryMiB:
+	default:
+		fatalf("Invalid MEDEA_SCOUT_MEMORY_UNIT %q, expected GB or MiB", c.MemoryUnit)
+	}
+
+	switch c.Strategy {
+	case "":
+		c.Strategy = strategyRandom
+	case strategyRandom, strategyMostCPU, strategyMostMem, strategyMostFree:
+	default:
+		fatalf("Invalid SCOUT_STRATEGY %q, expected random, most-cpu, most-mem or most-free", c.Strategy)
+	}
+
+	switch c.TieBreaker {
+	case "":
+		c.TieBreaker = tieRandom
+	case tieRandom, tieName, tiePlacements, tieNamespace:
+	default:
+		fatalf("Invalid MEDEA_SCOUT_TIE_BREAKER %q, expected random, name, placements or namespace", c.TieBreaker)
+	}
+
+	// Kubernetes namespaces are DNS-1123 labels unless configured otherwise
+	pattern := os.Getenv("MEDEA_SCOUT_NAMESPACE_PATTERN")
+	if pattern == "" {
+		pattern = `[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?`
+	}
+	re, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		fatalf("Invalid MEDEA_SCOUT_NAMESPACE_PATTERN: %v", err)
+	}
+	c.NamespacePattern = re
+
+	// Client certificates can only be verified over TLS
+	if c.MTLSCA != "" && (c.TLSCert == "" || c.TLSKey == "") {
+		fatalf("MEDEA_MTLS_CA requires MEDEA_TLS_CERT and MEDEA_TLS_KEY")
+	}
+	if len(c.NamespaceOwners) > 0 && c.OwnerLabel == "" {
+		fatalf("MEDEA_SCOUT_NAMESPACE_OWNERS requires MEDEA_SCOUT_OWNER_LABEL")
+	}
+
+	// A template with the wrong number of verbs would query a mangled namespace
+	for _, q := range []struct{ key, query string }{
+		{"SCOUT_CPU_QUERY", c.CPUQuery},
+		{"SCOUT_RAM_QUERY", c.RAMQuery},
+		{"SCOUT_CPU_FALLBACK_QUERY", c.CPUFallbackQuery},
+		{"SCOUT_RAM_FALLBACK_QUERY", c.RAMFallbackQuery},
+	} {
+		if q.query == "" {
+			continue
+		}
+		if err := checkQueryTemplate(q.query); err != nil {
+			fatalf("Invalid %s: %v", q.key, err)
+		}
+	}
+
+	// Shed queries serve cached results only as old as an outage would, a minute without MaxStale
+	shedMaxStale := time.Minute
+	if c.MaxStale > 0 {
+		shedMaxStale = c.MaxStale
+	}
+	c.ShedMaxStale = envDuration("PROMETHEUS_QUERY_MAX_STALE", shedMaxStale)
+
+	// Warming only makes sense with a cache, and must beat the TTL to avoid cold hits
+	c.WarmInterval = envDuration("MEDEA_SCOUT_WARM_INTERVAL", c.CacheTTL*4/5)
+	if len(c.HotNamespaces) > 0 {
+		if c.CacheTTL <= 0 {
+			fatalf("MEDEA_SCOUT_HOT_NAMESPACES requires SCOUT_CACHE_TTL")
+		}
+		if c.WarmInterval <= 0 || c.WarmInterval >= c.CacheTTL {
+			fatalf("MEDEA_SCOUT_WARM_INTERVAL must be positive and shorter than SCOUT_CACHE_TTL")
+		}
+	}
+	return c
+}
+
+// envDuration reads a Go duration from env, falling back to def when unset
+func envDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		fatalf("Invalid %s: %v", key, err)
+	}
+	return d
+}
+
+// envFloat reads a number from env, falling back to def when unset
+func envFloat(key string, def float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < 0 {
+		fatalf("Invalid %s %q", key, v)
+	}
+	return f
+}
+
+// envInt reads a non-negative integer from env, falling back to def when unset
+func envInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		fatalf("Invalid %s %q", key, v)
+	}
+	return n
+}
+
+// envList reads a comma-separated list from env, skipping empty items
+func envList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// envKeyValues reads a comma-separated list of key=value pairs from env, keeping their order
+func envKeyValues(key string) []KeyValue {
+	var list []KeyValue
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok {
+			if item != "" {
+				slog.Warn("Ignoring malformed item, expected key=value", "setting", key, "item", item)
+			}
+			continue
+		}
+		list = append(list, KeyValue{Key: strings.TrimSpace(k), Value: strings.TrimSpace(v)})
+	}
+	return list
+}
+
+// prometheusClient builds the HTTP client for Prometheus, trusting caFile in addition
+// to the system roots and optionally skipping verification
+func prometheusClient(caFile string, insecure bool, timeout time.Duration) (*http.Client, error) {
+	if caFile == "" && !insecure {
+		return &http.Client{Timeout: timeout}, nil
+	}
+	tlsCfg := &tls.Config{InsecureSkipVerify: insecure}
+	if caFile != "" {
+		caPEM, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		tlsCfg.RootCAs = pool
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsCfg
+	return &http.Client{Transport: transport, Timeout: timeout}, nil
+}
+
+// mtlsConfig builds a server TLS config that requires a client certificate signed by the given CA
+func mtlsConfig(caFile string) (*tls.Config, error) {
+	caPEM, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	return &tls.Config{
+		ClientCAs:  pool,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+	}, nil
+}
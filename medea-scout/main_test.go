@@ -0,0 +1,768 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"medea/internal/placement"
+)
+
+func TestMemoryUnitConversion(t *testing.T) {
+	defer func() { cfg = Config{} }()
+	tests := []struct {
+		scoutUnit   string
+		requestUnit string
+		request     float64 // in requestUnit
+		want        float64 // in scoutUnit
+	}{
+		{memoryGB, "", 2, 2},
+		{memoryGB, memoryGB, 2, 2},
+		{memoryGB, memoryMiB, 512, 0.5},
+		{memoryMiB, memoryGB, 2, 2048},
+		{memoryMiB, memoryMiB, 300, 300},
+	}
+	for _, tt := range tests {
+		cfg = Config{MemoryUnit: tt.scoutUnit}
+		got := convertMemory(tt.request, tt.requestUnit)
+		if got != tt.want {
+			t.Errorf("convertMemory(%v %q) with scout in %s = %v, want %v", tt.request, tt.requestUnit, tt.scoutUnit, got, tt.want)
+		}
+		// Headroom goes back to the client in the unit it asked in
+		unit := tt.requestUnit
+		if unit == "" {
+			unit = memoryGB
+		}
+		if back := memoryInRequestUnit(got, unit); back != tt.request {
+			t.Errorf("memoryInRequestUnit(%v, %s) with scout in %s = %v, want %v", got, unit, tt.scoutUnit, back, tt.request)
+		}
+	}
+}
+
+// placeWith answers one placement request against cached free CPU and RAM, without Prometheus
+func placeWith(t *testing.T, cpus, mems map[string]float64, req RequestPayload) *httptest.ResponseRecorder {
+	t.Helper()
+	cache = resourceCache{entries: make(map[cacheKey]cacheEntry)}
+	queries := placementQueries()
+	for i, free := range []map[string]float64{cpus, mems} {
+		cache.entries[cacheKey{namespace: req.Namespace, query: queries[i].Template}] = cacheEntry{values: free, fetchedAt: time.Now()}
+	}
+	body, _ := json.Marshal(req)
+	w := httptest.NewRecorder()
+	handleRequest(w, httptest.NewRequest(http.MethodPost, "/api/request", bytes.NewReader(body)))
+	return w
+}
+
+func TestHighPriorityAvoidsCanary(t *testing.T) {
+	defer func() {
+		cfg = Config{}
+		cache = resourceCache{entries: make(map[cacheKey]cacheEntry)}
+		tokens = placementTokens{tokens: make(map[string]tokenPlacement)}
+	}()
+	// The canary gate always opens, so normal requests land on the canary whenever it fits
+	cfg = Config{
+		NamespacePattern: regexp.MustCompile(`^[a-z0-9-]+$`), ClusterLabel: "cluster", MemoryUnit: memoryGB,
+		CacheTTL: time.Hour, TokenTTL: time.Hour, Strategy: strategyRandom, TieBreaker: tieName,
+		CanaryCluster: "canary", CanaryPercent: 100, DetailedStatus: true,
+	}
+	high := &placement.Constraints{Priority: placement.PriorityHigh}
+	both := map[string]float64{"canary": 8, "stable": 8}
+	tests := []struct {
+		name       string
+		free       map[string]float64
+		req        RequestPayload
+		token      string // cluster the request's token is bound to
+		wantStatus int
+		want       string
+	}{
+		{"normal", both, RequestPayload{}, "", http.StatusOK, "canary"},
+		{"high", both, RequestPayload{Placement: high}, "", http.StatusOK, "stable"},
+		{"high with a token bound to the canary", both, RequestPayload{Placement: high, PlacementToken: "t"}, "canary", http.StatusOK, "stable"},
+		{"high preferring the canary", both, RequestPayload{Placement: high, PreferredCluster: "canary"}, "", http.StatusOK, "stable"},
+		{"normal, only the canary fits", map[string]float64{"canary": 8, "stable": 1}, RequestPayload{}, "", http.StatusOK, "canary"},
+		{"high, only the canary fits", map[string]float64{"canary": 8, "stable": 1}, RequestPayload{Placement: high}, "", http.StatusInsufficientStorage, ""},
+		{"high, only the canary is there", map[string]float64{"canary": 8}, RequestPayload{Placement: high}, "", http.StatusServiceUnavailable, ""},
+	}
+	for _, tt := range tests {
+		tokens = placementTokens{tokens: make(map[string]tokenPlacement)}
+		if tt.token != "" {
+			tokens.set(tt.req.PlacementToken, tt.token, time.Hour)
+		}
+		tt.req.Namespace, tt.req.CPU, tt.req.RAM = "batch-a", 2, 2
+		w := placeWith(t, tt.free, tt.free, tt.req)
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.wantStatus, w.Body)
+			continue
+		}
+		if tt.want == "" {
+			continue
+		}
+		var resp ResponsePayload
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp.Cluster != tt.want {
+			t.Errorf("%s: cluster %q (%v), want %q", tt.name, resp.Cluster, err, tt.want)
+		}
+		if tt.token != "" {
+			if c, _ := tokens.get(tt.req.PlacementToken); c != tt.want {
+				t.Errorf("%s: token bound to %q, want it rebound to %q", tt.name, c, tt.want)
+			}
+		}
+	}
+
+	// Simulations skip the gate but still keep high-priority requests off the canary
+	sim := simulatePlacement(RequestPayload{Namespace: "batch-a", CPU: 2, RAM: 2, Placement: high}, map[string]Capacity{"canary": {CPU: 8, RAM: 8}})
+	if sim.Cluster != "" || sim.Error == "" {
+		t.Errorf("simulated high-priority placement got %q, want no cluster", sim.Cluster)
+	}
+}
+
+func TestNamespaceResourcesQueriesConcurrently(t *testing.T) {
+	defer func() { cfg = Config{} }()
+	// Each query waits until the other one arrived, so queries made one after the other time out
+	arrived := make(chan struct{}, 2)
+	var failRAM atomic.Bool
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		deadline := time.After(2 * time.Second)
+		for len(arrived) < 2 {
+			select {
+			case <-deadline:
+				http.Error(w, "the other query never came", http.StatusGatewayTimeout)
+				return
+			case <-time.After(time.Millisecond):
+			}
+		}
+		query := r.URL.Query().Get("query")
+		if strings.HasPrefix(query, "ram_free") && failRAM.Load() {
+			http.Error(w, "broken", http.StatusInternalServerError)
+			return
+		}
+		value := "4"
+		if strings.HasPrefix(query, "ram_free") {
+			value = "16"
+		}
+		fmt.Fprintf(w, `{"status": "success", "data": {"result": [{"metric": {"cluster": "c1"}, "value": [0, %q]}]}}`, value)
+	}))
+	defer prom.Close()
+	cfg = Config{
+		PrometheusURL: prom.URL, ClusterLabel: "cluster",
+		CPUQuery: `cpu_free{namespace="%s"} or cpu_free{namespace="%s"}`, RAMQuery: `ram_free{namespace="%s"} or ram_free{namespace="%s"}`,
+	}
+
+	start := time.Now()
+	cpus, mems, _, err := namespaceResources(context.Background(), "batch-a")
+	if err != nil {
+		t.Fatalf("namespaceResources: %v", err)
+	}
+	if cpus["c1"] != 4 || mems["c1"] != 16 {
+		t.Errorf("got cpu %v, ram %v, want 4 and 16 on c1", cpus, mems)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("both queries took %v, they didn't run in parallel", d)
+	}
+
+	// Either query failing fails the lookup
+	for len(arrived) > 0 {
+		<-arrived
+	}
+	failRAM.Store(true)
+	if _, _, _, err := namespaceResources(context.Background(), "batch-a"); err == nil {
+		t.Error("a failed RAM query went unnoticed")
+	}
+}
+
+func TestNamespaceResourcesFailureCancels(t *testing.T) {
+	defer func() { cfg = Config{} }()
+	// The CPU query hangs until it is cancelled, the RAM query fails at once
+	cancelled := make(chan struct{})
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Query().Get("query"), "ram_free") {
+			http.Error(w, "broken", http.StatusInternalServerError)
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			close(cancelled)
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer prom.Close()
+	cfg = Config{
+		PrometheusURL: prom.URL, ClusterLabel: "cluster",
+		CPUQuery: `cpu_free{namespace="%s"} or cpu_free{namespace="%s"}`, RAMQuery: `ram_free{namespace="%s"} or ram_free{namespace="%s"}`,
+	}
+
+	start := time.Now()
+	if _, _, _, err := namespaceResources(context.Background(), "batch-a"); err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("got error %v, want the failed RAM query", err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("the lookup took %v, the failed RAM query didn't cancel the CPU one", d)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("the CPU query was not cancelled")
+	}
+}
+
+func TestPrometheusTimeout(t *testing.T) {
+	defer func() { cfg, promClient = Config{}, http.DefaultClient }()
+	release := make(chan struct{})
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer prom.Close()
+	defer close(release)
+
+	cfg = Config{
+		PrometheusURL: prom.URL, PrometheusTimeout: 50 * time.Millisecond, ClusterLabel: "cluster", MemoryUnit: memoryGB,
+		NamespacePattern: regexp.MustCompile(`^[a-z0-9-]+$`), CPUQuery: `cpu_free{namespace="%s"} or cpu_free{namespace="%s"}`, RAMQuery: `ram_free{namespace="%s"} or ram_free{namespace="%s"}`,
+	}
+	var err error
+	if promClient, err = prometheusClient("", false, cfg.PrometheusTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	w := httptest.NewRecorder()
+	handleRequest(w, httptest.NewRequest(http.MethodPost, "/api/request", strings.NewReader(`{"namespace": "batch-a", "cpu": 1, "ram": 1}`)))
+	if w.Code != http.StatusGatewayTimeout || !strings.Contains(w.Body.String(), "timed out after 50ms") {
+		t.Errorf("slow Prometheus answered %d %q, want 504 naming the timeout", w.Code, strings.TrimSpace(w.Body.String()))
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("request took %v, the timeout didn't cut the query short", d)
+	}
+}
+
+func TestSoftDimensions(t *testing.T) {
+	defer func() {
+		cfg = Config{}
+		cache = resourceCache{entries: make(map[cacheKey]cacheEntry)}
+	}()
+	// c1 has 3 cores and 8 GB free
+	cpus, mems := map[string]float64{"c1": 3}, map[string]float64{"c1": 8}
+	tests := []struct {
+		name             string
+		softCPU, softRAM bool
+		cpu, ram         float64
+		wantFit          bool
+	}{
+		{"all hard, CPU short", false, false, 4, 4, false},
+		{"soft CPU within the overcommit", true, false, 4, 4, true},
+		{"soft CPU beyond the overcommit", true, false, 8, 4, false},
+		{"soft CPU, hard RAM short", true, false, 4, 10, false},
+		{"soft RAM only, hard CPU short", false, true, 4, 4, false},
+		{"both soft", true, true, 4, 10, true},
+	}
+	for _, tt := range tests {
+		cfg = Config{
+			NamespacePattern: regexp.MustCompile(`^[a-z0-9-]+$`), ClusterLabel: "cluster", MemoryUnit: memoryGB,
+			CacheTTL: time.Hour, Strategy: strategyRandom, TieBreaker: tieName, DetailedStatus: true,
+			SoftCPU: tt.softCPU, SoftRAM: tt.softRAM, Overcommit: 0.5,
+		}
+		w := placeWith(t, cpus, mems, RequestPayload{Namespace: "batch-a", CPU: tt.cpu, RAM: tt.ram})
+		if fit := w.Code == http.StatusOK; fit != tt.wantFit {
+			t.Errorf("%s: %v cores, %v GB answered %d, want fit %v", tt.name, tt.cpu, tt.ram, w.Code, tt.wantFit)
+		}
+	}
+}
+
+func TestExcludeClusters(t *testing.T) {
+	defer func() {
+		cfg = Config{}
+		cache = resourceCache{entries: make(map[cacheKey]cacheEntry)}
+	}()
+	free := map[string]float64{"east": 16, "west": 8}
+	tests := []struct {
+		name       string
+		envExclude []string
+		reqExclude []string
+		wantStatus int
+		want       string
+	}{
+		{"nothing excluded", nil, nil, http.StatusOK, "east"},
+		{"excluded by SCOUT_EXCLUDE_CLUSTERS", []string{"east"}, nil, http.StatusOK, "west"},
+		{"excluded by the request", nil, []string{"east"}, http.StatusOK, "west"},
+		{"both excluded, one each way", []string{"east"}, []string{"west"}, http.StatusNotFound, ""},
+		{"both excluded by the request", nil, []string{"east", "west"}, http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		// The most-cpu strategy picks east whenever it is allowed
+		cfg = Config{
+			NamespacePattern: regexp.MustCompile(`^[a-z0-9-]+$`), ClusterLabel: "cluster", MemoryUnit: memoryGB,
+			CacheTTL: time.Hour, Strategy: strategyMostCPU, TieBreaker: tieName, ExcludeClusters: tt.envExclude,
+		}
+		w := placeWith(t, free, free, RequestPayload{Namespace: "batch-a", CPU: 2, RAM: 2, ExcludeClusters: tt.reqExclude})
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.wantStatus)
+			continue
+		}
+		var resp ResponsePayload
+		if tt.want != "" {
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp.Cluster != tt.want {
+				t.Errorf("%s: cluster %q (%v), want %q", tt.name, resp.Cluster, err, tt.want)
+			}
+		}
+	}
+}
+
+func TestCheckQueryTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		wantErr bool
+	}{
+		{"built-in CPU", defaultCPUQuery, false},
+		{"built-in RAM", defaultRAMQuery, false},
+		{"two verbs", `free{namespace="%s"} - used{namespace="%s"}`, false},
+		{"modulo", `free{namespace="%s"} %% 2 + used{namespace="%s"}`, false},
+		{"one verb", `free{namespace="%s"}`, true},
+		{"three verbs", `free{namespace="%s"} - used{namespace="%s"} - held{namespace="%s"}`, true},
+		{"no verb", `free{namespace="$namespace"}`, true},
+		{"other verb", `free{namespace="%s"} - used{namespace="%d"}`, true},
+		{"lone percent", `free{namespace="%s"} - used{namespace="%s"} %`, true},
+	}
+	for _, tt := range tests {
+		if err := checkQueryTemplate(tt.query); (err != nil) != tt.wantErr {
+			t.Errorf("%s: checkQueryTemplate(%q) = %v, wantErr %v", tt.name, tt.query, err, tt.wantErr)
+		}
+	}
+}
+
+func TestNoFitReason(t *testing.T) {
+	defer func() {
+		cfg = Config{}
+		cache = resourceCache{entries: make(map[cacheKey]cacheEntry)}
+	}()
+	tests := []struct {
+		name       string
+		cpus, mems map[string]float64
+		exclude    []string
+		detailed   bool
+		wantStatus int
+		want       NoFitReason
+	}{
+		{"short of CPU", map[string]float64{"east": 1}, map[string]float64{"east": 64}, nil, false, http.StatusNotFound,
+			NoFitReason{ClustersSeen: 1, InsufficientCPU: 1}},
+		{"short of RAM", map[string]float64{"east": 16}, map[string]float64{"east": 2}, nil, false, http.StatusNotFound,
+			NoFitReason{ClustersSeen: 1, InsufficientRAM: 1}},
+		{"short of both", map[string]float64{"east": 1}, map[string]float64{"east": 2}, nil, false, http.StatusNotFound,
+			NoFitReason{ClustersSeen: 1, InsufficientAll: 1}},
+		{"one of each", map[string]float64{"a": 1, "b": 16, "c": 1}, map[string]float64{"a": 64, "b": 2, "c": 2}, nil, true, http.StatusInsufficientStorage,
+			NoFitReason{ClustersSeen: 3, InsufficientCPU: 1, InsufficientRAM: 1, InsufficientAll: 1}},
+		{"no clusters reported", map[string]float64{}, map[string]float64{}, nil, false, http.StatusNotFound,
+			NoFitReason{}},
+		{"no clusters reported, detailed", map[string]float64{}, map[string]float64{}, nil, true, http.StatusNotFound,
+			NoFitReason{}},
+		{"all excluded", map[string]float64{"east": 16}, map[string]float64{"east": 64}, []string{"east"}, true, http.StatusServiceUnavailable,
+			NoFitReason{Excluded: 1}},
+	}
+	for _, tt := range tests {
+		cfg = Config{
+			NamespacePattern: regexp.MustCompile(`^[a-z0-9-]+$`), ClusterLabel: "cluster", MemoryUnit: memoryGB,
+			CacheTTL: time.Hour, Strategy: strategyRandom, TieBreaker: tieName, DetailedStatus: tt.detailed,
+		}
+		w := placeWith(t, tt.cpus, tt.mems, RequestPayload{Namespace: "batch-a", CPU: 8, RAM: 32, ExcludeClusters: tt.exclude})
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: answered %d, want %d", tt.name, w.Code, tt.wantStatus)
+		}
+		var got NotFoundPayload
+		if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got.Reason != tt.want {
+			t.Errorf("%s: reason %+v, want %+v", tt.name, got.Reason, tt.want)
+		}
+	}
+}
+
+func TestPreferredCluster(t *testing.T) {
+	defer func() {
+		cfg = Config{}
+		cache = resourceCache{entries: make(map[cacheKey]cacheEntry)}
+	}()
+	// Without a preference the cluster with the most free CPU wins
+	cfg = Config{
+		NamespacePattern: regexp.MustCompile(`^[a-z0-9-]+$`), ClusterLabel: "cluster", MemoryUnit: memoryGB,
+		CacheTTL: time.Hour, Strategy: strategyMostCPU, TieBreaker: tieName,
+	}
+	tests := []struct {
+		name      string
+		cpus      map[string]float64
+		preferred string
+		want      string
+	}{
+		{"no preference", map[string]float64{"big": 32, "small": 8}, "", "big"},
+		{"preferred fits", map[string]float64{"big": 32, "small": 8}, "small", "small"},
+		{"preferred too small", map[string]float64{"big": 32, "small": 2}, "small", "big"},
+		{"preferred not reported", map[string]float64{"big": 32, "small": 8}, "gone", "big"},
+	}
+	for _, tt := range tests {
+		w := placeWith(t, tt.cpus, map[string]float64{"big": 64, "small": 64}, RequestPayload{Namespace: "batch-a", CPU: 4, RAM: 8, PreferredCluster: tt.preferred})
+		var got ResponsePayload
+		if err := json.NewDecoder(w.Body).Decode(&got); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("%s: answered %d (%v)", tt.name, w.Code, err)
+		}
+		if got.Cluster != tt.want {
+			t.Errorf("%s: placed on %s, want %s", tt.name, got.Cluster, tt.want)
+		}
+	}
+}
+
+func TestCustomClusterLabel(t *testing.T) {
+	defer func() { cfg = Config{} }()
+	var queries []string
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.Query().Get("query"))
+		// Series without the configured label can't be told apart and are skipped
+		w.Write([]byte(`{"status": "success", "data": {"result": [
+			{"metric": {"cluster_name": "east", "cluster": "prom-1"}, "value": [0, "4"]},
+			{"metric": {"cluster_name": "west"}, "value": [0, "2"]},
+			{"metric": {"cluster": "prom-2"}, "value": [0, "8"]}
+		]}}`))
+	}))
+	defer prom.Close()
+	cfg = Config{PrometheusURL: prom.URL, ClusterLabel: "cluster_name", MemoryUnit: memoryGB}
+
+	for _, q := range placementQueries() {
+		got, err := fetchResources(context.Background(), prom.URL, "batch-a", q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := map[string]float64{"east": 4, "west": 2}; !maps.Equal(got, want) {
+			t.Errorf("%s: got %v, want %v", q.Name, got, want)
+		}
+	}
+	// The default queries join CPU and RAM on the configured label
+	for _, q := range queries {
+		if !strings.Contains(q, "on(cluster_name)") || strings.Contains(q, "on(cluster)") {
+			t.Errorf("query %q is not matched on cluster_name", q)
+		}
+	}
+}
+
+func TestNamespaceOwners(t *testing.T) {
+	defer func() {
+		cfg = Config{}
+		owners.byCluster = make(map[string]string)
+	}()
+	var onTeam atomic.Bool
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The owner label survives the CPU/RAM join only when it is listed in on(...)
+		onTeam.Store(strings.Contains(r.URL.Query().Get("query"), "on(cluster, team)"))
+		w.Write([]byte(`{"status": "success", "data": {"result": [
+			{"metric": {"cluster": "a-1", "team": "team-a"}, "value": [0, "16"]},
+			{"metric": {"cluster": "a-2", "team": "team-a"}, "value": [0, "2"]},
+			{"metric": {"cluster": "b-1", "team": "team-b"}, "value": [0, "64"]}
+		]}}`))
+	}))
+	defer prom.Close()
+	cfg = Config{
+		PrometheusURL: prom.URL, NamespacePattern: regexp.MustCompile(`^[a-z0-9-]+$`), ClusterLabel: "cluster", MemoryUnit: memoryGB,
+		Strategy: strategyMostCPU, TieBreaker: tieName, DetailedStatus: true,
+		OwnerLabel: "team", NamespaceOwners: []KeyValue{{Key: "a-*", Value: "team-a"}, {Key: "b-etl", Value: "team-b"}},
+	}
+	tests := []struct {
+		name        string
+		namespace   string
+		cpu         float64
+		wantCluster string
+		wantReason  NoFitReason
+	}{
+		{"restricted to its team", "a-etl", 4, "a-1", NoFitReason{}},
+		{"other team", "b-etl", 4, "b-1", NoFitReason{}},
+		{"unmapped namespace may use any cluster", "shared", 4, "b-1", NoFitReason{}},
+		{"nothing of its team fits", "a-etl", 32, "", NoFitReason{ClustersSeen: 2, InsufficientCPU: 2, Excluded: 1}},
+	}
+	for _, tt := range tests {
+		body, _ := json.Marshal(RequestPayload{Namespace: tt.namespace, CPU: tt.cpu, RAM: 1})
+		w := httptest.NewRecorder()
+		handleRequest(w, httptest.NewRequest(http.MethodPost, "/api/request", bytes.NewReader(body)))
+		if !onTeam.Load() {
+			t.Errorf("%s: queries are not joined on the owner label", tt.name)
+		}
+		if tt.wantCluster == "" {
+			var got NotFoundPayload
+			json.NewDecoder(w.Body).Decode(&got)
+			if w.Code == http.StatusOK || got.Reason != tt.wantReason {
+				t.Errorf("%s: answered %d with reason %+v, want %+v", tt.name, w.Code, got.Reason, tt.wantReason)
+			}
+			continue
+		}
+		var got ResponsePayload
+		if err := json.NewDecoder(w.Body).Decode(&got); err != nil || got.Cluster != tt.wantCluster {
+			t.Errorf("%s: answered %d, placed on %q, want %q", tt.name, w.Code, got.Cluster, tt.wantCluster)
+		}
+	}
+}
+
+func TestNamespaceInjection(t *testing.T) {
+	defer func() {
+		cfg = Config{}
+		cache = resourceCache{entries: make(map[cacheKey]cacheEntry)}
+	}()
+	var queries atomic.Int32
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries.Add(1)
+		w.Write([]byte(`{"status": "success", "data": {"result": []}}`))
+	}))
+	defer prom.Close()
+	// The default MEDEA_SCOUT_NAMESPACE_PATTERN
+	cfg = Config{
+		PrometheusURL: prom.URL, NamespacePattern: regexp.MustCompile(`^(?:[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?)$`),
+		ClusterLabel: "cluster", MemoryUnit: memoryGB, Strategy: strategyRandom, TieBreaker: tieName,
+	}
+	for _, ns := range []string{
+		`batch-a"} or vector(1) or x{namespace="`,
+		`batch-a",cluster="east`,
+		`.*`,
+		`batch-a\`,
+		`batch-a"}[5m]`,
+		"batch-a\n",
+		strings.Repeat("a", 64),
+		"Batch-A",
+		"",
+	} {
+		body, _ := json.Marshal(RequestPayload{Namespace: ns, CPU: 1, RAM: 1})
+		w := httptest.NewRecorder()
+		handleRequest(w, httptest.NewRequest(http.MethodPost, "/api/request", bytes.NewReader(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("namespace %q answered %d, want 400", ns, w.Code)
+		}
+	}
+	if n := queries.Load(); n != 0 {
+		t.Errorf("Prometheus queried %d times for refused namespaces", n)
+	}
+
+	// Escaping keeps whatever a looser pattern lets through inside the label value
+	tests := []struct {
+		namespace string
+		want      string
+	}{
+		{"batch-a", `namespace="batch-a"`},
+		{`a"} or vector(1) or x{namespace="`, `namespace="a\"} or vector(1) or x{namespace=\""`},
+		{`a\`, `namespace="a\\"`},
+		{"a\nb", `namespace="a\nb"`},
+	}
+	for _, tt := range tests {
+		if got := `namespace="` + promLabelValue(tt.namespace) + `"`; got != tt.want {
+			t.Errorf("%q escaped to %s, want %s", tt.namespace, got, tt.want)
+		}
+	}
+}
+
+func TestNoFitRetryAfter(t *testing.T) {
+	defer func() {
+		cfg = Config{}
+		cache = resourceCache{entries: make(map[cacheKey]cacheEntry)}
+	}()
+	tests := []struct {
+		name       string
+		retryAfter time.Duration
+		detailed   bool
+		exclude    []string
+		cpu        float64
+		wantStatus int
+		want       string
+	}{
+		{"no fit", 30 * time.Second, false, nil, 32, http.StatusNotFound, "30"},
+		{"rounded up to whole seconds", 1500 * time.Millisecond, false, nil, 32, http.StatusNotFound, "2"},
+		{"all excluded", 30 * time.Second, true, []string{"east"}, 1, http.StatusServiceUnavailable, "30"},
+		{"disabled", 0, false, nil, 32, http.StatusNotFound, ""},
+		{"placed", 30 * time.Second, false, nil, 1, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		cfg = Config{
+			NamespacePattern: regexp.MustCompile(`^[a-z0-9-]+$`), ClusterLabel: "cluster", MemoryUnit: memoryGB,
+			CacheTTL: time.Hour, Strategy: strategyRandom, TieBreaker: tieName, DetailedStatus: tt.detailed, RetryAfter: tt.retryAfter,
+		}
+		w := placeWith(t, map[string]float64{"east": 16}, map[string]float64{"east": 64}, RequestPayload{Namespace: "batch-a", CPU: tt.cpu, RAM: 1, ExcludeClusters: tt.exclude})
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: answered %d, want %d", tt.name, w.Code, tt.wantStatus)
+		}
+		if got := w.Header().Get("Retry-After"); got != tt.want {
+			t.Errorf("%s: Retry-After %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFallbackQuery(t *testing.T) {
+	defer func() {
+		cfg = Config{}
+		queryErrors = queryErrorLog{byQuery: make(map[string]*QueryErrorStats)}
+	}()
+	// SCOUT_CPU_FALLBACK_QUERY and SCOUT_RAM_FALLBACK_QUERY reach the placement queries
+	cfg = Config{ClusterLabel: "cluster", MemoryUnit: memoryGB, CPUFallbackQuery: "raw cpu", RAMFallbackQuery: "raw ram"}
+	if qs := placementQueries(); qs[0].Fallback != "raw cpu" || qs[1].Fallback != "raw ram" {
+		t.Errorf("placement queries %+v, want the configured fallbacks", qs)
+	}
+	q := promQuery{
+		Name:     "cpu",
+		Template: `rule_cpu{namespace="%s"} or rule_cpu{namespace="%s"}`,
+		Fallback: `raw_cpu{namespace="%s"} or raw_cpu{namespace="%s"}`,
+	}
+	answer := func(w http.ResponseWriter, a string) {
+		switch a {
+		case "error":
+			http.Error(w, "down", http.StatusInternalServerError)
+		case "empty":
+			w.Write([]byte(`{"status": "success", "data": {"result": []}}`))
+		default:
+			fmt.Fprintf(w, `{"status": "success", "data": {"result": [{"metric": {"cluster": "east"}, "value": [0, %q]}]}}`, a)
+		}
+	}
+
+	tests := []struct {
+		name              string
+		primary, fallback string // answers: error, empty or a value
+		noFallback        bool
+		want              float64
+		wantErr           bool
+		wantQueries       []string
+		wantErrors        []string
+	}{
+		{"primary works", "4", "8", false, 4, false, []string{"rule_cpu"}, nil},
+		{"primary fails", "error", "8", false, 8, false, []string{"rule_cpu", "raw_cpu"}, []string{"cpu"}},
+		{"primary is empty", "empty", "8", false, 8, false, []string{"rule_cpu", "raw_cpu"}, nil},
+		{"both fail", "error", "error", false, 0, true, []string{"rule_cpu", "raw_cpu"}, []string{"cpu", "cpu-fallback"}},
+		{"no fallback configured", "error", "8", true, 0, true, []string{"rule_cpu"}, []string{"cpu"}},
+	}
+	for _, tt := range tests {
+		var queries []string
+		prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			name, _, _ := strings.Cut(r.URL.Query().Get("query"), "{")
+			queries = append(queries, name)
+			if name == "raw_cpu" {
+				answer(w, tt.fallback)
+			} else {
+				answer(w, tt.primary)
+			}
+		}))
+		queryErrors = queryErrorLog{byQuery: make(map[string]*QueryErrorStats)}
+		query := q
+		if tt.noFallback {
+			query.Fallback = ""
+		}
+		got, err := fetchResources(context.Background(), prom.URL, "batch-a", query)
+		prom.Close()
+		if (err != nil) != tt.wantErr || got["east"] != tt.want {
+			t.Errorf("%s: got %v, %v, want %v", tt.name, got, err, tt.want)
+		}
+		if !slices.Equal(queries, tt.wantQueries) {
+			t.Errorf("%s: queried %v, want %v", tt.name, queries, tt.wantQueries)
+		}
+		// Failures of the primary and the fallback are told apart
+		if recorded := slices.Sorted(maps.Keys(queryErrors.snapshot())); !slices.Equal(recorded, tt.wantErrors) {
+			t.Errorf("%s: errors recorded for %v, want %v", tt.name, recorded, tt.wantErrors)
+		}
+	}
+}
+
+func TestPlacementToken(t *testing.T) {
+	defer func() {
+		cfg = Config{}
+		cache = resourceCache{entries: make(map[cacheKey]cacheEntry)}
+		tokens = placementTokens{tokens: make(map[string]tokenPlacement)}
+	}()
+	cpus := map[string]float64{"a": 16, "b": 16, "c": 4, "d": 16, "e": 16, "f": 16, "g": 16, "h": 16}
+	mems := map[string]float64{"a": 64, "b": 64, "c": 64, "d": 64, "e": 64, "f": 64, "g": 64, "h": 64}
+	place := func(req RequestPayload) string {
+		t.Helper()
+		var got ResponsePayload
+		w := placeWith(t, cpus, mems, req)
+		if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+			t.Fatalf("%+v answered %d: %v", req, w.Code, err)
+		}
+		return got.Cluster
+	}
+
+	// Random picks would spread these over eight clusters, the token keeps them together
+	cfg = Config{
+		NamespacePattern: regexp.MustCompile(`^[a-z0-9-]+$`), ClusterLabel: "cluster", MemoryUnit: memoryGB,
+		CacheTTL: time.Hour, TokenTTL: time.Hour, Strategy: strategyRandom, TieBreaker: tieRandom,
+	}
+	first := place(RequestPayload{Namespace: "batch-a", CPU: 1, RAM: 1, PlacementToken: "group-1"})
+	for i := range 20 {
+		if got := place(RequestPayload{Namespace: "batch-b", CPU: 1, RAM: 1, PlacementToken: "group-1"}); got != first {
+			t.Fatalf("member %d of group-1 went to %s, the group is on %s", i, got, first)
+		}
+	}
+
+	// With the name tie-breaker a fresh pick is always a
+	cfg.TieBreaker, cfg.TokenTTL = tieName, 50*time.Millisecond
+	tests := []struct {
+		name  string
+		bound string // cluster the token is bound to, empty for none
+		wait  time.Duration
+		req   RequestPayload
+		want  string
+		// cluster the token resolves to afterwards, empty for none
+		wantBound string
+	}{
+		{"bound token", "c", 0, RequestPayload{CPU: 1, RAM: 1}, "c", "c"},
+		{"expired token", "c", 100 * time.Millisecond, RequestPayload{CPU: 1, RAM: 1}, "a", "a"},
+		{"bound cluster no longer fits", "c", 0, RequestPayload{CPU: 8, RAM: 1}, "a", "a"},
+		{"dry-run binds nothing", "", 0, RequestPayload{CPU: 1, RAM: 1, DryRun: true}, "a", ""},
+	}
+	for _, tt := range tests {
+		tokens = placementTokens{tokens: make(map[string]tokenPlacement)}
+		if tt.bound != "" {
+			tokens.set("group-2", tt.bound, cfg.TokenTTL)
+		}
+		time.Sleep(tt.wait)
+		tt.req.Namespace, tt.req.PlacementToken = "batch-a", "group-2"
+		if got := place(tt.req); got != tt.want {
+			t.Errorf("%s: placed on %s, want %s", tt.name, got, tt.want)
+		}
+		if got, _ := tokens.get("group-2"); got != tt.wantBound {
+			t.Errorf("%s: token bound to %q, want %q", tt.name, got, tt.wantBound)
+		}
+	}
+}
+
+func TestFetchResourcesAbortsOnCancel(t *testing.T) {
+	defer func() { cfg = Config{} }()
+	cfg = Config{ClusterLabel: "cluster"}
+	got, aborted := make(chan struct{}, 1), make(chan struct{}, 1)
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- struct{}{}
+		select {
+		case <-r.Context().Done():
+			aborted <- struct{}{}
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	defer prom.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := fetchResources(ctx, prom.URL, "batch-a", promQuery{Name: "cpu", Template: `x{namespace="%s"} or x{namespace="%s"}`})
+		done <- err
+	}()
+	<-got
+	// The balancer's request is cancelled, and with it the query
+	cancel()
+	select {
+	case <-aborted:
+	case <-time.After(5 * time.Second):
+		t.Error("the Prometheus query kept going after the request was cancelled")
+	}
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("fetchResources = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	freeCPUGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "medea_scout_free_cpu",
+		Help: "Free CPU per cluster and namespace as seen by the last placement, after reservations.",
+	}, []string{"cluster", "namespace"})
+	freeRAMGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "medea_scout_free_ram_gb",
+		Help: "Free RAM in GB per cluster and namespace as seen by the last placement, after reservations.",
+	}, []string{"cluster", "namespace"})
+	feedbackTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "medea_scout_feedback_total",
+		Help: "Placement outcomes reported through /api/feedback, by outcome.",
+	}, []string{"outcome"})
+	cacheHitsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "medea_scout_cache_hits_total",
+		Help: "Prometheus lookups answered from the cache within SCOUT_CACHE_TTL.",
+	})
+)
+
+// capacityNamespaces caps the namespaces exported in the capacity gauges.
+// The first MEDEA_SCOUT_METRICS_MAX_NAMESPACES namespaces are exported, later ones are not.
+var capacityNamespaces = struct {
+	mu   sync.Mutex
+	seen map[string]bool
+}{seen: make(map[string]bool)}
+
+func exportNamespace(ns string) bool {
+	capacityNamespaces.mu.Lock()
+	defer capacityNamespaces.mu.Unlock()
+	if capacityNamespaces.seen[ns] {
+		return true
+	}
+	if len(capacityNamespaces.seen) >= cfg.MetricsMaxNamespaces {
+		return false
+	}
+	capacityNamespaces.seen[ns] = true
+	return true
+}
+
+// recordCapacity updates the gauges of one candidate cluster; the RAM gauge is always in GB
+func recordCapacity(cluster, ns string, freeCPU, freeRAM float64) {
+	freeCPUGauge.WithLabelValues(cluster, ns).Set(freeCPU)
+	if cfg.MemoryUnit == memoryMiB {
+		freeRAM /= 1024
+	}
+	freeRAMGauge.WithLabelValues(cluster, ns).Set(freeRAM)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"maps"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// gaugeValues returns the series of a gauge vector by "cluster/namespace"
+func gaugeValues(t *testing.T, vec *prometheus.GaugeVec) map[string]float64 {
+	t.Helper()
+	ch := make(chan prometheus.Metric)
+	go func() {
+		vec.Collect(ch)
+		close(ch)
+	}()
+	values := make(map[string]float64)
+	for m := range ch {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		labels := make(map[string]string)
+		for _, l := range pb.GetLabel() {
+			labels[l.GetName()] = l.GetValue()
+		}
+		values[labels["cluster"]+"/"+labels["namespace"]] = pb.GetGauge().GetValue()
+	}
+	return values
+}
+
+func TestCapacityGauges(t *testing.T) {
+	reset := func() {
+		freeCPUGauge.Reset()
+		freeRAMGauge.Reset()
+		capacityNamespaces.seen = make(map[string]bool)
+		cache = resourceCache{entries: make(map[cacheKey]cacheEntry)}
+		reservations = reservationStore{byCluster: make(map[string][]Reservation)}
+	}
+	defer func() {
+		reset()
+		cfg = Config{}
+	}()
+	cpus, mems := map[string]float64{"east": 8, "west": 4}, map[string]float64{"east": 16384, "west": 2048}
+	tests := []struct {
+		name       string
+		metrics    bool
+		namespaces []string
+		exclude    []string
+		want       map[string][2]float64 // cluster/namespace: free CPU, free RAM in GB
+	}{
+		{"disabled", false, []string{"batch-a"}, nil, map[string][2]float64{}},
+		{"fetched values after reservations", true, []string{"batch-a"}, nil, map[string][2]float64{
+			"east/batch-a": {6, 12}, "west/batch-a": {4, 2},
+		}},
+		{"excluded clusters are not reported", true, []string{"batch-a"}, []string{"west"}, map[string][2]float64{
+			"east/batch-a": {6, 12},
+		}},
+		{"namespaces beyond the cap are not exported", true, []string{"batch-a", "batch-b", "batch-c", "batch-a"}, []string{"west"}, map[string][2]float64{
+			"east/batch-a": {6, 12}, "east/batch-b": {8, 16},
+		}},
+	}
+	for _, tt := range tests {
+		reset()
+		// RAM comes in MiB from Prometheus, the gauge is in GB
+		cfg = Config{
+			NamespacePattern: regexp.MustCompile(`^[a-z0-9-]+$`), ClusterLabel: "cluster", MemoryUnit: memoryMiB,
+			CacheTTL: time.Hour, Strategy: strategyRandom, TieBreaker: tieName, ReservationTTL: time.Hour,
+			CapacityMetrics: tt.metrics, MetricsMaxNamespaces: 2,
+		}
+		reservations.add("east", Reservation{Namespace: "batch-a", CPU: 2, RAM: 4096, Expires: time.Now().Add(time.Hour)})
+		for _, ns := range tt.namespaces {
+			// Nothing fits, so the request reserves nothing that would change the next one
+			if w := placeWith(t, cpus, mems, RequestPayload{Namespace: ns, CPU: 100, RAM: 1, ExcludeClusters: tt.exclude}); w.Code == http.StatusOK {
+				t.Fatalf("%s: request for %s fit", tt.name, ns)
+			}
+		}
+		gotCPU, gotRAM := gaugeValues(t, freeCPUGauge), gaugeValues(t, freeRAMGauge)
+		want := make(map[string]float64)
+		for series, v := range tt.want {
+			want[series] = v[0]
+		}
+		if !maps.Equal(gotCPU, want) {
+			t.Errorf("%s: free CPU %v, want %v", tt.name, gotCPU, want)
+		}
+		for series, v := range tt.want {
+			want[series] = v[1]
+		}
+		if !maps.Equal(gotRAM, want) {
+			t.Errorf("%s: free RAM %v, want %v", tt.name, gotRAM, want)
+		}
+	}
+}
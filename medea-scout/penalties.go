@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Placement outcomes the balancer reports through the feedback endpoint
+const (
+	outcomeSuccess = "success"
+	outcomeFailure = "failure"
+)
+
+// failurePenalties remembers the submit failures reported per cluster. Each failure takes
+// MEDEA_SCOUT_FAILURE_PENALTY of the cluster's free capacity away, decaying linearly to
+// nothing over MEDEA_SCOUT_PENALTY_WINDOW.
+type failurePenalties struct {
+	mu       sync.Mutex
+	failures map[string][]time.Time
+}
+
+// Failures reported to this scout instance, only used when MEDEA_SCOUT_FAILURE_PENALTY is set
+var penalties = failurePenalties{failures: make(map[string][]time.Time)}
+
+func (p *failurePenalties) record(cluster string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.failures[cluster] = append(p.failures[cluster], time.Now())
+}
+
+// factor returns the share of capacity to keep on cluster: 1 without recent failures,
+// down to 0 when the decayed penalties add up to the whole capacity
+func (p *failurePenalties) factor(cluster string) float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	ts := p.failures[cluster]
+	i := 0
+	for i < len(ts) && now.Sub(ts[i]) >= cfg.PenaltyWindow {
+		i++
+	}
+	if i == len(ts) {
+		delete(p.failures, cluster)
+		return 1
+	}
+	p.failures[cluster] = ts[i:]
+
+	var penalty float64
+	for _, t := range ts[i:] {
+		penalty += cfg.FailurePenalty * (1 - float64(now.Sub(t))/float64(cfg.PenaltyWindow))
+	}
+	return max(0, 1-penalty)
+}
+
+// penalized reduces free capacity by the cluster's penalty, a shortfall stays as it is
+func penalized(free, factor float64) float64 {
+	if free <= 0 {
+		return free
+	}
+	return free * factor
+}
+
+// Feedback reports how a submit to the cluster scout picked went
+type Feedback struct {
+	Cluster   string `json:"cluster"`
+	Namespace string `json:"namespace"`
+	Outcome   string `json:"outcome"`
+}
+
+// handleFeedback records placement outcomes. A failure frees the capacity reserved for the
+// placement and penalizes the cluster when MEDEA_SCOUT_FAILURE_PENALTY is set; successes are
+// only counted.
+func handleFeedback(w http.ResponseWriter, r *http.Request) {
+	r = withRequestID(r)
+	var fb Feedback
+	if err := json.NewDecoder(r.Body).Decode(&fb); err != nil || fb.Cluster == "" {
+		http.Error(w, "Expected {\"cluster\": \"<cluster>\", \"namespace\": \"<namespace>\", \"outcome\": \"success|failure\"}", http.StatusBadRequest)
+		return
+	}
+	switch fb.Outcome {
+	case outcomeSuccess:
+	case outcomeFailure:
+		// The workflow never started, so nothing will show up in Prometheus for its reservation
+		if cfg.ReservationTTL > 0 && reservations.release(fb.Cluster, fb.Namespace) {
+			slog.InfoContext(r.Context(), "Released the reservation after a failed submit", "cluster", fb.Cluster, "namespace", fb.Namespace)
+		}
+		if cfg.FailurePenalty > 0 {
+			penalties.record(fb.Cluster)
+			slog.WarnContext(r.Context(), "Submit failure reported, penalizing cluster", "cluster", fb.Cluster, "namespace", fb.Namespace, "window", cfg.PenaltyWindow.String())
+		}
+	default:
+		http.Error(w, "outcome must be success or failure", http.StatusBadRequest)
+		return
+	}
+	feedbackTotal.WithLabelValues(fb.Outcome).Inc()
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleFeedback(t *testing.T) {
+	defer func() {
+		cfg = Config{}
+		reservations = reservationStore{byCluster: make(map[string][]Reservation)}
+		penalties = failurePenalties{failures: make(map[string][]time.Time)}
+	}()
+	tests := []struct {
+		name         string
+		body         string
+		wantStatus   int
+		wantReserved float64 // CPU still reserved on east for batch-a
+		wantPenalty  bool
+	}{
+		{"success", `{"cluster": "east", "namespace": "batch-a", "outcome": "success"}`, http.StatusNoContent, 2, false},
+		{"failure", `{"cluster": "east", "namespace": "batch-a", "outcome": "failure"}`, http.StatusNoContent, 1, true},
+		{"failure of another namespace", `{"cluster": "east", "namespace": "batch-b", "outcome": "failure"}`, http.StatusNoContent, 2, true},
+		{"unknown outcome", `{"cluster": "east", "namespace": "batch-a", "outcome": "maybe"}`, http.StatusBadRequest, 2, false},
+		{"no cluster", `{"namespace": "batch-a", "outcome": "failure"}`, http.StatusBadRequest, 2, false},
+		{"invalid JSON", `{"cluster": `, http.StatusBadRequest, 2, false},
+	}
+	for _, tt := range tests {
+		cfg = Config{ReservationTTL: time.Minute, FailurePenalty: 0.5, PenaltyWindow: time.Hour}
+		reservations = reservationStore{byCluster: make(map[string][]Reservation)}
+		for range 2 {
+			reservations.add("east", Reservation{Namespace: "batch-a", CPU: 1, RAM: 1, Expires: time.Now().Add(time.Minute)})
+		}
+		penalties = failurePenalties{failures: make(map[string][]time.Time)}
+
+		w := httptest.NewRecorder()
+		handleFeedback(w, httptest.NewRequest(http.MethodPost, "/api/feedback", strings.NewReader(tt.body)))
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: answered %d, want %d", tt.name, w.Code, tt.wantStatus)
+		}
+		// A failure releases the reservation of its placement only
+		if cpu, _ := reservations.held("east", "batch-a"); cpu != tt.wantReserved {
+			t.Errorf("%s: %v CPU reserved, want %v", tt.name, cpu, tt.wantReserved)
+		}
+		if penalized := penalties.factor("east") < 1; penalized != tt.wantPenalty {
+			t.Errorf("%s: east penalized %v, want %v", tt.name, penalized, tt.wantPenalty)
+		}
+	}
+}
+
+func TestPenaltyFactorDecays(t *testing.T) {
+	defer func() {
+		cfg = Config{}
+		penalties = failurePenalties{failures: make(map[string][]time.Time)}
+	}()
+	cfg = Config{FailurePenalty: 0.5, PenaltyWindow: time.Hour}
+	tests := []struct {
+		name     string
+		failures []time.Duration // ago
+		want     float64
+	}{
+		{"no failures", nil, 1},
+		{"fresh failure", []time.Duration{0}, 0.5},
+		{"half decayed", []time.Duration{30 * time.Minute}, 0.75},
+		{"outside the window", []time.Duration{2 * time.Hour}, 1},
+		{"penalties add up", []time.Duration{0, 30 * time.Minute}, 0.25},
+		{"capped at nothing left", []time.Duration{0, 0, 0}, 0},
+	}
+	for _, tt := range tests {
+		now := time.Now()
+		penalties = failurePenalties{failures: make(map[string][]time.Time)}
+		for _, ago := range tt.failures {
+			penalties.failures["east"] = append(penalties.failures["east"], now.Add(-ago))
+		}
+		if got := penalties.factor("east"); math.Abs(got-tt.want) > 0.01 {
+			t.Errorf("%s: factor %v, want %v", tt.name, got, tt.want)
+		}
+		if got := penalized(10, penalties.factor("east")); math.Abs(got-10*tt.want) > 0.1 {
+			t.Errorf("%s: 10 free penalized to %v, want %v", tt.name, got, 10*tt.want)
+		}
+	}
+	// A shortfall is not made smaller by the penalty
+	if got := penalized(-2, 0.5); got != -2 {
+		t.Errorf("penalized(-2) = %v, want -2", got)
+	}
+}
+
+func TestFailureFeedbackShiftsPlacement(t *testing.T) {
+	defer func() {
+		cfg = Config{}
+		cache = resourceCache{entries: make(map[cacheKey]cacheEntry)}
+		tokens = placementTokens{tokens: make(map[string]tokenPlacement)}
+		penalties = failurePenalties{failures: make(map[string][]time.Time)}
+	}()
+	cfg = Config{
+		NamespacePattern: regexp.MustCompile(`^[a-z0-9-]+$`), ClusterLabel: "cluster", MemoryUnit: memoryGB,
+		CacheTTL: time.Hour, TokenTTL: time.Hour, Strategy: strategyMostCPU, TieBreaker: tieName,
+		FailurePenalty: 0.5, PenaltyWindow: time.Hour,
+	}
+	penalties = failurePenalties{failures: make(map[string][]time.Time)}
+	free := map[string]float64{"east": 10, "west": 8}
+	place := func() string {
+		t.Helper()
+		w := placeWith(t, free, free, RequestPayload{Namespace: "batch-a", CPU: 2, RAM: 2})
+		var resp ResponsePayload
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("placement answered %d: %s", w.Code, w.Body)
+		}
+		return resp.Cluster
+	}
+
+	if got := place(); got != "east" {
+		t.Fatalf("before any failure placed on %q, want east", got)
+	}
+	w := httptest.NewRecorder()
+	handleFeedback(w, httptest.NewRequest(http.MethodPost, "/api/feedback", strings.NewReader(`{"cluster": "east", "namespace": "batch-a", "outcome": "failure"}`)))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("feedback answered %d: %s", w.Code, w.Body)
+	}
+	// East looks half its size right after the failure and is no longer the best fit
+	if got := place(); got != "west" {
+		t.Errorf("right after the failure placed on %q, want west", got)
+	}
+
+	// Halfway through the window east is at 7.5 of 10, still smaller than west
+	penalties.failures["east"] = []time.Time{time.Now().Add(-30 * time.Minute)}
+	if got := place(); got != "west" {
+		t.Errorf("halfway through the window placed on %q, want west", got)
+	}
+	// Late in the window east is at 9.5 and wins again
+	penalties.failures["east"] = []time.Time{time.Now().Add(-54 * time.Minute)}
+	if got := place(); got != "east" {
+		t.Errorf("late in the window placed on %q, want east", got)
+	}
+	// Once the window has passed the failure is forgotten
+	penalties.failures["east"] = []time.Time{time.Now().Add(-2 * time.Hour)}
+	if got := place(); got != "east" {
+		t.Errorf("after the window placed on %q, want east", got)
+	}
+	if f := penalties.factor("east"); f != 1 {
+		t.Errorf("east factor %v after the window, want 1", f)
+	}
+}
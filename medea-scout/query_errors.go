@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var queryErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "medea_scout_prometheus_query_errors_total",
+	Help: "Failed Prometheus queries, by dimension (cpu, ram, cpu-fallback, ram-fallback).",
+}, []string{"query"})
+
+// QueryErrorStats summarizes the failures of one query
+type QueryErrorStats struct {
+	Count     int       `json:"count"`
+	LastError string    `json:"lastError"`
+	LastTime  time.Time `json:"lastTime"`
+}
+
+// queryErrorLog keeps the failure count and last error per query since start
+type queryErrorLog struct {
+	mu      sync.Mutex
+	byQuery map[string]*QueryErrorStats
+}
+
+// Errors of the Prometheus queries made by this scout instance
+var queryErrors = queryErrorLog{byQuery: make(map[string]*QueryErrorStats)}
+
+func (l *queryErrorLog) record(query string, err error) {
+	queryErrorsTotal.WithLabelValues(query).Inc()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	st, ok := l.byQuery[query]
+	if !ok {
+		st = &QueryErrorStats{}
+		l.byQuery[query] = st
+	}
+	st.Count++
+	st.LastError = err.Error()
+	st.LastTime = time.Now().UTC()
+}
+
+func (l *queryErrorLog) snapshot() map[string]QueryErrorStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make(map[string]QueryErrorStats, len(l.byQuery))
+	for q, st := range l.byQuery {
+		out[q] = *st
+	}
+	return out
+}
+
+// handleQueryErrors shows the failure count and last error of each Prometheus query
+func handleQueryErrors(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(queryErrors.snapshot())
+}
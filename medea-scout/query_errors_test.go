@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueryErrorsRecorded(t *testing.T) {
+	defer func() {
+		cfg = Config{}
+		queryErrors = queryErrorLog{byQuery: make(map[string]*QueryErrorStats)}
+	}()
+	queryErrors = queryErrorLog{byQuery: make(map[string]*QueryErrorStats)}
+	// Only the RAM query fails
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Query().Get("query"), "free_ram") {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write([]byte(`{"status": "error", "errorType": "execution", "error": "no recording rule"}`))
+			return
+		}
+		w.Write([]byte(`{"status": "success", "data": {"result": [{"metric": {"cluster": "east"}, "value": [0, "4"]}]}}`))
+	}))
+	defer prom.Close()
+	cfg = Config{
+		PrometheusURL: prom.URL, NamespacePattern: regexp.MustCompile(`^[a-z0-9-]+$`), ClusterLabel: "cluster", MemoryUnit: memoryGB,
+		Strategy: strategyRandom, TieBreaker: tieName,
+		CPUQuery: `free_cpu{namespace="%s"} or free_cpu{namespace="%s"}`,
+		RAMQuery: `free_ram{namespace="%s"} or free_ram{namespace="%s"}`,
+	}
+
+	ramBefore := counterValue(t, queryErrorsTotal.WithLabelValues("ram"))
+	cpuBefore := counterValue(t, queryErrorsTotal.WithLabelValues("cpu"))
+	for range 2 {
+		body, _ := json.Marshal(RequestPayload{Namespace: "batch-a", CPU: 1, RAM: 1})
+		w := httptest.NewRecorder()
+		handleRequest(w, httptest.NewRequest(http.MethodPost, "/api/request", bytes.NewReader(body)))
+		if w.Code != http.StatusInternalServerError {
+			t.Fatalf("answered %d, want 500 while the RAM query fails", w.Code)
+		}
+	}
+	// A query cut short by the client leaving isn't Prometheus' fault
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	fetchResources(ctx, prom.URL, "batch-a", placementQueries()[1])
+
+	w := httptest.NewRecorder()
+	handleQueryErrors(w, httptest.NewRequest(http.MethodGet, "/api/v1/query-errors", nil))
+	var got map[string]QueryErrorStats
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	ram, ok := got["ram"]
+	if !ok || ram.Count != 2 || !strings.Contains(ram.LastError, "422") || time.Since(ram.LastTime) > time.Minute {
+		t.Errorf("ram errors %+v, want 2 with the last error and its time", ram)
+	}
+	if _, ok := got["cpu"]; ok || len(got) != 1 {
+		t.Errorf("errors recorded for %v, want only ram", got)
+	}
+	if d := counterValue(t, queryErrorsTotal.WithLabelValues("ram")) - ramBefore; d != 2 {
+		t.Errorf("ram error counter rose by %v, want 2", d)
+	}
+	if d := counterValue(t, queryErrorsTotal.WithLabelValues("cpu")) - cpuBefore; d != 0 {
+		t.Errorf("cpu error counter rose by %v, want 0", d)
+	}
+}
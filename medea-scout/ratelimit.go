@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// errThrottled is returned instead of querying Prometheus when the query rate cap is reached
+var errThrottled = errors.New("prometheus query rate limit reached")
+
+// tokenBucket caps the rate of Prometheus queries across all requests
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// Global limiter for outbound Prometheus queries, nil when PROMETHEUS_QUERY_RPS is unset
+var promLimiter *tokenBucket
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// wait takes a token, sleeping until it is available. It returns errThrottled without taking
+// one when that would take longer than maxWait, so maxWait 0 sheds as soon as the bucket is
+// empty, and ctx's error when ctx ends first.
+func (b *tokenBucket) wait(ctx context.Context, maxWait time.Duration) error {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	var delay time.Duration
+	if b.tokens < 1 {
+		delay = time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		if delay > maxWait {
+			b.mu.Unlock()
+			return errThrottled
+		}
+	}
+	// Taking the token now, even if it goes negative, queues later callers behind this one
+	b.tokens--
+	b.mu.Unlock()
+	if delay == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// The client went away, the token goes back to those still waiting
+		b.mu.Lock()
+		b.tokens++
+		b.mu.Unlock()
+		return ctx.Err()
+	}
+}
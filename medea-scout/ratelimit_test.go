@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPrometheusRateCap(t *testing.T) {
+	defer func() { cfg, promLimiter = Config{}, nil }()
+	var mu sync.Mutex
+	var arrivals []time.Time
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		arrivals = append(arrivals, time.Now())
+		mu.Unlock()
+		w.Write([]byte(`{"status": "success", "data": {"result": []}}`))
+	}))
+	defer prom.Close()
+
+	const rate, burst, clients = 40, 4, 30
+	tests := []struct {
+		name        string
+		maxWait     time.Duration
+		wantQueried int // -1 for all clients
+	}{
+		{"queued", 5 * time.Second, -1},
+		{"shed", 0, burst},
+	}
+	for _, tt := range tests {
+		arrivals = nil
+		cfg = Config{PrometheusQueryWait: tt.maxWait, ClusterLabel: "cluster"}
+		promLimiter = newTokenBucket(rate, burst)
+		var wg sync.WaitGroup
+		for range clients {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := queryPrometheus(context.Background(), prom.URL, "batch-a", `free{namespace="%s"} - used{namespace="%s"}`); err != nil && !errors.Is(err, errThrottled) {
+					t.Errorf("%s: %v", tt.name, err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		want := tt.wantQueried
+		if want < 0 {
+			want = clients
+		}
+		if len(arrivals) != want {
+			t.Errorf("%s: %d queries reached Prometheus, want %d", tt.name, len(arrivals), want)
+		}
+		// No window holds more queries than the burst plus what the rate refills in it
+		slices.SortFunc(arrivals, func(a, b time.Time) int { return a.Compare(b) })
+		for i := range arrivals {
+			for j := i; j < len(arrivals); j++ {
+				window := arrivals[j].Sub(arrivals[i]).Seconds()
+				if n := j - i + 1; float64(n) > burst+rate*window+1 {
+					t.Errorf("%s: %d queries within %.3fs, over the cap of %d/s with a burst of %d", tt.name, n, window, rate, burst)
+				}
+			}
+		}
+	}
+}
+
+func TestTokenBucketWaitCancelled(t *testing.T) {
+	// The bucket is empty, the next token comes in a second
+	b := newTokenBucket(1, 1)
+	b.tokens = 0
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	start := time.Now()
+	if err := b.wait(ctx, time.Minute); !errors.Is(err, context.Canceled) {
+		t.Errorf("wait = %v, want context.Canceled", err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("wait returned after %v, not when the context ended", d)
+	}
+	// The token is given back, so the next caller isn't queued behind the cancelled one
+	b.mu.Lock()
+	tokens := b.tokens
+	b.mu.Unlock()
+	if tokens < 0 {
+		t.Errorf("bucket holds %v tokens after the cancelled wait, want the token back", tokens)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+
+	"medea/internal/registry"
+)
+
+// loadRegistry reads MEDEA_CLUSTER_REGISTRY and checks the clusters named by other settings
+// against it: unknown ones are fatal in reject mode and logged in warn mode
+func loadRegistry(c *Config) {
+	v := os.Getenv("MEDEA_CLUSTER_REGISTRY")
+	if v == "" {
+		return
+	}
+	reg, err := registry.Parse(v, os.Getenv("MEDEA_CLUSTER_REGISTRY_MODE"))
+	if err != nil {
+		fatalf("Invalid MEDEA_CLUSTER_REGISTRY: %v", err)
+	}
+	c.Registry = reg
+
+	var pairs []string
+	for _, kv := range c.ClusterPairs {
+		pairs = append(pairs, kv.Key, kv.Value)
+	}
+	var classes []string
+	for _, kv := range c.ClusterClasses {
+		classes = append(classes, kv.Key)
+	}
+	var canary []string
+	if c.CanaryCluster != "" {
+		canary = []string{c.CanaryCluster}
+	}
+	settings := []struct {
+		name     string
+		clusters []string
+	}{
+		{"MEDEA_SCOUT_CLUSTER_CLASSES", classes},
+		{"MEDEA_SCOUT_CLUSTER_PAIRS", pairs},
+		{"SCOUT_EXCLUDE_CLUSTERS", c.ExcludeClusters},
+		{"SCOUT_CLUSTER_WEIGHTS", slices.Sorted(maps.Keys(c.ClusterWeights))},
+		{"MEDEA_SCOUT_CANARY_CLUSTER", canary},
+	}
+	for _, s := range settings {
+		for _, cluster := range reg.Unknown(s.clusters...) {
+			if reg.Mode == registry.ModeReject {
+				fatalf("%s names %s, which is not in MEDEA_CLUSTER_REGISTRY", s.name, cluster)
+			}
+			slog.Warn("Cluster is not in MEDEA_CLUSTER_REGISTRY", "setting", s.name, "cluster", cluster)
+		}
+	}
+}
+
+// registeredCluster reports whether a cluster reported by Prometheus may be selected: always
+// without a registry or in warn mode, where unknown clusters are logged once, and only when
+// registered in reject mode
+func registeredCluster(cluster string) bool {
+	if !cfg.Registry.Enabled() {
+		return true
+	}
+	if _, ok := cfg.Registry.Lookup(cluster); ok {
+		return true
+	}
+	if cfg.Registry.FirstSighting(cluster) {
+		slog.Warn("Cluster is not in MEDEA_CLUSTER_REGISTRY", "cluster", cluster, "mode", cfg.Registry.Mode)
+	}
+	return cfg.Registry.Mode != registry.ModeReject
+}
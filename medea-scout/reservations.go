@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Reservation holds capacity for a placement until Prometheus reflects the new workflow
+type Reservation struct {
+	Namespace string    `json:"namespace"`
+	CPU       float64   `json:"cpu"`
+	RAM       float64   `json:"ram"`
+	Expires   time.Time `json:"expires"`
+}
+
+// reservationStore keeps short-lived in-memory reservations per cluster
+type reservationStore struct {
+	mu        sync.Mutex
+	byCluster map[string][]Reservation
+}
+
+// Reservations made by this scout instance, only used when MEDEA_SCOUT_RESERVATION_TTL is set
+var reservations = reservationStore{byCluster: make(map[string][]Reservation)}
+
+func (s *reservationStore) add(cluster string, r Reservation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.byCluster[cluster] = append(s.byCluster[cluster], r)
+}
+
+// release drops the newest reservation of a namespace on a cluster, for a placement that didn't happen
+func (s *reservationStore) release(cluster, namespace string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rs := s.byCluster[cluster]
+	for i := len(rs) - 1; i >= 0; i-- {
+		if rs[i].Namespace == namespace {
+			s.byCluster[cluster] = append(rs[:i], rs[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// held sums the active reservations of a namespace on a cluster; quotas are per namespace
+func (s *reservationStore) held(cluster, namespace string) (cpu, ram float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneLocked(time.Now())
+	for _, r := range s.byCluster[cluster] {
+		if r.Namespace == namespace {
+			cpu += r.CPU
+			ram += r.RAM
+		}
+	}
+	return cpu, ram
+}
+
+// list returns a copy of all active reservations per cluster
+func (s *reservationStore) list() map[string][]Reservation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneLocked(time.Now())
+	out := make(map[string][]Reservation, len(s.byCluster))
+	for cluster, rs := range s.byCluster {
+		out[cluster] = append([]Reservation(nil), rs...)
+	}
+	return out
+}
+
+func (s *reservationStore) pruneLocked(now time.Time) {
+	for cluster, rs := range s.byCluster {
+		active := rs[:0]
+		for _, r := range rs {
+			if r.Expires.After(now) {
+				active = append(active, r)
+			}
+		}
+		if len(active) == 0 {
+			delete(s.byCluster, cluster)
+		} else {
+			s.byCluster[cluster] = active
+		}
+	}
+}
+
+// handleReservations lists the outstanding reservations with their expiry times
+func handleReservations(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reservations.list())
+}
+
+// requireAdmin only lets requests with the MEDEA_SCOUT_ADMIN_TOKEN in X-Medea-Admin-Token through.
+// Without a configured token admin endpoints are disabled.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("X-Medea-Admin-Token")
+		if cfg.AdminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(cfg.AdminToken)) != 1 {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
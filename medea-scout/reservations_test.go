@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReservationsEndpoint(t *testing.T) {
+	defer func() {
+		cfg = Config{}
+		reservations = reservationStore{byCluster: make(map[string][]Reservation)}
+	}()
+	now := time.Now()
+	active := Reservation{Namespace: "batch-a", CPU: 2, RAM: 4, Expires: now.Add(time.Minute)}
+	reservations = reservationStore{byCluster: make(map[string][]Reservation)}
+	reservations.add("east", active)
+	reservations.add("east", Reservation{Namespace: "batch-b", CPU: 1, RAM: 1, Expires: now.Add(-time.Second)})
+	reservations.add("west", Reservation{Namespace: "batch-a", CPU: 1, RAM: 1, Expires: now.Add(-time.Minute)})
+
+	tests := []struct {
+		name       string
+		configured string
+		sent       string
+		wantStatus int
+	}{
+		{"no token sent", "s3cret", "", http.StatusForbidden},
+		{"wrong token", "s3cret", "guess", http.StatusForbidden},
+		{"admin endpoints disabled", "", "", http.StatusForbidden},
+		{"admin token", "s3cret", "s3cret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		cfg = Config{AdminToken: tt.configured}
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/reservations", nil)
+		if tt.sent != "" {
+			r.Header.Set("X-Medea-Admin-Token", tt.sent)
+		}
+		w := httptest.NewRecorder()
+		requireAdmin(handleReservations)(w, r)
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: answered %d, want %d", tt.name, w.Code, tt.wantStatus)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var got map[string][]Reservation
+		if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		// Only the active reservation is listed, with its expiry; west has none left
+		if len(got) != 1 || len(got["east"]) != 1 || got["east"][0].Namespace != "batch-a" || !got["east"][0].Expires.Equal(active.Expires) {
+			t.Errorf("%s: listed %+v, want only %+v on east", tt.name, got, active)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSeenClusters(t *testing.T) {
+	defer func() {
+		cfg = Config{}
+		seen = seenClusters{}
+	}()
+	seen = seenClusters{}
+	var reported []string
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var series []string
+		for _, cluster := range reported {
+			series = append(series, fmt.Sprintf(`{"metric": {"cluster": %q}, "value": [0, "4"]}`, cluster))
+		}
+		fmt.Fprintf(w, `{"status": "success", "data": {"result": [%s]}}`, strings.Join(series, ", "))
+	}))
+	defer prom.Close()
+	cfg = Config{PrometheusURL: prom.URL, ClusterLabel: "cluster"}
+
+	list := func() map[string]time.Time {
+		w := httptest.NewRecorder()
+		handleSeenClusters(w, httptest.NewRequest(http.MethodGet, "/api/v1/seen-clusters", nil))
+		var got []SeenCluster
+		if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		byCluster := make(map[string]time.Time)
+		for _, c := range got {
+			byCluster[c.Cluster] = c.LastSeen
+		}
+		return byCluster
+	}
+	query := func(clusters ...string) {
+		reported = clusters
+		if _, err := queryPrometheus(context.Background(), prom.URL, "batch-a", `x{namespace="%s"} or x{namespace="%s"}`); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := list(); len(got) != 0 {
+		t.Fatalf("seen %v before any query", got)
+	}
+	query("east", "west")
+	first := list()
+	if len(first) != 2 || first["east"].IsZero() || first["west"].IsZero() {
+		t.Fatalf("seen %v, want east and west", first)
+	}
+	// West stops reporting: it stays listed with the time it was last seen
+	time.Sleep(10 * time.Millisecond)
+	query("east")
+	second := list()
+	if !second["east"].After(first["east"]) {
+		t.Errorf("east last seen %v, want after %v", second["east"], first["east"])
+	}
+	if !second["west"].Equal(first["west"]) {
+		t.Errorf("west last seen %v, want still %v", second["west"], first["west"])
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Tie-breakers for clusters that score the same, set by MEDEA_SCOUT_TIE_BREAKER
+const (
+	tieRandom     = "random"
+	tieName       = "name"
+	tiePlacements = "placements"
+	tieNamespace  = "namespace"
+)
+
+// Selection strategies, set by SCOUT_STRATEGY
+const (
+	strategyRandom   = "random"
+	strategyMostCPU  = "most-cpu"
+	strategyMostMem  = "most-mem"
+	strategyMostFree = "most-free"
+)
+
+// recentPlacements counts the placements scout made per cluster within a sliding window
+type recentPlacements struct {
+	mu    sync.Mutex
+	times map[string][]time.Time
+}
+
+// Placements made by this scout instance, used by the "placements" tie-breaker
+var placements = recentPlacements{times: make(map[string][]time.Time)}
+
+func (p *recentPlacements) record(cluster string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.times[cluster] = append(p.times[cluster], time.Now())
+}
+
+// count returns the placements on cluster within window, dropping older ones
+func (p *recentPlacements) count(cluster string, window time.Duration) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	cutoff := time.Now().Add(-window)
+	ts := p.times[cluster]
+	i := 0
+	for i < len(ts) && ts[i].Before(cutoff) {
+		i++
+	}
+	p.times[cluster] = ts[i:]
+	return len(ts) - i
+}
+
+// breakTie picks one of the tied clusters with the configured tie-breaker.
+// freeCPU is only used by the namespace tie-breaker, as the weight of each cluster.
+func breakTie(tied []string, namespace string, freeCPU map[string]float64) string {
+	switch cfg.TieBreaker {
+	case tieNamespace:
+		return namespaceChoice(tied, namespace, freeCPU)
+	case tieName:
+		return slices.Min(tied)
+	case tiePlacements:
+		// Fewest recent placements wins, the name decides between equal counts
+		sorted := slices.Sorted(slices.Values(tied))
+		best, bestCount := sorted[0], placements.count(sorted[0], cfg.PlacementWindow)
+		for _, c := range sorted[1:] {
+			if n := placements.count(c, cfg.PlacementWindow); n < bestCount {
+				best, bestCount = c, n
+			}
+		}
+		return best
+	default:
+		if len(cfg.ClusterWeights) > 0 {
+			return weightedChoice(tied, rand.Float64())
+		}
+		return tied[rand.Intn(len(tied))]
+	}
+}
+
+// weightedChoice picks a cluster with a probability proportional to its weight in
+// SCOUT_CLUSTER_WEIGHTS, clusters without one weigh 1. u is a uniform random number in [0, 1).
+func weightedChoice(clusters []string, u float64) string {
+	weight := func(c string) float64 {
+		if w, ok := cfg.ClusterWeights[c]; ok {
+			return w
+		}
+		return 1
+	}
+	var total float64
+	for _, c := range clusters {
+		total += weight(c)
+	}
+	r := u * total
+	for _, c := range clusters {
+		if r -= weight(c); r < 0 {
+			return c
+		}
+	}
+	// Rounding may leave r at 0 after the last cluster
+	return clusters[len(clusters)-1]
+}
+
+// bestScoring returns the clusters with the highest score under the configured strategy,
+// scored on the headroom left after placing the request. most-free normalizes the CPU and RAM
+// headroom by the largest one among the candidates and takes the scarcer dimension.
+func bestScoring(clusters []string, freeCPU, freeRAM map[string]float64, needCPU, needRAM float64) []string {
+	var maxCPU, maxRAM float64
+	for _, c := range clusters {
+		maxCPU = max(maxCPU, freeCPU[c]-needCPU)
+		maxRAM = max(maxRAM, freeRAM[c]-needRAM)
+	}
+	score := func(c string) float64 {
+		cpu, ram := freeCPU[c]-needCPU, freeRAM[c]-needRAM
+		switch cfg.Strategy {
+		case strategyMostCPU:
+			return cpu
+		case strategyMostMem:
+			return ram
+		default:
+			return min(normalized(cpu, maxCPU), normalized(ram, maxRAM))
+		}
+	}
+
+	var best []string
+	bestScore := math.Inf(-1)
+	for _, c := range clusters {
+		switch s := score(c); {
+		case s > bestScore:
+			best, bestScore = []string{c}, s
+		case s == bestScore:
+			best = append(best, c)
+		}
+	}
+	return best
+}
+
+// normalized scales v by the largest value m, a dimension with no headroom anywhere counts as 1
+func normalized(v, m float64) float64 {
+	if m <= 0 {
+		return 1
+	}
+	return v / m
+}
+
+// namespaceChoice is weighted rendezvous hashing: every cluster gets a pseudo-random score
+// seeded by the epoch, namespace and cluster name and scaled by its free CPU. The same namespace
+// keeps landing on the same cluster across restarts while capacity is unchanged, different
+// namespaces spread over all clusters, and removing a cluster only moves the namespaces it had.
+func namespaceChoice(tied []string, namespace string, freeCPU map[string]float64) string {
+	epoch := seedEpoch(time.Now())
+	best, bestScore := "", math.Inf(-1)
+	for _, cluster := range tied {
+		h := fnv.New64a()
+		fmt.Fprintf(h, "%s\x00%s\x00%s", epoch, namespace, cluster)
+		// Uniform in (0, 1) from the top 53 bits of the mixed hash
+		u := (float64(mix64(h.Sum64())>>11) + 0.5) / (1 << 53)
+		weight := max(freeCPU[cluster], 1e-9)
+		if score := weight / -math.Log(u); score > bestScore {
+			best, bestScore = cluster, score
+		}
+	}
+	return best
+}
+
+// mix64 is the splitmix64 finalizer. FNV barely changes its high bits when only the last
+// bytes differ, as they do between the clusters of one namespace, so the hash is mixed first.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// seedEpoch is MEDEA_SCOUT_SEED_EPOCH, extended by the current rotation period when
+// MEDEA_SCOUT_SEED_ROTATION is set so that assignments reshuffle once per period
+func seedEpoch(now time.Time) string {
+	if cfg.SeedRotation <= 0 {
+		return cfg.SeedEpoch
+	}
+	return fmt.Sprintf("%s/%d", cfg.SeedEpoch, now.UnixNano()/int64(cfg.SeedRotation))
+}
+
+// canaryGate lets the canary cluster take cfg.CanaryPercent of the placements it is eligible for.
+// It returns the canary when the gate opens, otherwise the suitable clusters without it.
+// A canary that is the only suitable cluster is kept so placements don't fail because of the gate.
+func canaryGate(suitable []string) (string, []string) {
+	if cfg.CanaryCluster == "" || !slices.Contains(suitable, cfg.CanaryCluster) || len(suitable) == 1 {
+		return "", suitable
+	}
+	if rand.Float64()*100 < cfg.CanaryPercent {
+		return cfg.CanaryCluster, suitable
+	}
+	rest := make([]string, 0, len(suitable)-1)
+	for _, c := range suitable {
+		if c != cfg.CanaryCluster {
+			rest = append(rest, c)
+		}
+	}
+	return "", rest
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math/rand"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestWeightedChoice(t *testing.T) {
+	defer func() { cfg = Config{} }()
+	cfg = Config{ClusterWeights: map[string]float64{"cheap": 10, "spare": 0}}
+	clusters := []string{"cheap", "default", "spare"}
+
+	// Fixed seed, so the counts are the same on every run
+	rng := rand.New(rand.NewSource(1))
+	const n = 11000
+	counts := make(map[string]int)
+	for range n {
+		counts[weightedChoice(clusters, rng.Float64())]++
+	}
+	// cheap weighs 10, default the implied 1 and spare 0: expect 10000, 1000 and 0 picks
+	if counts["spare"] != 0 {
+		t.Errorf("a cluster of weight 0 was picked %d times", counts["spare"])
+	}
+	if c := counts["cheap"]; c < 9700 || c > 10300 {
+		t.Errorf("cheap picked %d of %d times, want about 10000", c, n)
+	}
+	if c := counts["default"]; c < 700 || c > 1300 {
+		t.Errorf("unweighted cluster picked %d of %d times, want about 1000", c, n)
+	}
+
+	// The draw maps onto the clusters in order of their cumulative weight
+	for _, tt := range []struct {
+		u    float64
+		want string
+	}{
+		{0, "cheap"},
+		{10.0/11 - 1e-9, "cheap"},
+		{10.0 / 11, "default"},
+		{0.999999, "default"},
+	} {
+		if got := weightedChoice(clusters, tt.u); got != tt.want {
+			t.Errorf("weightedChoice(%v) = %s, want %s", tt.u, got, tt.want)
+		}
+	}
+}
+
+func TestTieBreaker(t *testing.T) {
+	defer func() {
+		cfg = Config{}
+		cache = resourceCache{entries: make(map[cacheKey]cacheEntry)}
+		placements = recentPlacements{times: make(map[string][]time.Time)}
+	}()
+	// a, b and c tie on free capacity, d has less
+	cpus := map[string]float64{"c": 16, "a": 16, "b": 16, "d": 8}
+	mems := map[string]float64{"c": 64, "a": 64, "b": 64, "d": 64}
+
+	tests := []struct {
+		name       string
+		tieBreaker string
+		recent     map[string]int // placements already made per cluster
+		want       string
+	}{
+		{"alphabetical", tieName, nil, "a"},
+		{"fewest placements", tiePlacements, map[string]int{"a": 3, "b": 1, "c": 2}, "b"},
+		{"fewest placements, equal counts by name", tiePlacements, map[string]int{"a": 1, "b": 1, "c": 1}, "a"},
+		// Placements on a cluster that doesn't tie don't matter
+		{"fewest placements among the tied only", tiePlacements, map[string]int{"a": 2, "b": 2, "c": 1}, "c"},
+	}
+	for _, tt := range tests {
+		cfg = Config{
+			NamespacePattern: regexp.MustCompile(`^[a-z0-9-]+$`), ClusterLabel: "cluster", MemoryUnit: memoryGB,
+			CacheTTL: time.Hour, Strategy: strategyMostCPU, TieBreaker: tt.tieBreaker, PlacementWindow: time.Hour,
+		}
+		placements = recentPlacements{times: make(map[string][]time.Time)}
+		for c, n := range tt.recent {
+			for range n {
+				placements.record(c)
+			}
+		}
+		// The same answer every time, not whichever random.Intn happens to draw
+		for i := range 5 {
+			w := placeWith(t, cpus, mems, RequestPayload{Namespace: "batch-a", CPU: 1, RAM: 1})
+			var got ResponsePayload
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if got.Cluster != tt.want {
+				t.Errorf("%s: placement %d went to %q, want %q", tt.name, i, got.Cluster, tt.want)
+			}
+			if tt.tieBreaker == tiePlacements {
+				// Undo the placement just made so the counts stay as set up
+				placements.mu.Lock()
+				placements.times[got.Cluster] = placements.times[got.Cluster][:tt.recent[got.Cluster]]
+				placements.mu.Unlock()
+			}
+		}
+	}
+}
+
+func TestNamespaceChoice(t *testing.T) {
+	defer func() { cfg = Config{} }()
+	cfg = Config{TieBreaker: tieNamespace, SeedEpoch: "1"}
+	clusters := []string{"a", "b", "c", "d"}
+	free := map[string]float64{"a": 16, "b": 16, "c": 16, "d": 16}
+
+	namespaces := make([]string, 400)
+	assigned := make(map[string]string)
+	counts := make(map[string]int)
+	for i := range namespaces {
+		namespaces[i] = fmt.Sprintf("team-%d", i)
+		assigned[namespaces[i]] = namespaceChoice(clusters, namespaces[i], free)
+		counts[assigned[namespaces[i]]]++
+	}
+	// The same namespace keeps its cluster, whatever order the clusters come in
+	reversed := slices.Clone(clusters)
+	slices.Reverse(reversed)
+	for _, ns := range namespaces[:20] {
+		if got := namespaceChoice(reversed, ns, free); got != assigned[ns] {
+			t.Errorf("%s moved from %s to %s under the same capacity", ns, assigned[ns], got)
+		}
+	}
+	// Different namespaces spread over every cluster, about 100 each
+	for _, c := range clusters {
+		if counts[c] < 70 || counts[c] > 130 {
+			t.Errorf("cluster %s got %d of %d namespaces, want about 100", c, counts[c], len(namespaces))
+		}
+	}
+	// A cluster with twice the free CPU draws twice the namespaces, 2/5 of them
+	weighted := 0
+	for _, ns := range namespaces {
+		if namespaceChoice(clusters, ns, map[string]float64{"a": 32, "b": 16, "c": 16, "d": 16}) == "a" {
+			weighted++
+		}
+	}
+	if weighted < 130 || weighted > 190 {
+		t.Errorf("cluster a with twice the CPU got %d of %d namespaces, want about 160", weighted, len(namespaces))
+	}
+	// Removing a cluster only moves the namespaces it had
+	for _, ns := range namespaces {
+		if got := namespaceChoice(clusters[:3], ns, free); assigned[ns] != "d" && got != assigned[ns] {
+			t.Errorf("%s moved from %s to %s when d was removed", ns, assigned[ns], got)
+		}
+	}
+	// Another epoch reshuffles
+	cfg.SeedEpoch = "2"
+	moved := 0
+	for _, ns := range namespaces {
+		if namespaceChoice(clusters, ns, free) != assigned[ns] {
+			moved++
+		}
+	}
+	if moved < 200 {
+		t.Errorf("%d of %d namespaces moved with a new epoch, want about 300", moved, len(namespaces))
+	}
+
+	// With a rotation the epoch changes once per period
+	cfg.SeedRotation = time.Hour
+	start := time.Unix(0, 0).Add(10 * time.Hour)
+	if seedEpoch(start) != seedEpoch(start.Add(59*time.Minute)) || seedEpoch(start) == seedEpoch(start.Add(time.Hour)) {
+		t.Errorf("epochs %s, %s and %s, want a change once per hour", seedEpoch(start), seedEpoch(start.Add(59*time.Minute)), seedEpoch(start.Add(time.Hour)))
+	}
+}
+
+func TestCanaryGate(t *testing.T) {
+	defer func() { cfg = Config{} }()
+	suitable := []string{"canary", "east", "west"}
+	for _, percent := range []float64{0, 20, 50, 100} {
+		cfg = Config{CanaryCluster: "canary", CanaryPercent: percent}
+		const n = 10000
+		hits := 0
+		for range n {
+			picked, rest := canaryGate(suitable)
+			switch {
+			case picked == "canary":
+				hits++
+			case slices.Contains(rest, "canary") || len(rest) != 2:
+				t.Fatalf("%v%%: a closed gate left %v", percent, rest)
+			}
+		}
+		// Within 3 points of the configured share, six standard deviations at 50%
+		if share := float64(hits) * 100 / n; share < percent-3 || share > percent+3 {
+			t.Errorf("canary took %.1f%% of the placements, want about %v%%", share, percent)
+		}
+	}
+
+	cfg = Config{CanaryCluster: "canary", CanaryPercent: 0}
+	// A canary that is the only suitable cluster still takes the placement
+	if picked, rest := canaryGate([]string{"canary"}); picked != "" || !slices.Equal(rest, []string{"canary"}) {
+		t.Errorf("only the canary fits: got %q and %v, want the canary kept", picked, rest)
+	}
+	// Placements the canary isn't suitable for are left alone
+	if picked, rest := canaryGate([]string{"east", "west"}); picked != "" || !slices.Equal(rest, []string{"east", "west"}) {
+		t.Errorf("canary unsuitable: got %q and %v", picked, rest)
+	}
+}
+
+func TestBestScoring(t *testing.T) {
+	defer func() { cfg = Config{} }()
+	tests := []struct {
+		name             string
+		strategy         string
+		cpus, mems       map[string]float64
+		needCPU, needRAM float64
+		want             []string
+	}{
+		{"most-cpu", strategyMostCPU, map[string]float64{"a": 10, "b": 20, "c": 15}, map[string]float64{"a": 64, "b": 8, "c": 32}, 1, 1, []string{"b"}},
+		{"most-mem", strategyMostMem, map[string]float64{"a": 10, "b": 20, "c": 15}, map[string]float64{"a": 64, "b": 8, "c": 32}, 1, 1, []string{"a"}},
+		{"most-cpu ties", strategyMostCPU, map[string]float64{"a": 20, "b": 20, "c": 15}, map[string]float64{"a": 64, "b": 8, "c": 32}, 1, 1, []string{"a", "b"}},
+		// Normalized headroom: a min(1, 0.25), b min(0.5, 1), c min(0.75, 0.75)
+		{"most-free takes the scarcer dimension", strategyMostFree, map[string]float64{"a": 20, "b": 10, "c": 15}, map[string]float64{"a": 10, "b": 40, "c": 30}, 0, 0, []string{"c"}},
+		// Before the request a leads with min(1, 0.83) over b's min(0.6, 1), after taking 40 of RAM
+		// a is left with min(1, 0.5) and b with min(0.6, 1)
+		{"most-free subtracts the request", strategyMostFree, map[string]float64{"a": 100, "b": 60}, map[string]float64{"a": 50, "b": 60}, 0, 40, []string{"b"}},
+		{"most-free without the request", strategyMostFree, map[string]float64{"a": 100, "b": 60}, map[string]float64{"a": 50, "b": 60}, 0, 0, []string{"a"}},
+	}
+	for _, tt := range tests {
+		cfg = Config{Strategy: tt.strategy}
+		clusters := slices.Sorted(maps.Keys(tt.cpus))
+		if got := bestScoring(clusters, tt.cpus, tt.mems, tt.needCPU, tt.needRAM); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: best %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// random leaves every suitable cluster to the tie-breaker, as before
+	cfg = Config{Strategy: strategyRandom, TieBreaker: tieRandom}
+	picked := make(map[string]bool)
+	for range 200 {
+		c, _ := rankClusters([]string{"a", "b", "c"}, map[string]float64{"a": 100, "b": 1, "c": 1}, map[string]float64{"a": 100, "b": 1, "c": 1}, 0, 0, "batch-a")
+		picked[c] = true
+	}
+	if len(picked) != 3 {
+		t.Errorf("random picked only %v", picked)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+)
+
+// SimulateRequest is a sequence of hypothetical placements. Capacity optionally replaces the
+// Prometheus figures with recorded ones, per namespace and cluster in MemoryUnit; namespaces
+// missing from it are looked up like for a placement.
+type SimulateRequest struct {
+	Requests []RequestPayload               `json:"requests"`
+	Capacity map[string]map[string]Capacity `json:"capacity,omitempty"`
+}
+
+// SimulatedPlacement is the outcome of one simulated request: the cluster it got and what is
+// left there afterwards, or why nothing fit
+type SimulatedPlacement struct {
+	Namespace string       `json:"namespace"`
+	Cluster   string       `json:"cluster,omitempty"`
+	Strategy  string       `json:"strategy,omitempty"`
+	FreeCPU   *float64     `json:"freeCpu,omitempty"`
+	FreeMem   *float64     `json:"freeMem,omitempty"`
+	Error     string       `json:"error,omitempty"`
+	Reason    *NoFitReason `json:"reason,omitempty"`
+}
+
+// SimulateResponse holds one placement per request, in order, and the capacity left at the end
+type SimulateResponse struct {
+	Placements []SimulatedPlacement           `json:"placements"`
+	Remaining  map[string]map[string]Capacity `json:"remaining"`
+	// Stale is set when some Prometheus figures came from the cache during an outage
+	Stale bool `json:"stale,omitempty"`
+}
+
+// handleSimulate places a sequence of requests one after the other without placing anything:
+// each placement takes its CPU and RAM from the simulated capacity of its namespace, so later
+// requests see what earlier ones left. Capacity from Prometheus is reduced by the held
+// reservations and failure penalties like for a placement, recorded capacity is taken as
+// is. Nothing is reserved and no token is bound or followed.
+func handleSimulate(w http.ResponseWriter, r *http.Request) {
+	if cfg.SimulateMaxRequests <= 0 {
+		http.NotFound(w, r)
+		return
+	}
+	r = withRequestID(r)
+	var sim SimulateRequest
+	if err := json.NewDecoder(r.Body).Decode(&sim); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if len(sim.Requests) > cfg.SimulateMaxRequests {
+		http.Error(w, fmt.Sprintf("At most %d requests per simulation", cfg.SimulateMaxRequests), http.StatusBadRequest)
+		return
+	}
+	for i, req := range sim.Requests {
+		if err := validateRequest(req); err != nil {
+			http.Error(w, fmt.Sprintf("Request %d: %v", i, err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	resp := SimulateResponse{Placements: make([]SimulatedPlacement, 0, len(sim.Requests)), Remaining: make(map[string]map[string]Capacity)}
+	for ns, clusters := range sim.Capacity {
+		resp.Remaining[ns] = make(map[string]Capacity, len(clusters))
+		for cluster, c := range clusters {
+			resp.Remaining[ns][cluster] = c
+		}
+	}
+	for _, req := range sim.Requests {
+		if _, ok := resp.Remaining[req.Namespace]; ok {
+			continue
+		}
+		cpus, mems, stale, err := namespaceResources(r.Context(), req.Namespace)
+		if r.Context().Err() != nil {
+			return
+		}
+		if errors.Is(err, errThrottled) {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Prometheus query rate limit reached", http.StatusServiceUnavailable)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Prometheus query failed", "namespace", req.Namespace, "error", err)
+			writePrometheusError(w, err)
+			return
+		}
+		resp.Stale = resp.Stale || stale
+		free := make(map[string]Capacity, len(cpus))
+		for cluster, cVal := range cpus {
+			cpu, ram := adjustedFree(cluster, req.Namespace, cVal, mems[cluster])
+			free[cluster] = Capacity{CPU: cpu, RAM: ram}
+		}
+		resp.Remaining[req.Namespace] = free
+	}
+
+	for _, req := range sim.Requests {
+		resp.Placements = append(resp.Placements, simulatePlacement(req, resp.Remaining[req.Namespace]))
+	}
+	slog.DebugContext(r.Context(), "Placements simulated", "count", len(sim.Requests))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// simulatePlacement places req on free, the simulated capacity of its namespace, and takes what
+// it needs from the chosen cluster. It filters and ranks clusters like a placement, but the
+// canary gate is skipped, so the canary ranks like any cluster; high-priority requests still
+// never get it.
+func simulatePlacement(req RequestPayload, free map[string]Capacity) SimulatedPlacement {
+	owner := namespaceOwner(req.Namespace)
+	needCPU, needRAM, fitCPU, fitRAM := requestNeeds(req)
+
+	var suitable []string
+	suitableCPU := make(map[string]float64)
+	suitableRAM := make(map[string]float64)
+	var reason NoFitReason
+	for cluster, c := range free {
+		if len(req.Clusters) > 0 && !slices.Contains(req.Clusters, cluster) || excludedCluster(req, cluster) || !registeredCluster(cluster) {
+			reason.Excluded++
+			continue
+		}
+		if !ownedBy(cluster, owner) || !placementAllows(req.Placement, cluster) || inactiveCluster(cluster, req.Namespace) {
+			reason.Excluded++
+			continue
+		}
+		reason.ClustersSeen++
+		cpuOK, ramOK := dimensionFits(cfg.SoftCPU, c.CPU, fitCPU, needCPU), dimensionFits(cfg.SoftRAM, c.RAM, fitRAM, needRAM)
+		switch {
+		case cpuOK && ramOK:
+			suitable = append(suitable, cluster)
+			suitableCPU[cluster] = c.CPU
+			suitableRAM[cluster] = c.RAM
+		case !cpuOK && !ramOK:
+			reason.InsufficientAll++
+		case !cpuOK:
+			reason.InsufficientCPU++
+		default:
+			reason.InsufficientRAM++
+		}
+	}
+	if len(suitable) == 0 {
+		_, msg := noFitStatus(reason)
+		return SimulatedPlacement{Namespace: req.Namespace, Error: msg, Reason: &reason}
+	}
+	// Map order is random, sorting keeps the name and namespace tie-breakers repeatable
+	slices.Sort(suitable)
+
+	var selected, strategy string
+	if req.PreferredCluster != "" && slices.Contains(suitable, req.PreferredCluster) {
+		selected, strategy = req.PreferredCluster, "preferred"
+	} else {
+		selected, strategy = rankClusters(suitable, suitableCPU, suitableRAM, needCPU, needRAM, req.Namespace)
+	}
+	left := Capacity{CPU: free[selected].CPU - needCPU, RAM: free[selected].RAM - needRAM}
+	free[selected] = left
+	return SimulatedPlacement{Namespace: req.Namespace, Cluster: selected, Strategy: strategy, FreeCPU: &left.CPU, FreeMem: &left.RAM}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSimulateFillsAndSpills(t *testing.T) {
+	defer func() {
+		cfg = Config{}
+		reservations = reservationStore{byCluster: make(map[string][]Reservation)}
+	}()
+	reservations = reservationStore{byCluster: make(map[string][]Reservation)}
+	// The name tie-breaker fills the alphabetically first cluster before the next one
+	cfg = Config{
+		NamespacePattern: regexp.MustCompile(`^[a-z0-9-]+$`), ClusterLabel: "cluster", MemoryUnit: memoryGB,
+		Strategy: strategyRandom, TieBreaker: tieName, SimulateMaxRequests: 10, ReservationTTL: time.Minute,
+	}
+	body := `{
+		"requests": [
+			{"namespace": "batch-a", "cpu": 4, "ram": 8},
+			{"namespace": "batch-a", "cpu": 4, "ram": 8},
+			{"namespace": "batch-a", "cpu": 4, "ram": 8},
+			{"namespace": "batch-a", "cpu": 4, "ram": 8},
+			{"namespace": "batch-a", "cpu": 4, "ram": 8}
+		],
+		"capacity": {"batch-a": {"east": {"cpu": 8, "ram": 32}, "west": {"cpu": 6, "ram": 32}}}
+	}`
+	w := httptest.NewRecorder()
+	handleSimulate(w, httptest.NewRequest(http.MethodPost, "/api/simulate", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("simulate answered %d %q", w.Code, w.Body.String())
+	}
+	var resp SimulateResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"east", "east", "west", "", ""}
+	if len(resp.Placements) != len(want) {
+		t.Fatalf("got %d placements, want %d", len(resp.Placements), len(want))
+	}
+	for i, p := range resp.Placements {
+		if p.Cluster != want[i] {
+			t.Errorf("request %d placed on %q, want %q", i, p.Cluster, want[i])
+		}
+		if want[i] == "" && (p.Error == "" || p.Reason == nil || p.Reason.InsufficientCPU != 2) {
+			t.Errorf("request %d: error %q, reason %+v, want no fit for CPU on both clusters", i, p.Error, p.Reason)
+		}
+	}
+	if left := resp.Placements[2].FreeCPU; left == nil || *left != 2 {
+		t.Errorf("CPU left on west after the spill = %v, want 2", left)
+	}
+	if got := resp.Remaining["batch-a"]; got["east"] != (Capacity{CPU: 0, RAM: 16}) || got["west"] != (Capacity{CPU: 2, RAM: 24}) {
+		t.Errorf("remaining %+v, want east 0/16 and west 2/24", got)
+	}
+	// Nothing was actually placed
+	if cpu, ram := reservations.held("east", "batch-a"); cpu != 0 || ram != 0 {
+		t.Errorf("simulation reserved %v CPU and %v RAM", cpu, ram)
+	}
+}
+
+func TestSimulateFromPrometheus(t *testing.T) {
+	defer func() {
+		cfg = Config{}
+		cache = resourceCache{entries: make(map[cacheKey]cacheEntry)}
+		reservations = reservationStore{byCluster: make(map[string][]Reservation)}
+		penalties = failurePenalties{failures: make(map[string][]time.Time)}
+	}()
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		value := 8
+		if strings.HasPrefix(r.URL.Query().Get("query"), "ram_free") {
+			value = 32
+		}
+		fmt.Fprintf(w, `{"status": "success", "data": {"result": [
+			{"metric": {"cluster": "east"}, "value": [0, "%d"]},
+			{"metric": {"cluster": "west"}, "value": [0, "%d"]}]}}`, value, value)
+	}))
+	defer prom.Close()
+	cfg = Config{
+		PrometheusURL: prom.URL, NamespacePattern: regexp.MustCompile(`^[a-z0-9-]+$`), ClusterLabel: "cluster", MemoryUnit: memoryGB,
+		CPUQuery: `cpu_free{namespace="%s"} or cpu_free{namespace="%s"}`, RAMQuery: `ram_free{namespace="%s"} or ram_free{namespace="%s"}`,
+		Strategy: strategyRandom, TieBreaker: tieName, SimulateMaxRequests: 10,
+		ReservationTTL: time.Minute, FailurePenalty: 0.5, PenaltyWindow: time.Hour,
+	}
+	// east holds a reservation of half its capacity, west failed a submit and counts half
+	reservations = reservationStore{byCluster: make(map[string][]Reservation)}
+	reservations.add("east", Reservation{Namespace: "batch-a", CPU: 4, RAM: 16, Expires: time.Now().Add(time.Minute)})
+	penalties = failurePenalties{failures: make(map[string][]time.Time)}
+	penalties.record("west")
+
+	w := httptest.NewRecorder()
+	handleSimulate(w, httptest.NewRequest(http.MethodPost, "/api/simulate", strings.NewReader(`{"requests": [{"namespace": "batch-a", "cpu": 1, "ram": 2}]}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("simulate answered %d %q", w.Code, w.Body.String())
+	}
+	var resp SimulateResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	got := resp.Remaining["batch-a"]
+	near := func(a, b float64) bool { return math.Abs(a-b) < 0.01 }
+	if resp.Placements[0].Cluster != "east" || got["east"] != (Capacity{CPU: 3, RAM: 14}) {
+		t.Errorf("placed on %q leaving %+v on east, want east with 3/14 left", resp.Placements[0].Cluster, got["east"])
+	}
+	if !near(got["west"].CPU, 4) || !near(got["west"].RAM, 16) {
+		t.Errorf("west %+v, want the penalized 4/16", got["west"])
+	}
+	// The simulated placement reserved nothing
+	if cpu, ram := reservations.held("east", "batch-a"); cpu != 4 || ram != 16 {
+		t.Errorf("east holds %v CPU and %v RAM after the simulation, want the 4/16 reserved before", cpu, ram)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/pem"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPrometheusClientCA(t *testing.T) {
+	defer func() { cfg, promClient = Config{}, http.DefaultClient }()
+	prom := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status": "success", "data": {"result": [{"metric": {"cluster": "east"}, "value": [0, "4"]}]}}`))
+	}))
+	// Refused handshakes are expected below
+	prom.Config.ErrorLog = log.New(io.Discard, "", 0)
+	prom.StartTLS()
+	defer prom.Close()
+	cfg = Config{PrometheusURL: prom.URL, ClusterLabel: "cluster"}
+
+	dir := t.TempDir()
+	ca := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: prom.Certificate().Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	empty := filepath.Join(dir, "empty.pem")
+	if err := os.WriteFile(empty, []byte("no certificates here\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		caFile       string
+		insecure     bool
+		wantBuildErr bool
+		wantQueryErr bool
+	}{
+		{"system roots only", "", false, false, true},
+		{"private CA bundle", ca, false, false, false},
+		{"verification skipped", "", true, false, false},
+		{"missing bundle", filepath.Join(dir, "missing.pem"), false, true, false},
+		{"bundle without certificates", empty, false, true, false},
+	}
+	for _, tt := range tests {
+		client, err := prometheusClient(tt.caFile, tt.insecure, time.Second)
+		if (err != nil) != tt.wantBuildErr {
+			t.Errorf("%s: building the client: %v", tt.name, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		promClient = client
+		got, err := queryPrometheus(context.Background(), prom.URL, "batch-a", `x{namespace="%s"} or x{namespace="%s"}`)
+		if (err != nil) != tt.wantQueryErr {
+			t.Errorf("%s: query error %v, want error %v", tt.name, err, tt.wantQueryErr)
+		}
+		if err == nil && got["east"] != 4 {
+			t.Errorf("%s: got %v", tt.name, got)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// tokenPlacement is the cluster a placement token resolved to
+type tokenPlacement struct {
+	cluster string
+	expires time.Time
+}
+
+// placementTokens maps client-supplied tokens to clusters, so that separate requests
+// sharing a token land on the same cluster while it stays suitable
+type placementTokens struct {
+	mu     sync.Mutex
+	tokens map[string]tokenPlacement
+}
+
+// Token placements of this scout instance
+var tokens = placementTokens{tokens: make(map[string]tokenPlacement)}
+
+// get returns the cluster of an unexpired token
+func (p *placementTokens) get(token string) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	tp, ok := p.tokens[token]
+	if !ok || time.Now().After(tp.expires) {
+		return "", false
+	}
+	return tp.cluster, true
+}
+
+// set binds token to cluster for ttl, dropping expired tokens along the way
+func (p *placementTokens) set(token, cluster string, ttl time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	for t, tp := range p.tokens {
+		if now.After(tp.expires) {
+			delete(p.tokens, t)
+		}
+	}
+	p.tokens[token] = tokenPlacement{cluster: cluster, expires: now.Add(ttl)}
+}